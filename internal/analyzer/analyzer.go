@@ -2,6 +2,7 @@
 package analyzer
 
 import (
+    "sync"
     "time"
 )
 
@@ -13,6 +14,8 @@ type Analyzer struct {
     samples []int // the number of bytes received for each sample
     Throughputs []float64 // the Mbps for each sample
     ticker *time.Ticker // allows a sample to be taken every sampleDuration seconds
+    done chan struct{} // closed to stop the sampling goroutine
+    mutex sync.Mutex // bytes are added by the receiving thread while samples are taken by the sampling goroutine
 
     startTime time.Time // time the replay starts
     ReplayElapsedTime time.Duration // length of replay
@@ -37,16 +40,23 @@ func NewAnalyzer(replayLength time.Duration, numberOfSamples int) *Analyzer {
 func (a *Analyzer) Run() {
     a.startTime = time.Now()
     a.ticker = time.NewTicker(a.sampleDuration)
+    a.done = make(chan struct{})
     go func() {
         for {
-            <-a.ticker.C
-            a.createSample()
+            select {
+            case <-a.done:
+                return
+            case <-a.ticker.C:
+                a.createSample()
+            }
         }
     }()
 }
 
 // Captures a sample.
 func (a *Analyzer) createSample() {
+    a.mutex.Lock()
+    defer a.mutex.Unlock()
     a.sampleNumber += 1
     sampleTime := float64(a.sampleNumber) * a.sampleDuration.Seconds()
     a.SampleTimes = append(a.SampleTimes, sampleTime)
@@ -58,6 +68,10 @@ func (a *Analyzer) createSample() {
 func (a *Analyzer) Stop() {
     a.ReplayElapsedTime = time.Now().Sub(a.startTime)
     a.ticker.Stop()
+    close(a.done)
+
+    a.mutex.Lock()
+    defer a.mutex.Unlock()
 
     // calculate the throughputs for each sample
     a.Throughputs = []float64{}
@@ -68,18 +82,26 @@ func (a *Analyzer) Stop() {
     }
 
     // The last sampled throughput might be outlier since the intervals can be extremely small
-    a.Throughputs = a.Throughputs[:len(a.Throughputs) - 1]
-    a.SampleTimes = a.SampleTimes[:len(a.SampleTimes) - 1]
-    a.samples = a.samples[:len(a.samples) - 1]
+    if len(a.samples) > 0 {
+        a.Throughputs = a.Throughputs[:len(a.Throughputs) - 1]
+        a.SampleTimes = a.SampleTimes[:len(a.SampleTimes) - 1]
+        a.samples = a.samples[:len(a.samples) - 1]
+    }
 }
 
 // Adds number of bytes received by the client. This is the input for the analyzer.
 func (a *Analyzer) AddBytesRead(bytesRead int) {
+    a.mutex.Lock()
+    defer a.mutex.Unlock()
     a.bytesRead += bytesRead
 }
 
-// Calculates and returns the average throughput for the replay.
+// Calculates and returns the average throughput for the replay. Returns 0 if no samples were
+// taken, such as when the replay finished before the first sample.
 func (a *Analyzer) GetAverageThroughput() float64 {
+    if len(a.Throughputs) == 0 {
+        return 0
+    }
     sum := 0.0
     for _, throughput := range a.Throughputs {
         sum += throughput
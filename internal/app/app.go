@@ -2,132 +2,497 @@
 package app
 
 import (
-    "bufio"
+    "context"
     "crypto/tls"
     "crypto/x509"
+    "errors"
     "fmt"
+    "io"
     "io/ioutil"
     "math/rand"
     "os"
-    "strconv"
     "strings"
+    "text/tabwriter"
     "time"
-    "unicode"
 
     "wehe-cmdline-client/internal/config"
+    "wehe-cmdline-client/internal/importer"
+    "wehe-cmdline-client/internal/logging"
+    "wehe-cmdline-client/internal/network"
+    "wehe-cmdline-client/internal/results"
     "wehe-cmdline-client/internal/testorchestrator"
     "wehe-cmdline-client/internal/serverhandler"
     "wehe-cmdline-client/internal/testdata"
+    "wehe-cmdline-client/internal/updater"
+    "wehe-cmdline-client/internal/userstate"
 )
 
+// Returned by Run when the tests are stopped before they finish, such as when the user presses
+// Ctrl-C.
+var ErrInterrupted = testorchestrator.ErrInterrupted
+
 const (
-    charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-    userIDLength = 10
-    cmdlineUserIDFirstChar = "@"
+    mlabTestTries = 3 // number of MLab servers to try a test on before giving up
 )
 
+// where the results of the tests are written; replaced in tests to check the output
+var stdout io.Writer = os.Stdout
+
+// Runs tests on servers.
+type testRunner struct {
+    cfg config.Config // the configurations to run Wehe with
+    version string // version number of Wehe
+    userStore *userstate.Store // stores the user ID and test ID
+    servers []*serverhandler.Server // the servers to run tests on; connected to before each test if MLab is used
+    familyServers map[string][]*serverhandler.Server // the self-hosted servers for each address family that tests run over; nil if MLab is used
+    mlabLocator *serverhandler.MLabLocator // finds MLab servers; nil if MLab is not used
+    tlsConfig *tls.Config // TLS configuration containing the server cert
+    replayOrder []testorchestrator.ReplayType // the order that the replays run in
+    logger *logging.Logger // logger to write progress to
+}
+
 // Run the Wehe command line client.
+// ctx: context that stops the tests when cancelled
 // cfg: the configurations to run Wehe with
 // version: version number of Wehe
+// logger: logger to write progress to
 // Returns any errors
-func Run(cfg config.Config, version string) error {
-    // TODO: save user configs when done
-    userID, testID := readUserConfig(cfg.UserConfigFile)
-    fmt.Println(userID, testID)
+func Run(ctx context.Context, cfg config.Config, version string, logger *logging.Logger) (err error) {
+    userStore := userstate.New(cfg.UserConfigFile)
+    userState, err := userStore.Load()
+    if err != nil {
+        return fmt.Errorf("Unable to read user config file %s: %v", cfg.UserConfigFile, err)
+    }
+    logger.Debug("User ID: %s, last test ID: %d", userState.UserID, userState.TestID)
+
+    output, err := results.NewOutput(cfg.OutputFormat, stdout)
+    if err != nil {
+        return err
+    }
+    // write out the results of the tests that finished, even if a later test fails or is interrupted
+    defer func() {
+        flushErr := output.Flush()
+        if err == nil {
+            err = flushErr
+        }
+    }()
 
-    tests, err := testdata.ParseTestJSON(cfg.TestsConfigFile, cfg.TestNames)
+    tests, err := testdata.ParseTestJSON(cfg.TestsConfigFile, cfg.TestNames, logger)
     if err != nil {
         return err
     }
 
+    runner := &testRunner{
+        cfg: cfg,
+        version: version,
+        userStore: userStore,
+        logger: logger,
+    }
+
+    // each test runs once over each address family
+    ipFamilies := []string{cfg.IPFamily}
+    if cfg.DualStack {
+        ipFamilies = []string{network.IPFamilyV4, network.IPFamilyV6}
+    }
+
     // set up the servers
-    var servers []*serverhandler.Server
-    useMLab, err := serverhandler.UseMLab(cfg.ServerDisplay)
+    useMLab, err := usesMLab(cfg.Servers)
     if err != nil {
         return err
     }
     if useMLab {
-        // We currently use MLab if the hostname is wehe4.meddle.mobi or if the client is using
-        // IPv6. Steps to connect:
+        if cfg.DualStack {
+            return fmt.Errorf("Dual-stack tests cannot run on MLab (%s). List self-hosted servers in server_display instead.", serverhandler.UseMLabHostname)
+        }
+        // We use MLab if the hostname is wehe4.meddle.mobi. Steps to connect:
         // 1) GET request to MLab site to get JSON of MLab servers that can be connected to.
         // 2) Get the hostname (machine) and websocket authentication URL
         //    (wss://:4443/v0/envelope/access) of a server. The URL is valid for two minutes.
         // 3) Connect to the websocket URL and have connection open for duration of test. The
         //    websocket connection is valid for two minutes.
         // 4) Connect to the SideChannel using the hostname returned by the GET request.
-        mlabServers, err := serverhandler.GetMLabServers()
-        if err != nil {
-            return err
+        // Since the access tokens expire, the servers are located again before each test.
+        locateParams := serverhandler.MLabLocateParams{
+            Country: cfg.MLabCountry,
+            Region: cfg.MLabRegion,
+            Latitude: cfg.MLabLatitude,
+            Longitude: cfg.MLabLongitude,
+            Site: cfg.MLabSite,
+            APIKey: cfg.MLabAPIKey,
         }
-        var mlabErrors []string
-        numTries := 0 // number tries before successful connection to an MLab server
-        for _, mlabServer := range mlabServers {
-            if len(servers) == cfg.NumServers {
-                // we have the desired number of servers
-                break
+        runner.mlabLocator = serverhandler.NewMLabLocator(locateParams, cfg.MLabPrefer, cfg.MLabExclude, cfg.IPFamily, logger)
+    } else {
+        // self-hosted servers: the test runs concurrently on every server in server_display; config
+        // makes sure that there are num_servers of them
+        runner.familyServers = make(map[string][]*serverhandler.Server)
+        for _, ipFamily := range ipFamilies {
+            for _, hostname := range cfg.Servers {
+                srv, err := serverhandler.New(hostname, getPorts(cfg, hostname), ipFamily, logger)
+                if err != nil {
+                    return err
+                }
+                defer srv.CleanUp()
+                runner.familyServers[ipFamily] = append(runner.familyServers[ipFamily], srv)
             }
+        }
+    }
 
-            numTries += 1
+    // add server cert to the list of trusted CAs
+    runner.tlsConfig, err = addTrustedCACerts(cfg.ServerCertFile)
+    if err != nil {
+        return err
+    }
 
-            srv, err := serverhandler.New(mlabServer.Hostname)
-            if err != nil {
-                mlabErrors = append(mlabErrors, fmt.Sprintf("Error initializing server to %s: %v", mlabServer.Hostname, err))
-                continue
+    // determine the order that the replays will run
+    runner.replayOrder = generateReplayOrder()
+
+    // run the tests
+    for _, test := range tests {
+        var ipv4Results []testorchestrator.TestResult
+        ipv4TestID := 0
+        for _, ipFamily := range ipFamilies {
+            if ctx.Err() != nil {
+                return ErrInterrupted
+            }
+            if !useMLab {
+                runner.servers = runner.familyServers[ipFamily]
+            }
+            if cfg.DualStack {
+                logger.UI("Running %s over %s...", test.DisplayName(), familyName(ipFamily))
+            }
+            userID, testResults, err := runner.runTest(ctx, test)
+            interrupted := errors.Is(err, ErrInterrupted)
+            if err != nil && !interrupted {
+                return err
+            }
+            if cfg.DualStack && ipFamily == network.IPFamilyV4 {
+                ipv4Results = testResults
+                ipv4TestID = test.TestID
+            } else if cfg.DualStack && !interrupted {
+                testorchestrator.CompareIPFamilies(ipv4Results, ipv4TestID, testResults)
             }
-            defer srv.CleanUp()
 
-            err = srv.OpenWebsocket(mlabServer.AccessToken)
-            if err != nil {
-                mlabErrors = append(mlabErrors, fmt.Sprintf("Error connecting to %s websocket: %v", mlabServer.Hostname, err))
-                continue
+            tomography := testorchestrator.AnalyzeTomography(testResults)
+            record := results.NewRecord(userID, version, test, runner.replayOrder, testResults, tomography)
+            record.Interrupted = interrupted
+            saveErr := record.Save(cfg.ResultsUIDir, cfg.ResultsLogDir, cfg.InfoFile)
+            if saveErr != nil {
+                return fmt.Errorf("Unable to save results of %s: %v", test.DisplayName(), saveErr)
+            }
+            outputErr := output.WriteResults(test, testResults, tomography)
+            if outputErr != nil {
+                return outputErr
+            }
+            if interrupted {
+                return err
             }
-            srv.NumMLabTries = numTries
-            numTries = 0
-            servers = append(servers, srv)
         }
-        // In the app, if MLab fails to connect, we fall back to the EC2 serverhandler. However, because
-        // the command line client is mainly used to test connectivity to MLab, we return an error
-        // instead.
-        if len(servers) != cfg.NumServers {
-            return fmt.Errorf("Initialized only %d/%d MLab servers. Errors:\n%s\n", len(servers), cfg.NumServers, strings.Join(mlabErrors, "\n"))
+    }
+    return nil
+}
+
+// Gets the name of an address family to show to the user.
+// ipFamily: one of network.IPFamilyV4 or network.IPFamilyV6
+// Returns either network.IPv4 or network.IPv6
+func familyName(ipFamily string) string {
+    if ipFamily == network.IPFamilyV6 {
+        return network.IPv6
+    }
+    return network.IPv4
+}
+
+// Runs a test. If MLab is used, the test is tried again on other MLab servers if it fails, such as
+// when a side channel fails or an access token expires.
+// ctx: context that stops the test when cancelled
+// test: the test to run
+// Returns the user ID that ran the test and the results of the test, or any errors
+func (tr *testRunner) runTest(ctx context.Context, test *testdata.Test) (string, []testorchestrator.TestResult, error) {
+    tries := 1
+    if tr.mlabLocator != nil {
+        tries = mlabTestTries
+    }
+
+    var testResults []testorchestrator.TestResult
+    var userState userstate.UserState
+    var err error
+    // the confirmation replays run as a new test, so they get their own test ID
+    nextTestID := func() (int, error) {
+        confirmationState, err := tr.userStore.NextTestID()
+        if err != nil {
+            return 0, fmt.Errorf("Unable to save user config file %s: %v", tr.cfg.UserConfigFile, err)
         }
-    } else {
-        if cfg.NumServers > 1 {
-            return fmt.Errorf("Must connect to MLab (%s) to run more than one concurrent test. Currently connected to %s.\n", serverhandler.UseMLabHostname, cfg.ServerDisplay)
+        return confirmationState.TestID, nil
+    }
+    for try := 1; try <= tries; try++ {
+        if tr.mlabLocator != nil {
+            tr.servers, err = tr.mlabLocator.Connect(tr.cfg.NumServers, func(hostname string) serverhandler.Ports {
+                return getPorts(tr.cfg, hostname)
+            })
+            if err != nil {
+                return "", nil, err
+            }
         }
-        srv, err := serverhandler.New(cfg.ServerDisplay)
+
+        // save the test ID before running the test so that it is never reused
+        userState, err = tr.userStore.NextTestID()
         if err != nil {
-            return err
+            return "", nil, fmt.Errorf("Unable to save user config file %s: %v", tr.cfg.UserConfigFile, err)
         }
-        servers = append(servers, srv)
+        test.TestID = userState.TestID
+        tr.logger.UI("Running %s (test ID %d)...", test.DisplayName(), test.TestID)
+        r := testorchestrator.NewTestOrchestrator(test, tr.replayOrder, tr.cfg, tr.servers, nextTestID, tr.logger)
+        testResults, err = r.Run(ctx, userState.UserID, tr.version, tr.tlsConfig)
+        var serverErr *testorchestrator.ServerError
+        if err == nil || errors.Is(err, ErrInterrupted) || try == tries || !errors.As(err, &serverErr) {
+            // a failure that is not caused by a server would happen on other servers too
+            break
+        }
+
+        tr.logger.Warn("%s failed on %s: %v. Trying again on another MLab server (%d/%d)...", test.DisplayName(), serverErr.Server.HostName, err, try + 1, tries)
+        tr.mlabLocator.MarkFailed(serverErr.Server)
     }
+    return userState.UserID, testResults, err
+}
 
-    // add server cert to the list of trusted CAs
-    tlsConfig, err := addTrustedCACerts(cfg.ServerCertFile)
+// Show or reset the user ID and test ID.
+// cfg: the configurations to run Wehe with
+// reset: true if a new user ID should be generated and the test ID set back to 0
+// Returns any errors
+func User(cfg config.Config, reset bool) error {
+    userStore := userstate.New(cfg.UserConfigFile)
+    var userState userstate.UserState
+    var err error
+    if reset {
+        userState, err = userStore.Reset()
+    } else {
+        userState, err = userStore.Load()
+    }
     if err != nil {
         return err
     }
+    fmt.Printf("User ID: %s\nLast test ID: %d\n", userState.UserID, userState.TestID)
+    return nil
+}
 
-    // determine the order that the replays will run
-    replayOrder := generateReplayOrder()
+// List the tests that can be run.
+// cfg: the configurations to run Wehe with
+// category: only list tests in this category; empty to list all categories
+// language: only list tests shown to users of this language; empty to list all languages
+// Returns any errors
+func List(cfg config.Config, category string, language string) error {
+    allTests, err := testdata.LoadTests(cfg.TestsConfigFile)
+    if err != nil {
+        return err
+    }
+    tests, err := testdata.FilterTests(allTests, category, language)
+    if err != nil {
+        return err
+    }
+    if len(tests) == 0 {
+        fmt.Println("No tests match.")
+        return nil
+    }
 
-    // run the tests
+    totalTime := 0
+    totalSize := 0
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "NAME\tCATEGORY\tDISPLAY NAME\tLANGUAGE\tDURATION\tDATA")
     for _, test := range tests {
-        testID += 1
-        test.TestID = testID
-        r := testorchestrator.NewTestOrchestrator(test, replayOrder, cfg, servers)
-        testResults, err := r.Run(userID, version, tlsConfig)
+        language := test.Language()
+        if language == "" {
+            language = "all"
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\t~%ds\t~%d MB\n", test.Selector(), test.Category, test.Name, language, test.Time, test.Size)
+        totalTime += test.Time
+        totalSize += test.Size
+    }
+    w.Flush()
+    fmt.Printf("\n%d tests, ~%ds and ~%d MB in total.\n", len(tests), totalTime, totalSize)
+    return nil
+}
+
+// Update the tests list and replay files from the update server.
+// cfg: the configurations to run Wehe with
+// logger: logger to write progress to
+// Returns any errors
+func Update(cfg config.Config, logger *logging.Logger) error {
+    if cfg.UpdateURL == "" {
+        return fmt.Errorf("No update URL given. Set update_url in the config file or use the -u flag.")
+    }
+
+    logger.UI("Updating tests from %s...", cfg.UpdateURL)
+    report, err := updater.Run(cfg.UpdateURL, cfg.TestsConfigFile, cfg.ReplaysDir)
+    if err != nil {
+        return err
+    }
+    for _, warning := range report.Warnings {
+        logger.Warn("%s", warning)
+    }
+
+    fmt.Printf("Downloaded %d replay files.\n", report.NumReplays)
+    printTestNames("Imported tests kept", report.Kept)
+    if len(report.Added) == 0 && len(report.Removed) == 0 && len(report.Changed) == 0 {
+        fmt.Println("Tests are already up to date.")
+        return nil
+    }
+    printTestNames("Added", report.Added)
+    printTestNames("Removed", report.Removed)
+    printTestNames("Changed", report.Changed)
+    return nil
+}
+
+// Validate every replay file in the replays directory, and check that the replay files of every test
+// in the tests list exist.
+// cfg: the configurations to run Wehe with
+// Returns an error if any replay file is invalid or missing
+func Validate(cfg config.Config) error {
+    checks, err := testdata.ValidateReplays(cfg.ReplaysDir)
+    if err != nil {
+        return err
+    }
+
+    numInvalid := 0
+    replayFiles := make(map[string]bool)
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "FILE\tREPLAY NAME\tFORMAT\tSTATUS")
+    for _, check := range checks {
+        replayFiles[check.File] = true
+        format := "legacy"
+        if check.Version > 0 {
+            format = fmt.Sprintf("version %d", check.Version)
+        }
+        status := "ok"
+        if check.Err != nil {
+            numInvalid += 1
+            status = check.Err.Error()
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.File, check.ReplayName, format, status)
+    }
+    w.Flush()
+
+    // the tests list is optional; the replays directory can be checked without it
+    var missing []string
+    tests, err := testdata.LoadTests(cfg.TestsConfigFile)
+    if err != nil {
+        fmt.Printf("\nUnable to check the replay files of the tests in %s: %v\n", cfg.TestsConfigFile, err)
+    }
+    for _, test := range tests {
+        for _, replayFile := range []string{test.DataFile, test.RandomDataFile} {
+            if !replayFiles[replayFile] {
+                missing = append(missing, fmt.Sprintf("%s (%s)", replayFile, test.Selector()))
+            }
+        }
+    }
+    if len(missing) > 0 {
+        fmt.Println()
+        printTestNames("Missing replay files", missing)
+    }
+
+    fmt.Printf("\n%d replay files checked in %s, %d invalid, %d missing.\n", len(checks), cfg.ReplaysDir, numInvalid, len(missing))
+    if numInvalid > 0 || len(missing) > 0 {
+        return fmt.Errorf("The replays directory %s has invalid or missing replay files.", cfg.ReplaysDir)
+    }
+    return nil
+}
+
+// Import the original and random replays of an app from a packet capture into the replays
+// directory, and optionally add a test that runs them to the tests list.
+// cfg: the configurations to run Wehe with
+// options: what to import; the replays directory is taken from cfg
+// addTest: true if a test for the replays should be added to the tests list
+// displayName: name of the test that is displayed to the user; the name of the app if empty
+// category: type of app the test replays; only used if addTest is true
+// logger: logger to write warnings to
+// Returns any errors
+func Import(cfg config.Config, options importer.Options, addTest bool, displayName string, category string, logger *logging.Logger) error {
+    // check the test before importing so that nothing is written if the category is invalid or the
+    // test would replace one that was not imported
+    if addTest {
+        test, err := importer.Report{Name: options.Name}.NewTest(displayName, category)
         if err != nil {
             return err
         }
-        for _, result := range testResults {
-            fmt.Printf("Test result for %s:\n\tStatus: %s\n\tOriginal Throughput: %f Mbps\n\tRandom Throughput: %f Mbps\n\tServer: %s\n\tArea Threshold: %f\n\tKS2 P-Value Threshold: %f\n",
-                test.Name, result.Result, result.KS2Result.OriginalAvgThroughput, result.KS2Result.RandomAvgThroughput, result.ServerHostname, result.AreaThreshold, result.KS2PValueThreshold)
+        err = importer.CheckTest(cfg.TestsConfigFile, test, options.Overwrite)
+        if err != nil {
+            return err
         }
     }
+
+    options.ReplaysDir = cfg.ReplaysDir
+    report, err := importer.Import(options)
+    if err != nil {
+        return err
+    }
+    for _, warning := range report.Warnings {
+        logger.Warn("%s", warning)
+    }
+
+    fmt.Printf("Imported %s replay from %s:\n", strings.ToUpper(report.Protocol), options.CaptureFile)
+    printTestNames("Flows", report.Flows)
+    fmt.Printf("%d packets, %d bytes from the client, %d bytes from the server, %.1fs long.\n", report.NumPackets, report.ClientBytes, report.ServerBytes, report.Duration.Seconds())
+    fmt.Printf("Wrote %s (%s) and %s (%s) to %s.\n", report.ReplayFile, report.ReplayName, report.RandomReplayFile, report.RandomReplayName, cfg.ReplaysDir)
+    if !addTest {
+        return nil
+    }
+
+    test, err := report.NewTest(displayName, category)
+    if err != nil {
+        return err
+    }
+    replaced, err := importer.AddTest(cfg.TestsConfigFile, test, options.Overwrite)
+    if err != nil {
+        return err
+    }
+    if replaced {
+        fmt.Printf("Replaced test %s in %s.\n", test.Selector(), cfg.TestsConfigFile)
+    } else {
+        fmt.Printf("Added test %s to %s.\n", test.Selector(), cfg.TestsConfigFile)
+    }
     return nil
 }
 
+// Prints a list of test names under a heading.
+// heading: the heading of the list
+// testNames: the test names to print; nothing is printed if the list is empty
+func printTestNames(heading string, testNames []string) {
+    if len(testNames) == 0 {
+        return
+    }
+    fmt.Printf("%s (%d):\n", heading, len(testNames))
+    for _, testName := range testNames {
+        fmt.Printf("\t%s\n", testName)
+    }
+}
+
+// Determines if MLab servers are used to run the tests. MLab is used only if it is the sole server
+// listed.
+// hostnames: the servers listed in the config
+// Returns true if MLab is used, or an error if MLab is listed with other servers
+func usesMLab(hostnames []string) (bool, error) {
+    if len(hostnames) == 1 {
+        return serverhandler.UseMLab(hostnames[0]), nil
+    }
+    for _, hostname := range hostnames {
+        if hostname == serverhandler.UseMLabHostname {
+            return false, fmt.Errorf("%s cannot be listed with other servers. Use num_servers to run tests on more than one MLab server.", serverhandler.UseMLabHostname)
+        }
+    }
+    return false, nil
+}
+
+// Gets the ports that a server listens on from the config.
+// cfg: the configurations to run Wehe with
+// hostname: hostname of the server
+// Returns the ports of the server
+func getPorts(cfg config.Config, hostname string) serverhandler.Ports {
+    serverPorts := cfg.GetServerPorts(hostname)
+    return serverhandler.Ports{
+        SideChannel: serverPorts.SideChannelPort,
+        Results: serverPorts.ResultPort,
+        Replay: serverPorts.ReplayPorts,
+    }
+}
+
 // Add the server cert to list of trusted CAs.
 // caCertFilename: file path to the server cert
 // Returns the TLS config that can be used for TLS connections, or any errors
@@ -154,72 +519,3 @@ func generateReplayOrder() []testorchestrator.ReplayType {
         return []testorchestrator.ReplayType{testorchestrator.Random, testorchestrator.Original}
     }
 }
-
-//TODO: if above gets too long, move below to app.utils file
-
-
-// Retrieves the user ID and test ID from a file. Creates new ones if file cannot be opened or
-// config is invalid.
-// userConfigFile: path of the file containing user ID and test ID
-// Returns the user ID and test ID
-func readUserConfig(userConfigFile string) (string, int) {
-    file, err := os.Open(userConfigFile)
-    if err != nil {
-        return generateUserID(userIDLength), 0
-    }
-    fileScanner := bufio.NewScanner(file)
-    fileScanner.Split(bufio.ScanLines)
-
-    // first line of file is user ID
-    fileScanner.Scan()
-    userID := fileScanner.Text()
-    // second line of file is test ID
-    fileScanner.Scan()
-    testID, err := strconv.Atoi(fileScanner.Text())
-    if err != nil || !isUserIDValid(userID) || !isTestIDValid(testID) {
-        userID = generateUserID(userIDLength)
-        testID = 0
-    }
-
-    file.Close()
-
-    return userID, testID
-}
-
-// Checks if a user ID is valid. A user ID is valid if it contains ten alphanumeric characters.
-// However, unlike the iOS and Android apps, the first character is a '@' for the command line
-// client.
-// userID: the user ID to validate
-// Returns true if user ID is valid; false otherwise
-func isUserIDValid(userID string) bool {
-    if len(userID) == userIDLength && string(userID[0]) == cmdlineUserIDFirstChar {
-        for i := 1; i < userIDLength; i++ {
-            if !unicode.IsLetter(rune(userID[i])) && !unicode.IsNumber(rune(userID[i])) {
-                return false
-            }
-        }
-        return true
-    }
-    return false
-}
-
-// Checks if a test ID is valid. A test ID is valid if it is a non-negative integer.
-// testID: the test ID to validate
-// Returns true if test ID is valid; false otherwise
-func isTestIDValid(testID int) bool {
-    return testID > -1
-}
-
-// Generates a user ID of random alphanumeric characters, with the first character as a '@'.
-// length: total length of the user ID, including the '@'.
-// Returns the user ID
-func generateUserID(length int) string {
-	rand.Seed(time.Now().UnixNano())
-
-	result := make([]byte, length - 1)
-	for i := range result {
-		result[i] = charset[rand.Intn(len(charset))]
-	}
-
-	return cmdlineUserIDFirstChar + string(result)
-}
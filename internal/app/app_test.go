@@ -0,0 +1,130 @@
+package app
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "wehe-cmdline-client/internal/config"
+    "wehe-cmdline-client/internal/fakeserver"
+    "wehe-cmdline-client/internal/logging"
+    "wehe-cmdline-client/internal/network"
+    "wehe-cmdline-client/internal/results"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const testCSPair = "010.000.000.001.50000-010.000.000.002.00080"
+
+// Writes a replay file with TCP packets 100 ms apart that each get a 10000 byte response.
+func writeReplay(t *testing.T, dir string, filename string, replayName string) testdata.ReplayInfo {
+    var packets []string
+    for i := 0; i < 3; i++ {
+        packets = append(packets, fmt.Sprintf(`{"c_s_pair": "%s", "timestamp": %.1f, "payload": "%x", "response_len": 10000, "response_hash": null}`, testCSPair, float64(i) * 0.1, fmt.Sprintf("GET /%d", i)))
+    }
+    replay := fmt.Sprintf(`[[%s], [], ["%s"], "%s"]`, strings.Join(packets, ", "), testCSPair, replayName)
+    replayFile := filepath.Join(dir, filename)
+    err := os.WriteFile(replayFile, []byte(replay), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    replayInfo, err := testdata.ParseReplayJSON(replayFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return replayInfo
+}
+
+// Starts a fake server and makes a config that runs the tests in the tests list on it.
+func setupRun(t *testing.T, tests []testdata.Test, outputFormat string) config.Config {
+    dir := t.TempDir()
+    replaysDir := filepath.Join(dir, "replays")
+    err := os.MkdirAll(replaysDir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    original := writeReplay(t, replaysDir, "Video.json", "Video-01012024")
+    random := writeReplay(t, replaysDir, "VideoRandom.json", "VideoRandom-01012024")
+    fake, err := fakeserver.Start(fakeserver.Config{
+        Replays: []testdata.ReplayInfo{original, random},
+        KS2Result: &testdata.KS2Result{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(fake.Close)
+
+    cfg := config.Config{
+        OutputFormat: outputFormat,
+        ServerDisplay: fakeserver.DefaultListenIP,
+        Servers: []string{fakeserver.DefaultListenIP},
+        ServerPorts: map[string]config.ServerPorts{},
+        IPFamily: network.IPFamilyAuto,
+        NumServers: 1,
+        UseDefaultThresholds: true,
+        AreaThreshold: 50,
+        KS2PValueThreshold: 1,
+        UserConfigFile: filepath.Join(dir, "user_info"),
+        TestsConfigFile: filepath.Join(dir, "tests_list.json"),
+        ServerCertFile: filepath.Join(dir, "ca.pem"),
+        ReplaysDir: replaysDir,
+        ResultsUIDir: filepath.Join(dir, "ui"),
+        ResultsLogDir: filepath.Join(dir, "logs"),
+        InfoFile: filepath.Join(dir, "info.txt"),
+    }
+    ports := fake.Ports()
+    cfg.ServerPorts[fakeserver.DefaultListenIP] = config.ServerPorts{SideChannelPort: ports.SideChannel, ResultPort: ports.Results, ReplayPorts: ports.Replay}
+    for _, test := range tests {
+        cfg.TestNames = append(cfg.TestNames, test.Selector())
+    }
+    for _, resultsDir := range []string{cfg.ResultsUIDir, cfg.ResultsLogDir} {
+        err = os.MkdirAll(resultsDir, 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    err = os.WriteFile(cfg.ServerCertFile, fake.CACertPEM(), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := json.Marshal(tests)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(cfg.TestsConfigFile, data, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return cfg
+}
+
+func TestRunFailsPartway(t *testing.T) {
+    videoTest := testdata.Test{Name: "Video", Time: 2, Image: "video", DataFile: "Video.json", RandomDataFile: "VideoRandom.json", Category: testdata.CategoryVideo}
+    // the second test cannot run because its replays are missing
+    musicTest := testdata.Test{Name: "Music", Time: 2, Image: "music", DataFile: "Music.json", RandomDataFile: "MusicRandom.json", Category: testdata.CategoryMusic}
+    cfg := setupRun(t, []testdata.Test{videoTest, musicTest}, results.JSONOutput)
+
+    var out bytes.Buffer
+    stdout = &out
+    defer func() { stdout = os.Stdout }()
+
+    err := Run(context.Background(), cfg, "4.0", logging.New(logging.Info, io.Discard))
+    if err == nil || !strings.Contains(err.Error(), "Music") {
+        t.Errorf("Expected error about the missing replay, got %v", err)
+    }
+
+    // the results of the test that finished are still written
+    var records []results.OutputRecord
+    err = json.Unmarshal(out.Bytes(), &records)
+    if err != nil {
+        t.Fatalf("Unexpected output %q: %v", out.String(), err)
+    }
+    if len(records) != 1 || records[0].TestSelector != "video" {
+        t.Errorf("Expected the results of video, got %+v", records)
+    }
+}
@@ -0,0 +1,111 @@
+// Collects information about the client's host and network, which is sent to the server as
+// mobile stats so that results can be grouped by access technology.
+package clientinfo
+
+import (
+    "encoding/json"
+    "io/fs"
+    "net"
+    "os"
+    "runtime"
+    "sort"
+)
+
+const (
+    WiredNetwork = "wired"
+    WifiNetwork = "wifi"
+    CellularNetwork = "cellular"
+    UnknownNetwork = "unknown"
+
+    clientType = "cmdline" // lets the server tell command line stats apart from the mobile apps' stats
+)
+
+// Information about the client that is sent to the server.
+type ClientInfo struct {
+    ClientType string `json:"clientType"` // always "cmdline"
+    OS OSInfo `json:"os"` // the operating system of the client
+    NetworkType string `json:"networkType"` // type of the interface used to reach the internet: wired, wifi, cellular, or unknown
+    DefaultInterface string `json:"defaultInterface"` // name of the interface that the default route uses; empty if unknown
+    Interfaces []InterfaceInfo `json:"interfaces"` // the network interfaces of the client that are up, excluding loopback
+    ExtraString string `json:"extraString"` // the extra_string value in the config file
+}
+
+// Information about the operating system of the client.
+type OSInfo struct {
+    Name string `json:"name"` // name of the OS or Linux distribution
+    Kernel string `json:"kernel"` // kernel release; empty if unknown
+    Arch string `json:"arch"` // CPU architecture
+}
+
+// Information about a network interface of the client.
+type InterfaceInfo struct {
+    Name string `json:"name"` // name of the interface
+    Type string `json:"type"` // wired, wifi, cellular, or unknown
+    Addresses []string `json:"addresses"` // local IP addresses of the interface, in CIDR notation
+}
+
+// Collects information about the client from the host. Any information that cannot be found is left
+// empty or marked as unknown.
+// extraString: the extra_string value in the config file
+// Returns the information about the client
+func Collect(extraString string) ClientInfo {
+    return CollectFS(os.DirFS("/"), extraString)
+}
+
+// Collects information about the client, reading the OS, route, and interface files from a file
+// system instead of the host's root. The network interfaces are still those of the host.
+// fsys: the file system that is read in place of the host's root directory
+// extraString: the extra_string value in the config file
+// Returns the information about the client
+func CollectFS(fsys fs.FS, extraString string) ClientInfo {
+    info := ClientInfo{
+        ClientType: clientType,
+        OS: OSInfo{
+            Name: getOSName(fsys),
+            Kernel: getKernelRelease(fsys),
+            Arch: runtime.GOARCH,
+        },
+        NetworkType: UnknownNetwork,
+        DefaultInterface: getDefaultRouteInterface(fsys),
+        Interfaces: []InterfaceInfo{},
+        ExtraString: extraString,
+    }
+
+    ifaces, err := net.Interfaces()
+    if err != nil {
+        return info
+    }
+    for _, iface := range ifaces {
+        if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+            continue
+        }
+        ifaceInfo := InterfaceInfo{
+            Name: iface.Name,
+            Type: getInterfaceType(fsys, iface.Name),
+            Addresses: []string{},
+        }
+        addrs, err := iface.Addrs()
+        if err == nil {
+            for _, addr := range addrs {
+                ifaceInfo.Addresses = append(ifaceInfo.Addresses, addr.String())
+            }
+        }
+        sort.Strings(ifaceInfo.Addresses)
+        info.Interfaces = append(info.Interfaces, ifaceInfo)
+
+        if iface.Name == info.DefaultInterface {
+            info.NetworkType = ifaceInfo.Type
+        }
+    }
+    return info
+}
+
+// Converts the information about the client to the JSON string that is sent to the server.
+// Returns the JSON string or any errors
+func (info ClientInfo) JSON() (string, error) {
+    data, err := json.Marshal(info)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
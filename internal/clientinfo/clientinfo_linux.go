@@ -0,0 +1,201 @@
+package clientinfo
+
+import (
+    "bufio"
+    "io/fs"
+    "path"
+    "strconv"
+    "strings"
+)
+
+// paths are relative to the root of the file system that host information is read from
+const (
+    osReleaseFile = "etc/os-release"
+    kernelReleaseFile = "proc/sys/kernel/osrelease"
+    ipv4RouteFile = "proc/net/route"
+    ipv6RouteFile = "proc/net/ipv6_route"
+    sysClassNetDir = "sys/class/net"
+
+    // interface hardware types from include/uapi/linux/if_arp.h
+    arphrdEther = 1
+    arphrdPPP = 512
+    arphrdRawIP = 519
+)
+
+// Gets the name of the Linux distribution.
+// fsys: the file system to read host information from
+// Returns PRETTY_NAME from /etc/os-release, or "linux" if it cannot be read
+func getOSName(fsys fs.FS) string {
+    file, err := fsys.Open(osReleaseFile)
+    if err != nil {
+        return "linux"
+    }
+    defer file.Close()
+
+    fileScanner := bufio.NewScanner(file)
+    for fileScanner.Scan() {
+        key, val, found := strings.Cut(fileScanner.Text(), "=")
+        if found && key == "PRETTY_NAME" {
+            return strings.Trim(val, "\"'")
+        }
+    }
+    return "linux"
+}
+
+// Gets the kernel release.
+// fsys: the file system to read host information from
+// Returns the kernel release, or an empty string if it cannot be read
+func getKernelRelease(fsys fs.FS) string {
+    data, err := fs.ReadFile(fsys, kernelReleaseFile)
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(data))
+}
+
+// Gets the interface that the default route uses. The IPv4 default route with the lowest metric is
+// preferred; the IPv6 default route is used if there is no IPv4 one.
+// fsys: the file system to read host information from
+// Returns the name of the interface, or an empty string if there is no default route
+func getDefaultRouteInterface(fsys fs.FS) string {
+    iface := getIPv4DefaultRouteInterface(fsys)
+    if iface != "" {
+        return iface
+    }
+    return getIPv6DefaultRouteInterface(fsys)
+}
+
+// Gets the interface that the IPv4 default route uses from /proc/net/route.
+// fsys: the file system to read host information from
+// Returns the name of the interface, or an empty string if there is no default route
+func getIPv4DefaultRouteInterface(fsys fs.FS) string {
+    file, err := fsys.Open(ipv4RouteFile)
+    if err != nil {
+        return ""
+    }
+    defer file.Close()
+
+    // columns: Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+    defaultIface := ""
+    lowestMetric := -1
+    fileScanner := bufio.NewScanner(file)
+    fileScanner.Scan() // skip header
+    for fileScanner.Scan() {
+        fields := strings.Fields(fileScanner.Text())
+        if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+            continue
+        }
+        metric, err := strconv.Atoi(fields[6])
+        if err != nil {
+            continue
+        }
+        if lowestMetric == -1 || metric < lowestMetric {
+            defaultIface = fields[0]
+            lowestMetric = metric
+        }
+    }
+    return defaultIface
+}
+
+// Gets the interface that the IPv6 default route uses from /proc/net/ipv6_route.
+// fsys: the file system to read host information from
+// Returns the name of the interface, or an empty string if there is no default route
+func getIPv6DefaultRouteInterface(fsys fs.FS) string {
+    file, err := fsys.Open(ipv6RouteFile)
+    if err != nil {
+        return ""
+    }
+    defer file.Close()
+
+    // columns: Destination PrefixLen Source SourcePrefixLen NextHop Metric RefCnt Use Flags Iface
+    defaultIface := ""
+    lowestMetric := int64(-1)
+    fileScanner := bufio.NewScanner(file)
+    for fileScanner.Scan() {
+        fields := strings.Fields(fileScanner.Text())
+        if len(fields) < 10 || fields[1] != "00" || strings.Trim(fields[0], "0") != "" || fields[9] == "lo" {
+            continue
+        }
+        metric, err := strconv.ParseInt(fields[5], 16, 64)
+        if err != nil {
+            continue
+        }
+        if lowestMetric == -1 || metric < lowestMetric {
+            defaultIface = fields[9]
+            lowestMetric = metric
+        }
+    }
+    return defaultIface
+}
+
+// Determines the type of a network interface from sysfs.
+// fsys: the file system to read host information from
+// iface: name of the interface
+// Returns wired, wifi, cellular, or unknown
+func getInterfaceType(fsys fs.FS, iface string) string {
+    ifaceDir := path.Join(sysClassNetDir, iface)
+
+    if fileExists(fsys, path.Join(ifaceDir, "wireless")) || fileExists(fsys, path.Join(ifaceDir, "phy80211")) {
+        return WifiNetwork
+    }
+
+    devType := getUeventValue(fsys, path.Join(ifaceDir, "uevent"), "DEVTYPE")
+    switch devType {
+    case "wlan":
+        return WifiNetwork
+    case "wwan":
+        return CellularNetwork
+    }
+
+    data, err := fs.ReadFile(fsys, path.Join(ifaceDir, "type"))
+    if err != nil {
+        return UnknownNetwork
+    }
+    hardwareType, err := strconv.Atoi(strings.TrimSpace(string(data)))
+    if err != nil {
+        return UnknownNetwork
+    }
+    switch hardwareType {
+    case arphrdEther:
+        // virtual interfaces (bridges, veth, docker, etc.) do not have a device
+        if devType == "" && fileExists(fsys, path.Join(ifaceDir, "device")) {
+            return WiredNetwork
+        }
+        return UnknownNetwork
+    case arphrdPPP, arphrdRawIP:
+        return CellularNetwork
+    default:
+        return UnknownNetwork
+    }
+}
+
+// Gets the value of a key in a uevent file.
+// fsys: the file system to read host information from
+// ueventFile: path to the uevent file
+// key: the key to look for
+// Returns the value, or an empty string if the key is not found
+func getUeventValue(fsys fs.FS, ueventFile string, key string) string {
+    file, err := fsys.Open(ueventFile)
+    if err != nil {
+        return ""
+    }
+    defer file.Close()
+
+    fileScanner := bufio.NewScanner(file)
+    for fileScanner.Scan() {
+        k, v, found := strings.Cut(fileScanner.Text(), "=")
+        if found && k == key {
+            return v
+        }
+    }
+    return ""
+}
+
+// Checks if a file exists.
+// fsys: the file system to look in
+// name: path to the file
+// Returns true if the file exists; false otherwise
+func fileExists(fsys fs.FS, name string) bool {
+    _, err := fs.Stat(fsys, name)
+    return err == nil
+}
@@ -0,0 +1,177 @@
+package clientinfo
+
+import (
+    "fmt"
+    "testing"
+    "testing/fstest"
+)
+
+// Makes a file for a fake file system.
+func file(data string) *fstest.MapFile {
+    return &fstest.MapFile{Data: []byte(data)}
+}
+
+func TestGetOSName(t *testing.T) {
+    tests := []struct {
+        name string
+        osRelease *fstest.MapFile
+        expected string
+    }{
+        {"quoted", file("NAME=\"Ubuntu\"\nPRETTY_NAME=\"Ubuntu 22.04.3 LTS\"\nID=ubuntu\n"), "Ubuntu 22.04.3 LTS"},
+        {"single quoted", file("PRETTY_NAME='Arch Linux'\n"), "Arch Linux"},
+        {"no pretty name", file("NAME=Alpine\nmalformed line\n"), "linux"},
+        {"missing", nil, "linux"},
+    }
+    for _, test := range tests {
+        fsys := fstest.MapFS{}
+        if test.osRelease != nil {
+            fsys[osReleaseFile] = test.osRelease
+        }
+        result := getOSName(fsys)
+        if result != test.expected {
+            t.Errorf("%s: expected '%s', got '%s'", test.name, test.expected, result)
+        }
+    }
+}
+
+func TestGetKernelRelease(t *testing.T) {
+    result := getKernelRelease(fstest.MapFS{kernelReleaseFile: file("6.5.0-14-generic\n")})
+    if result != "6.5.0-14-generic" {
+        t.Errorf("Expected '6.5.0-14-generic', got '%s'", result)
+    }
+    result = getKernelRelease(fstest.MapFS{})
+    if result != "" {
+        t.Errorf("Expected no kernel release, got '%s'", result)
+    }
+}
+
+const (
+    ipv4RouteHeader = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"
+    ipv6DefaultRoute = "00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 %s 00000001 00000000 00000003 %s\n"
+)
+
+func TestGetIPv4DefaultRouteInterface(t *testing.T) {
+    tests := []struct {
+        name string
+        routes string
+        expected string
+    }{
+        {"lowest metric", ipv4RouteHeader +
+            "wlan0\t00000000\t0101A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+            "eth0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+            "eth0\t0001A8C0\t00000000\t0001\t0\t0\t100\t00FFFFFF\t0\t0\t0\n", "eth0"},
+        {"malformed lines", ipv4RouteHeader +
+            "eth0\t00000000\n" +
+            "wwan0\t00000000\t00000000\t0001\t0\t0\tabc\t00000000\t0\t0\t0\n" +
+            "wlan0\t00000000\t0101A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n", "wlan0"},
+        {"no default route", ipv4RouteHeader +
+            "eth0\t0001A8C0\t00000000\t0001\t0\t0\t100\t00FFFFFF\t0\t0\t0\n", ""},
+        {"header only", ipv4RouteHeader, ""},
+    }
+    for _, test := range tests {
+        result := getIPv4DefaultRouteInterface(fstest.MapFS{ipv4RouteFile: file(test.routes)})
+        if result != test.expected {
+            t.Errorf("%s: expected '%s', got '%s'", test.name, test.expected, result)
+        }
+    }
+
+    result := getIPv4DefaultRouteInterface(fstest.MapFS{})
+    if result != "" {
+        t.Errorf("Missing file: expected no interface, got '%s'", result)
+    }
+}
+
+func TestGetIPv6DefaultRouteInterface(t *testing.T) {
+    tests := []struct {
+        name string
+        routes string
+        expected string
+    }{
+        {"lowest metric", fmt.Sprintf(ipv6DefaultRoute, "00000400", "wlan0") + fmt.Sprintf(ipv6DefaultRoute, "00000100", "eth0"), "eth0"},
+        {"loopback skipped", fmt.Sprintf(ipv6DefaultRoute, "00000001", "lo") + fmt.Sprintf(ipv6DefaultRoute, "00000400", "wlan0"), "wlan0"},
+        {"malformed lines", "00000000000000000000000000000000 00 eth0\n" + fmt.Sprintf(ipv6DefaultRoute, "zzzzzzzz", "eth0") + fmt.Sprintf(ipv6DefaultRoute, "00000400", "wwan0"), "wwan0"},
+        {"no default route", "fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001 eth0\n", ""},
+    }
+    for _, test := range tests {
+        result := getIPv6DefaultRouteInterface(fstest.MapFS{ipv6RouteFile: file(test.routes)})
+        if result != test.expected {
+            t.Errorf("%s: expected '%s', got '%s'", test.name, test.expected, result)
+        }
+    }
+
+    result := getIPv6DefaultRouteInterface(fstest.MapFS{})
+    if result != "" {
+        t.Errorf("Missing file: expected no interface, got '%s'", result)
+    }
+}
+
+func TestGetDefaultRouteInterface(t *testing.T) {
+    ipv6Routes := file(fmt.Sprintf(ipv6DefaultRoute, "00000100", "eth1"))
+    ipv4Routes := file(ipv4RouteHeader + "eth0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n")
+
+    // the IPv4 default route is preferred
+    result := getDefaultRouteInterface(fstest.MapFS{ipv4RouteFile: ipv4Routes, ipv6RouteFile: ipv6Routes})
+    if result != "eth0" {
+        t.Errorf("Expected 'eth0', got '%s'", result)
+    }
+    result = getDefaultRouteInterface(fstest.MapFS{ipv6RouteFile: ipv6Routes})
+    if result != "eth1" {
+        t.Errorf("Expected 'eth1', got '%s'", result)
+    }
+}
+
+func TestGetInterfaceType(t *testing.T) {
+    fsys := fstest.MapFS{
+        "sys/class/net/wlan0/wireless/.keep": file(""),
+        "sys/class/net/wlan0/type": file("1\n"),
+        "sys/class/net/wlp2s0/uevent": file("DEVTYPE=wlan\nINTERFACE=wlp2s0\n"),
+        "sys/class/net/wwan0/uevent": file("DEVTYPE=wwan\n"),
+        "sys/class/net/wwan0/type": file("1\n"),
+        "sys/class/net/eth0/type": file("1\n"),
+        "sys/class/net/eth0/device/vendor": file("0x8086\n"),
+        "sys/class/net/eth0/uevent": file("INTERFACE=eth0\nIFINDEX=2\n"),
+        "sys/class/net/docker0/type": file("1\n"),
+        "sys/class/net/docker0/uevent": file("DEVTYPE=bridge\n"),
+        "sys/class/net/veth0/type": file("1\n"),
+        "sys/class/net/ppp0/type": file("512\n"),
+        "sys/class/net/rmnet0/type": file("519\n"),
+        "sys/class/net/tun0/type": file("65534\n"),
+        "sys/class/net/bad0/type": file("ether\n"),
+        "sys/class/net/bad0/uevent": file("malformed\n"),
+    }
+    tests := map[string]string{
+        "wlan0": WifiNetwork,
+        "wlp2s0": WifiNetwork,
+        "wwan0": CellularNetwork,
+        "eth0": WiredNetwork,
+        "docker0": UnknownNetwork,
+        "veth0": UnknownNetwork,
+        "ppp0": CellularNetwork,
+        "rmnet0": CellularNetwork,
+        "tun0": UnknownNetwork,
+        "bad0": UnknownNetwork,
+        "missing0": UnknownNetwork,
+    }
+    for iface, expected := range tests {
+        result := getInterfaceType(fsys, iface)
+        if result != expected {
+            t.Errorf("%s: expected '%s', got '%s'", iface, expected, result)
+        }
+    }
+}
+
+func TestCollectFS(t *testing.T) {
+    fsys := fstest.MapFS{
+        osReleaseFile: file("PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\n"),
+        kernelReleaseFile: file("6.1.0-18-amd64\n"),
+        ipv4RouteFile: file(ipv4RouteHeader + "eth9\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n"),
+    }
+    info := CollectFS(fsys, "lab")
+    if info.OS.Name != "Debian GNU/Linux 12 (bookworm)" || info.OS.Kernel != "6.1.0-18-amd64" || info.DefaultInterface != "eth9" || info.ExtraString != "lab" {
+        t.Errorf("Unexpected client info: %+v", info)
+    }
+    // the interfaces are the host's, so none of them uses the default route in the fixture
+    if info.NetworkType != UnknownNetwork {
+        t.Errorf("Expected %s, got %s", UnknownNetwork, info.NetworkType)
+    }
+}
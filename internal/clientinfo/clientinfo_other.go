@@ -0,0 +1,29 @@
+//go:build !linux
+
+package clientinfo
+
+import (
+    "io/fs"
+    "runtime"
+)
+
+// Only the name of the OS is known on platforms other than Linux.
+// Returns the name of the OS
+func getOSName(fsys fs.FS) string {
+    return runtime.GOOS
+}
+
+// Returns an empty string since the kernel release is only known on Linux
+func getKernelRelease(fsys fs.FS) string {
+    return ""
+}
+
+// Returns an empty string since the default route is only known on Linux
+func getDefaultRouteInterface(fsys fs.FS) string {
+    return ""
+}
+
+// Returns unknown since interface types are only known on Linux
+func getInterfaceType(fsys fs.FS, iface string) string {
+    return UnknownNetwork
+}
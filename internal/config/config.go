@@ -3,9 +3,14 @@ package config
 
 import (
     "fmt"
+    "strconv"
     "strings"
 
     "gopkg.in/ini.v1"
+
+    "wehe-cmdline-client/internal/logging"
+    "wehe-cmdline-client/internal/network"
+    "wehe-cmdline-client/internal/serverhandler"
 )
 
 // Configurations for the Wehe command line client
@@ -13,9 +18,25 @@ import (
 type Config struct {
     // args from command line
     TestNames []string
+    OutputFormat string
 
     // args from ini config file
     ServerDisplay string
+    Servers []string // hostnames of the servers to run tests on, listed in server_display
+    SideChannelPort int
+    ResultPort int
+    ReplayPorts map[int]int // maps the server port in a replay file to the port the server listens on
+    ServerPorts map[string]ServerPorts // per-server port overrides, keyed by hostname
+    IPFamily string // the address family to connect to the servers with: auto, v4, or v6
+    DualStack bool // true if each test is run twice, once over IPv4 and once over IPv6
+    MLabPrefer []string // MLab sites or metros to try first
+    MLabExclude []string // MLab sites or metros to never use
+    MLabCountry string // ISO 3166-1 country code to locate MLab servers near, instead of the client's geolocation
+    MLabRegion string // ISO 3166-2 region code to locate MLab servers near (ex. US-NY)
+    MLabLatitude string // latitude to locate MLab servers near; set with MLabLongitude
+    MLabLongitude string // longitude to locate MLab servers near; set with MLabLatitude
+    MLabSite string // MLab site to get servers from (ex. lga03)
+    MLabAPIKey string // API key for priority access to the MLab locate API
     NumServers int
     ExtraString string
     SendMobileStats bool
@@ -32,8 +53,22 @@ type Config struct {
     ResultsUIDir string
     ResultsLogDir string
     InfoFile string
+    UpdateURL string
+}
+
+// The ports that one server listens on. Set in a [server:<hostname>] section of the config file.
+type ServerPorts struct {
+    SideChannelPort int
+    ResultPort int
+    ReplayPorts map[int]int
 }
 
+const (
+    serverSectionPrefix = "server:" // prefix of the sections that override the ports of one server
+    minPort = 1
+    maxPort = 65535
+)
+
 // Creates a new Config object.
 // testNames: names of the tests to run, delimitated by commas
 // configPath: path to the .ini config file
@@ -53,10 +88,23 @@ func New(testNames *string, configPath *string) (Config, error) {
         return config, fmt.Errorf("No test names entered.")
     }
 
+    // process configs from the configuration file
+    config, err := Load(*configPath)
+    if err != nil {
+        return config, err
+    }
     config.TestNames = nonEmptyStrings
 
-    // process configs from the configuration file
-    configFile, err := ini.Load(*configPath)
+    return config, nil
+}
+
+// Loads the configurations from the .ini config file only. Used by subcommands that do not run
+// tests.
+// configPath: path to the .ini config file
+// Returns a configuration struct or an error
+func Load(configPath string) (Config, error) {
+    config := Config{}
+    configFile, err := ini.Load(configPath)
     if err != nil {
         return config, err
     }
@@ -67,11 +115,64 @@ func New(testNames *string, configPath *string) (Config, error) {
         return config, err
     }
 
+    config.Servers, err = parseServers(config.ServerDisplay)
+    if err != nil {
+        return config, err
+    }
+
+    config.SideChannelPort, err = getOptionalInt(defaultSection, "side_channel_port", network.DefaultSideChannelPort, minPort, maxPort)
+    if err != nil {
+        return config, err
+    }
+
+    config.ResultPort, err = getOptionalInt(defaultSection, "result_port", serverhandler.DefaultResultsPort, minPort, maxPort)
+    if err != nil {
+        return config, err
+    }
+
+    config.ReplayPorts, err = ParsePortMap(getOptionalString(defaultSection, "replay_ports", ""))
+    if err != nil {
+        return config, fmt.Errorf("%s in replay_ports key", err)
+    }
+
+    config.ServerPorts, err = getServerPorts(configFile, config)
+    if err != nil {
+        return config, err
+    }
+
+    config.IPFamily = getOptionalString(defaultSection, "ip_family", network.IPFamilyAuto)
+    err = network.CheckIPFamily(config.IPFamily)
+    if err != nil {
+        return config, fmt.Errorf("%s in ip_family key", err)
+    }
+
+    config.DualStack, err = getOptionalBool(defaultSection, "dual_stack", false)
+    if err != nil {
+        return config, err
+    }
+
+    config.MLabPrefer = getOptionalList(defaultSection, "mlab_prefer")
+    config.MLabExclude = getOptionalList(defaultSection, "mlab_exclude")
+    config.MLabCountry = getOptionalString(defaultSection, "mlab_country", "")
+    config.MLabRegion = getOptionalString(defaultSection, "mlab_region", "")
+    config.MLabLatitude = getOptionalString(defaultSection, "mlab_lat", "")
+    config.MLabLongitude = getOptionalString(defaultSection, "mlab_lon", "")
+    config.MLabSite = getOptionalString(defaultSection, "mlab_site", "")
+    config.MLabAPIKey = getOptionalString(defaultSection, "mlab_api_key", "")
+    err = config.checkMLabLocation()
+    if err != nil {
+        return config, err
+    }
+
     // we are limited to 4 servers because MLab returns only 4 servers to choose from
     config.NumServers, err = getInt(defaultSection, "num_servers", 1, 4)
     if err != nil {
         return config, err
     }
+    err = checkNumServers(config.Servers, config.NumServers)
+    if err != nil {
+        return config, err
+    }
 
     config.ExtraString, err = getString(defaultSection, "extra_string")
     if err != nil {
@@ -148,9 +249,237 @@ func New(testNames *string, configPath *string) (Config, error) {
         return config, err
     }
 
+    // only needed by the update subcommand, so it may be left out of the config file
+    config.UpdateURL = getOptionalString(defaultSection, "update_url", "")
+
     return config, nil
 }
 
+// Gets the ports of a server. Servers without a [server:<hostname>] section use the ports in the
+// default section.
+// hostname: hostname of the server
+// Returns the ports of the server
+func (config Config) GetServerPorts(hostname string) ServerPorts {
+    if serverPorts, ok := config.ServerPorts[hostname]; ok {
+        return serverPorts
+    }
+    return ServerPorts{
+        SideChannelPort: config.SideChannelPort,
+        ResultPort: config.ResultPort,
+        ReplayPorts: config.ReplayPorts,
+    }
+}
+
+// Overrides the ports in the config file with the ports given on the command line. The overrides
+// apply to every server, including servers in a [server:<hostname>] section.
+// sideChannelPort: port of the side channel; 0 to keep the port in the config file
+// resultPort: port of the results endpoint; 0 to keep the port in the config file
+// replayPorts: replay port remapping table in the format <port>:<port>,...; empty to keep the
+//              table in the config file
+// Returns an error if any of the ports are invalid
+func (config *Config) OverridePorts(sideChannelPort int, resultPort int, replayPorts string) error {
+    if sideChannelPort != 0 && (sideChannelPort < minPort || sideChannelPort > maxPort) {
+        return fmt.Errorf("%d is not a valid side channel port. Must be between %d and %d inclusive.", sideChannelPort, minPort, maxPort)
+    }
+    if resultPort != 0 && (resultPort < minPort || resultPort > maxPort) {
+        return fmt.Errorf("%d is not a valid result port. Must be between %d and %d inclusive.", resultPort, minPort, maxPort)
+    }
+    replayPortMap, err := ParsePortMap(replayPorts)
+    if err != nil {
+        return err
+    }
+
+    override := func(serverPorts *ServerPorts) {
+        if sideChannelPort != 0 {
+            serverPorts.SideChannelPort = sideChannelPort
+        }
+        if resultPort != 0 {
+            serverPorts.ResultPort = resultPort
+        }
+        if replayPorts != "" {
+            serverPorts.ReplayPorts = replayPortMap
+        }
+    }
+
+    defaultPorts := config.GetServerPorts("")
+    override(&defaultPorts)
+    config.SideChannelPort = defaultPorts.SideChannelPort
+    config.ResultPort = defaultPorts.ResultPort
+    config.ReplayPorts = defaultPorts.ReplayPorts
+    for hostname, serverPorts := range config.ServerPorts {
+        override(&serverPorts)
+        config.ServerPorts[hostname] = serverPorts
+    }
+    return nil
+}
+
+// Overrides the address family in the config file with the one given on the command line.
+// ipFamily: the address family; empty to keep the family in the config file
+// Returns an error if the family is invalid
+func (config *Config) OverrideIPFamily(ipFamily string) error {
+    if ipFamily == "" {
+        return nil
+    }
+    err := network.CheckIPFamily(ipFamily)
+    if err != nil {
+        return err
+    }
+    config.IPFamily = ipFamily
+    return nil
+}
+
+// Overrides the MLab locate API parameters in the config file with the ones given on the command
+// line. Empty parameters keep the value in the config file.
+// country: ISO 3166-1 country code
+// region: ISO 3166-2 region code
+// latitude: latitude, given with longitude
+// longitude: longitude, given with latitude
+// site: MLab site
+// apiKey: API key for priority access
+// Returns an error if the parameters are invalid
+func (config *Config) OverrideMLabLocate(country string, region string, latitude string, longitude string, site string, apiKey string) error {
+    overrides := map[*string]string{
+        &config.MLabCountry: country,
+        &config.MLabRegion: region,
+        &config.MLabLatitude: latitude,
+        &config.MLabLongitude: longitude,
+        &config.MLabSite: site,
+        &config.MLabAPIKey: apiKey,
+    }
+    for field, val := range overrides {
+        if val != "" {
+            *field = val
+        }
+    }
+    return config.checkMLabLocation()
+}
+
+// Checks that the location given for the MLab locate API is valid.
+// Returns an error if only one of the latitude and longitude is given, or if they are out of range
+func (config Config) checkMLabLocation() error {
+    if config.MLabLatitude == "" && config.MLabLongitude == "" {
+        return nil
+    }
+    if config.MLabLatitude == "" || config.MLabLongitude == "" {
+        return fmt.Errorf("mlab_lat and mlab_lon must be given together.")
+    }
+    latitude, err := strconv.ParseFloat(config.MLabLatitude, 64)
+    if err != nil || latitude < -90 || latitude > 90 {
+        return fmt.Errorf("%s is not a valid latitude. Must be between -90 and 90 inclusive.", config.MLabLatitude)
+    }
+    longitude, err := strconv.ParseFloat(config.MLabLongitude, 64)
+    if err != nil || longitude < -180 || longitude > 180 {
+        return fmt.Errorf("%s is not a valid longitude. Must be between -180 and 180 inclusive.", config.MLabLongitude)
+    }
+    return nil
+}
+
+// Parses the list of servers to run tests on.
+// servers: comma-separated list of hostnames (ex. wehe1.example.com,wehe2.example.com)
+// Returns the hostnames or an error if the list is empty or a hostname is listed more than once
+func parseServers(servers string) ([]string, error) {
+    var hostnames []string
+    listed := make(map[string]bool)
+    for _, hostname := range strings.Split(servers, ",") {
+        hostname = strings.TrimSpace(hostname)
+        if hostname == "" {
+            continue
+        }
+        if listed[strings.ToLower(hostname)] {
+            return nil, fmt.Errorf("%s is listed more than once in server_display", hostname)
+        }
+        listed[strings.ToLower(hostname)] = true
+        hostnames = append(hostnames, hostname)
+    }
+    if len(hostnames) == 0 {
+        return nil, fmt.Errorf("No servers in server_display")
+    }
+    return hostnames, nil
+}
+
+// Parses a replay port remapping table.
+// portMap: the table in the format <replay file port>:<server port>, separated by commas
+//          (ex. 80:8080,443:8443); may be empty
+// Returns the table or an error
+func ParsePortMap(portMap string) (map[int]int, error) {
+    ports := make(map[int]int)
+    for _, pair := range strings.Split(portMap, ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" {
+            continue
+        }
+        fromTo := strings.Split(pair, ":")
+        if len(fromTo) != 2 {
+            return nil, fmt.Errorf("%s is not a valid port mapping. Should be in format <replay port>:<server port>", pair)
+        }
+        from, err := parsePort(fromTo[0])
+        if err != nil {
+            return nil, err
+        }
+        to, err := parsePort(fromTo[1])
+        if err != nil {
+            return nil, err
+        }
+        ports[from] = to
+    }
+    return ports, nil
+}
+
+// Parses a port number.
+// port: the port number
+// Returns the port number or an error if it is not a valid port
+func parsePort(port string) (int, error) {
+    val, err := strconv.Atoi(strings.TrimSpace(port))
+    if err != nil {
+        return -1, err
+    }
+    if val < minPort || val > maxPort {
+        return -1, fmt.Errorf("%d is not a valid port. Must be between %d and %d inclusive.", val, minPort, maxPort)
+    }
+    return val, nil
+}
+
+// Gets the port overrides in the [server:<hostname>] sections of the config file. Ports that are
+// not in a section are taken from the default section.
+// configFile: the config file
+// config: the configurations read from the default section
+// Returns the ports of each server, keyed by hostname, or an error
+func getServerPorts(configFile *ini.File, config Config) (map[string]ServerPorts, error) {
+    serverPorts := make(map[string]ServerPorts)
+    for _, section := range configFile.Sections() {
+        if !strings.HasPrefix(section.Name(), serverSectionPrefix) {
+            continue
+        }
+        hostname := strings.TrimSpace(strings.TrimPrefix(section.Name(), serverSectionPrefix))
+        if hostname == "" {
+            return nil, fmt.Errorf("No hostname in section [%s]", section.Name())
+        }
+
+        sideChannelPort, err := getOptionalInt(section, "side_channel_port", config.SideChannelPort, minPort, maxPort)
+        if err != nil {
+            return nil, err
+        }
+        resultPort, err := getOptionalInt(section, "result_port", config.ResultPort, minPort, maxPort)
+        if err != nil {
+            return nil, err
+        }
+        replayPorts := config.ReplayPorts
+        if section.HasKey("replay_ports") {
+            replayPorts, err = ParsePortMap(section.Key("replay_ports").String())
+            if err != nil {
+                return nil, fmt.Errorf("%s in replay_ports key of section [%s]", err, section.Name())
+            }
+        }
+
+        serverPorts[hostname] = ServerPorts{
+            SideChannelPort: sideChannelPort,
+            ResultPort: resultPort,
+            ReplayPorts: replayPorts,
+        }
+    }
+    return serverPorts, nil
+}
+
 // Gets a string from the config file.
 // section: the section of the ini file that contains the key
 // keyStr: the key
@@ -167,6 +496,37 @@ func getString(section *ini.Section, keyStr string) (string, error) {
     return val, nil
 }
 
+// Gets a string from the config file that does not have to be present.
+// section: the section of the ini file that contains the key
+// keyStr: the key
+// defaultVal: the value to use if the key does not exist or is empty
+// Returns the value of the key or the default value
+func getOptionalString(section *ini.Section, keyStr string, defaultVal string) string {
+    if !section.HasKey(keyStr) {
+        return defaultVal
+    }
+    val := section.Key(keyStr).String()
+    if val == "" {
+        return defaultVal
+    }
+    return val
+}
+
+// Gets a comma-separated list from the config file that does not have to be present.
+// section: the section of the ini file that contains the key
+// keyStr: the key
+// Returns the lowercase, non-empty items of the list, or nil if the key does not exist
+func getOptionalList(section *ini.Section, keyStr string) []string {
+    var list []string
+    for _, item := range strings.Split(getOptionalString(section, keyStr, ""), ",") {
+        item = strings.TrimSpace(strings.ToLower(item))
+        if item != "" {
+            list = append(list, item)
+        }
+    }
+    return list
+}
+
 // Gets a log level from the config file.
 // section: the section of the ini file that contains the key
 // keyStr: the key
@@ -179,17 +539,17 @@ func getLogLevel(section *ini.Section, keyStr string) (int, error) {
 
     switch val {
     case "ui":
-        return 0, nil
+        return int(logging.UI), nil
     case "wtf":
-        return 1, nil
+        return int(logging.WTF), nil
     case "error":
-        return 2, nil
+        return int(logging.Error), nil
     case "warn":
-        return 3, nil
+        return int(logging.Warn), nil
     case "info":
-        return 4, nil
+        return int(logging.Info), nil
     case "debug":
-        return 5, nil
+        return int(logging.Debug), nil
     default:
         return -1, fmt.Errorf("%s is not a log level. Choose from ui, wtf, error, warn, info, or debug.", val)
     }
@@ -216,6 +576,32 @@ func getInt(section *ini.Section, keyStr string, low int, high int) (int, error)
     return val, nil
 }
 
+// Gets an integer from the config file that does not have to be present.
+// section: the section of the ini file that contains the key
+// keyStr: the key
+// defaultVal: the value to use if the key does not exist or is empty
+// low: the lower bounds (inclusive) that the value should not go below
+// high: the upper bounds (inclusive) that the value should not go above
+// Returns the value or an error
+func getOptionalInt(section *ini.Section, keyStr string, defaultVal int, low int, high int) (int, error) {
+    if !section.HasKey(keyStr) || section.Key(keyStr).String() == "" {
+        return defaultVal, nil
+    }
+    return getInt(section, keyStr, low, high)
+}
+
+// Gets a boolean from the config file that does not have to be present.
+// section: the section of the ini file that contains the key
+// keyStr: the key
+// defaultVal: the value to use if the key does not exist or is empty
+// Returns the value or an error
+func getOptionalBool(section *ini.Section, keyStr string, defaultVal bool) (bool, error) {
+    if !section.HasKey(keyStr) || section.Key(keyStr).String() == "" {
+        return defaultVal, nil
+    }
+    return getBool(section, keyStr)
+}
+
 // Gets a boolean from the config file.
 // section: the section of the ini file that contains the key
 // keyStr: the key
@@ -231,3 +617,22 @@ func getBool(section *ini.Section, keyStr string) (bool, error) {
     }
     return val, nil
 }
+
+// Checks that the number of servers to run the tests on matches the servers listed. Tests run on
+// every self-hosted server listed, so num_servers must be the number of servers listed, just like
+// it is the number of MLab servers that tests run on when MLab is used.
+// servers: the servers listed in server_display
+// numServers: the number of servers to run the tests on, set with num_servers
+// Returns an error if the numbers do not match
+func checkNumServers(servers []string, numServers int) error {
+    for _, hostname := range servers {
+        // MLab listed with other servers is rejected when the servers are set up
+        if serverhandler.UseMLab(hostname) {
+            return nil
+        }
+    }
+    if numServers != len(servers) {
+        return fmt.Errorf("num_servers is %d, but %d servers are listed in server_display. Set num_servers to the number of servers listed, or use MLab (%s) to run tests on num_servers MLab servers.", numServers, len(servers), serverhandler.UseMLabHostname)
+    }
+    return nil
+}
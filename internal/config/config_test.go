@@ -205,6 +205,64 @@ func TestNew(t *testing.T) {
         t.Errorf("Expected 56566, got %d", config.ResultPort)
     }
 
+    if config.SideChannelPort != 55556 {
+        t.Errorf("Expected 55556, got %d", config.SideChannelPort)
+    }
+
+    if len(config.ReplayPorts) != 2 || config.ReplayPorts[80] != 8080 || config.ReplayPorts[443] != 8443 {
+        t.Errorf("Expected map[80:8080 443:8443], got %v", config.ReplayPorts)
+    }
+
+    // Test per-server port overrides
+    serverPorts := config.GetServerPorts("wehe-test.example.com")
+    if serverPorts.SideChannelPort != 45556 {
+        t.Errorf("Expected 45556, got %d", serverPorts.SideChannelPort)
+    }
+    if serverPorts.ResultPort != 56566 {
+        t.Errorf("Expected 56566, got %d", serverPorts.ResultPort)
+    }
+    if len(serverPorts.ReplayPorts) != 1 || serverPorts.ReplayPorts[80] != 10080 {
+        t.Errorf("Expected map[80:10080], got %v", serverPorts.ReplayPorts)
+    }
+
+    // Test command line overrides apply to every server
+    err = config.OverridePorts(40000, 0, "")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if config.GetServerPorts("wehe-test.example.com").SideChannelPort != 40000 || config.GetServerPorts("other").SideChannelPort != 40000 {
+        t.Errorf("Expected 40000, got %v", config.ServerPorts)
+    }
+    if config.ResultPort != 56566 {
+        t.Errorf("Expected 56566, got %d", config.ResultPort)
+    }
+
+    // Test address family
+    if config.IPFamily != "auto" {
+        t.Errorf("Expected auto, got %s", config.IPFamily)
+    }
+    err = config.OverrideIPFamily("v5")
+    if err == nil {
+        t.Errorf("Expected error for invalid IP family, but got none.")
+    }
+    err = config.OverrideIPFamily("v6")
+    if err != nil || config.IPFamily != "v6" {
+        t.Errorf("Expected v6, got %s (%v)", config.IPFamily, err)
+    }
+
+    // Test MLab locate overrides
+    err = config.OverrideMLabLocate("", "", "40.7", "", "", "")
+    if err == nil {
+        t.Errorf("Expected error for latitude without longitude, but got none.")
+    }
+    err = config.OverrideMLabLocate("US", "", "40.7", "-74.0", "", "")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if config.MLabCountry != "US" || config.MLabLatitude != "40.7" || config.MLabLongitude != "-74.0" {
+        t.Errorf("Expected US 40.7 -74.0, got %s %s %s", config.MLabCountry, config.MLabLatitude, config.MLabLongitude)
+    }
+
     if config.ConfirmationReplays != true {
         t.Errorf("Expected false, got %t", config.ConfirmationReplays)
     }
@@ -253,3 +311,95 @@ func TestNew(t *testing.T) {
         }
     }
 }
+
+func TestParsePortMap(t *testing.T) {
+    // Test valid table
+    result, err := ParsePortMap("80:8080, 443:8443,")
+    if err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+    if len(result) != 2 || result[80] != 8080 || result[443] != 8443 {
+        t.Errorf("Expected map[80:8080 443:8443], got %v", result)
+    }
+
+    // Test empty table
+    result, err = ParsePortMap("")
+    if err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+    if len(result) != 0 {
+        t.Errorf("Expected empty map, got %v", result)
+    }
+
+    // Test invalid mapping
+    _, err = ParsePortMap("80-8080")
+    if err == nil {
+        t.Error("Expected error for invalid mapping, but got none.")
+    } else {
+        expectedError := "80-8080 is not a valid port mapping. Should be in format <replay port>:<server port>"
+        if fmt.Sprint(err) != expectedError {
+            t.Errorf("Expected error '%s', got '%v'", expectedError, err)
+        }
+    }
+
+    // Test out of range port
+    _, err = ParsePortMap("80:70000")
+    if err == nil {
+        t.Error("Expected error for out-of-range port, but got none.")
+    } else {
+        expectedError := "70000 is not a valid port. Must be between 1 and 65535 inclusive."
+        if fmt.Sprint(err) != expectedError {
+            t.Errorf("Expected error '%s', got '%v'", expectedError, err)
+        }
+    }
+}
+
+func TestParseServers(t *testing.T) {
+    // Test list of servers
+    result, err := parseServers("wehe1.example.com, wehe2.example.com,")
+    if err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+    if len(result) != 2 || result[0] != "wehe1.example.com" || result[1] != "wehe2.example.com" {
+        t.Errorf("Expected [wehe1.example.com wehe2.example.com], got %v", result)
+    }
+
+    // Test duplicate server
+    _, err = parseServers("wehe1.example.com,WEHE1.example.com")
+    if err == nil {
+        t.Error("Expected error for duplicate server, but got none.")
+    } else {
+        expectedError := "WEHE1.example.com is listed more than once in server_display"
+        if fmt.Sprint(err) != expectedError {
+            t.Errorf("Expected error '%s', got '%v'", expectedError, err)
+        }
+    }
+
+    // Test empty list
+    _, err = parseServers(" , ")
+    if err == nil {
+        t.Error("Expected error for empty list, but got none.")
+    }
+}
+
+func TestCheckNumServers(t *testing.T) {
+    // Test number of self-hosted servers
+    err := checkNumServers([]string{"wehe1.example.com", "wehe2.example.com"}, 2)
+    if err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+    err = checkNumServers([]string{"wehe1.example.com", "wehe2.example.com"}, 1)
+    if err == nil {
+        t.Error("Expected error for more servers than num_servers, but got none.")
+    }
+    err = checkNumServers([]string{"wehe1.example.com"}, 3)
+    if err == nil {
+        t.Error("Expected error for fewer servers than num_servers, but got none.")
+    }
+
+    // Test MLab runs on num_servers servers
+    err = checkNumServers([]string{"wehe4.meddle.mobi"}, 3)
+    if err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+}
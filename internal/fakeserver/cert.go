@@ -0,0 +1,46 @@
+package fakeserver
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "net"
+    "time"
+)
+
+// Creates a self-signed certificate for the side channel.
+// ip: the IP that the certificate is valid for
+// Returns the TLS configuration of the server and the certificate in PEM format, or any errors
+func newTLSConfig(ip string) (*tls.Config, []byte, error) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return nil, nil, err
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(1),
+        Subject: pkix.Name{CommonName: "wehe fake server"},
+        NotBefore: time.Now().Add(-time.Hour),
+        NotAfter: time.Now().Add(24 * time.Hour),
+        KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        BasicConstraintsValid: true,
+        IsCA: true,
+        IPAddresses: []net.IP{net.ParseIP(ip)},
+    }
+    certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    cert := tls.Certificate{
+        Certificate: [][]byte{certDER},
+        PrivateKey: key,
+    }
+    certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+    return &tls.Config{Certificates: []tls.Certificate{cert}}, certPEM, nil
+}
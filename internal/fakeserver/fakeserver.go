@@ -0,0 +1,469 @@
+// A stand-in Wehe server that runs in the same process as the client, so that replays can be run
+// end to end on loopback without a real Wehe or MLab server.
+package fakeserver
+
+import (
+    "crypto/tls"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+
+    "wehe-cmdline-client/internal/logging"
+    "wehe-cmdline-client/internal/serverhandler"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    DefaultListenIP = "127.0.0.1"
+    DefaultSamplesPerReplay = 10
+
+    // the opcodes of the side channel; these must match the opcodes in the network package
+    receiveID byte = 2
+    ask4permission byte = 3
+    mobileStats byte = 4
+    throughputs byte = 5
+    declareReplay byte = 6
+    analyzeTest byte = 7
+
+    okResponse byte = 0
+    errorResponse byte = 1
+
+    ask4PermissionOkStatus = "0"
+)
+
+// Configurations for the fake server.
+type Config struct {
+    Replays []testdata.ReplayInfo // the replays that the server can run
+    SamplesPerReplay int // number of throughput samples the client is told to collect; DefaultSamplesPerReplay if 0
+    KS2Result *testdata.KS2Result // the analysis returned for every test; if nil, the analysis is computed from the throughputs sent by the client
+    Logger *logging.Logger // logger to write protocol traces to; may be nil
+    ListenIP string // IP that the server listens on, such as ::1 to test IPv6; DefaultListenIP if empty
+    TCPResponseLimit int // if greater than 0, TCP replay connections are closed after this many response bytes, as if a middlebox blocked them
+    TCPThrottle int // if greater than 0, TCP responses are sent at this many bytes per second, as if a middlebox throttled them
+    ResultsNotReady int // number of requests to the results endpoint that are answered as not ready before it starts answering
+    DropAnalysis bool // if true, the side channel is closed instead of returning the analysis, which is still stored for the results endpoint
+}
+
+// The information that the client sent for a replay.
+type ReplayReport struct {
+    ReplayID int // the type of replay; 0 for original, 1 for random
+    ReplayName string // the name of the replay
+    Duration float64 // number of seconds the replay ran for
+    Throughputs []float64 // the Mbps of each sample
+    SampleTimes []float64 // the number of seconds since the replay started that each sample was taken
+}
+
+// A fake Wehe server.
+type Server struct {
+    config Config
+    replays map[string]testdata.ReplayInfo // the replays that can be run, keyed by replay name
+    tlsConfig *tls.Config // TLS configuration of the side channel
+    caCertPEM []byte // the certificate that the client should trust
+    sideChannelListener net.Listener // listener for the side channel
+    resultsServer *http.Server // HTTPS server of the results endpoint
+    resultsListener net.Listener // listener for the results endpoint
+    tcpListeners map[int]net.Listener // TCP replay listeners, keyed by the server port in the replay files
+    udpConns map[int]*net.UDPConn // UDP replay connections, keyed by the server port in the replay files
+    wg sync.WaitGroup // waits for all the connections to close
+
+    mutex sync.Mutex // guards the fields below
+    conns map[net.Conn]bool // open connections, closed when the server is closed
+    currentReplay string // name of the replay that the client declared most recently
+    replayReports []ReplayReport // the throughputs sent by the client, in the order they were received
+    unanalyzed []ReplayReport // the throughputs that have not been analyzed yet
+    clientIDs []string // the messages sent with the receiveID opcode
+    declaredReplays []string // the messages sent with the declareReplay opcode
+    mobileStats []string // the messages sent with the mobileStats opcode
+    analyses []storedAnalysis // the analyses that the results endpoint returns
+    numResultsRequests int // number of requests that the results endpoint has received
+}
+
+// Starts a fake server. The side channel and replays listen on random ports on config.ListenIP; use
+// Ports to get them.
+// config: the configurations of the fake server
+// Returns the running server or any errors
+func Start(config Config) (*Server, error) {
+    if config.SamplesPerReplay == 0 {
+        config.SamplesPerReplay = DefaultSamplesPerReplay
+    }
+    if config.ListenIP == "" {
+        config.ListenIP = DefaultListenIP
+    }
+    srv := &Server{
+        config: config,
+        replays: make(map[string]testdata.ReplayInfo),
+        tcpListeners: make(map[int]net.Listener),
+        udpConns: make(map[int]*net.UDPConn),
+        conns: make(map[net.Conn]bool),
+    }
+
+    var err error
+    srv.tlsConfig, srv.caCertPEM, err = newTLSConfig(config.ListenIP)
+    if err != nil {
+        return nil, err
+    }
+
+    srv.sideChannelListener, err = tls.Listen("tcp", net.JoinHostPort(config.ListenIP, "0"), srv.tlsConfig)
+    if err != nil {
+        return nil, err
+    }
+    srv.wg.Add(1)
+    go srv.acceptSideChannels()
+
+    srv.resultsListener, err = tls.Listen("tcp", net.JoinHostPort(config.ListenIP, "0"), srv.tlsConfig)
+    if err != nil {
+        srv.sideChannelListener.Close()
+        return nil, err
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/Results", srv.handleResults)
+    srv.resultsServer = &http.Server{Handler: mux}
+    go srv.resultsServer.Serve(srv.resultsListener)
+
+    for _, replay := range config.Replays {
+        srv.replays[replay.ReplayName] = replay
+        err = srv.listenForReplay(replay)
+        if err != nil {
+            srv.Close()
+            return nil, err
+        }
+    }
+    return srv, nil
+}
+
+// Gets the ports that the server listens on, to be passed to serverhandler.New.
+// Returns the ports of the server
+func (srv *Server) Ports() serverhandler.Ports {
+    ports := serverhandler.Ports{
+        SideChannel: srv.sideChannelListener.Addr().(*net.TCPAddr).Port,
+        Results: srv.resultsListener.Addr().(*net.TCPAddr).Port,
+        Replay: make(map[int]int),
+    }
+    for port, listener := range srv.tcpListeners {
+        ports.Replay[port] = listener.Addr().(*net.TCPAddr).Port
+    }
+    for port, conn := range srv.udpConns {
+        ports.Replay[port] = conn.LocalAddr().(*net.UDPAddr).Port
+    }
+    return ports
+}
+
+// Gets the certificate of the side channel in PEM format, so that it can be written to the
+// server_cert_file.
+// Returns the certificate
+func (srv *Server) CACertPEM() []byte {
+    return srv.caCertPEM
+}
+
+// Gets the throughputs that the client has sent so far.
+// Returns the information sent for each replay
+func (srv *Server) ReplayReports() []ReplayReport {
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    return append([]ReplayReport{}, srv.replayReports...)
+}
+
+// Gets the messages that the client sent to declare a test.
+// Returns the semicolon-separated messages sent with the receiveID opcode
+func (srv *Server) ClientIDs() []string {
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    return append([]string{}, srv.clientIDs...)
+}
+
+// Gets the messages that the client sent to declare the replays after the first one in a test.
+// Returns the semicolon-separated messages sent with the declareReplay opcode
+func (srv *Server) DeclaredReplays() []string {
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    return append([]string{}, srv.declaredReplays...)
+}
+
+// Gets the information that the client sent about its host and network.
+// Returns the messages sent with the mobileStats opcode
+func (srv *Server) MobileStats() []string {
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    return append([]string{}, srv.mobileStats...)
+}
+
+// Gets the number of side channel and TCP replay connections that the client has not closed yet.
+// Returns the number of open connections
+func (srv *Server) NumOpenConns() int {
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    return len(srv.conns)
+}
+
+// Stops the server and closes all of its connections.
+func (srv *Server) Close() {
+    srv.sideChannelListener.Close()
+    srv.resultsServer.Close()
+    for _, listener := range srv.tcpListeners {
+        listener.Close()
+    }
+    for _, conn := range srv.udpConns {
+        conn.Close()
+    }
+    srv.mutex.Lock()
+    for conn := range srv.conns {
+        conn.Close()
+    }
+    srv.mutex.Unlock()
+    srv.wg.Wait()
+}
+
+// Keeps track of a connection so that it is closed when the server is closed.
+// conn: the connection
+func (srv *Server) trackConn(conn net.Conn) {
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    srv.conns[conn] = true
+}
+
+// Stops keeping track of a connection and closes it.
+// conn: the connection
+func (srv *Server) closeConn(conn net.Conn) {
+    srv.mutex.Lock()
+    delete(srv.conns, conn)
+    srv.mutex.Unlock()
+    conn.Close()
+}
+
+// Accepts side channel connections until the server is closed.
+func (srv *Server) acceptSideChannels() {
+    defer srv.wg.Done()
+    for {
+        conn, err := srv.sideChannelListener.Accept()
+        if err != nil {
+            return
+        }
+        srv.trackConn(conn)
+        srv.wg.Add(1)
+        go srv.handleSideChannel(conn)
+    }
+}
+
+// Answers the requests on a side channel connection until the client closes it.
+// conn: the side channel connection
+func (srv *Server) handleSideChannel(conn net.Conn) {
+    defer srv.wg.Done()
+    defer srv.closeConn(conn)
+
+    replayID := 0
+    replayName := ""
+    userID := ""
+    historyCount := 0
+    for {
+        // first byte is opcode, last 3 bytes is 24-bit big-endian unsigned message length
+        header := make([]byte, 4)
+        _, err := io.ReadFull(conn, header)
+        if err != nil {
+            return
+        }
+        op := header[0]
+        header[0] = 0
+        message := make([]byte, binary.BigEndian.Uint32(header))
+        _, err = io.ReadFull(conn, message)
+        if err != nil {
+            return
+        }
+        srv.config.Logger.Debug("Fake server received opcode %d: %s", op, message)
+
+        var code byte
+        var resp string
+        switch op {
+        case receiveID:
+            // <userID>;<replayID>;<replayName>;<numMLabTries>;<testID>;<isLastReplay>;<publicIP>;<clientVersion>
+            fields := strings.Split(string(message), ";")
+            if len(fields) != 8 {
+                srv.config.Logger.Error("Fake server received invalid ID: %s", message)
+                return
+            }
+            replayID, replayName, err = srv.setCurrentReplay(fields[1], fields[2])
+            if err != nil {
+                srv.config.Logger.Error("Fake server received invalid ID: %v", err)
+                return
+            }
+            userID = fields[0]
+            historyCount, err = strconv.Atoi(fields[4])
+            if err != nil {
+                srv.config.Logger.Error("Fake server received invalid test ID: %v", err)
+                return
+            }
+            srv.mutex.Lock()
+            srv.clientIDs = append(srv.clientIDs, string(message))
+            srv.mutex.Unlock()
+            // the client does not wait for a response to its ID
+            continue
+        case ask4permission:
+            code, resp = okResponse, ask4PermissionOkStatus + ";" + strconv.Itoa(srv.config.SamplesPerReplay)
+        case mobileStats:
+            srv.mutex.Lock()
+            srv.mobileStats = append(srv.mobileStats, string(message))
+            srv.mutex.Unlock()
+            code, resp = okResponse, ""
+        case throughputs:
+            err = srv.addReplayReport(replayID, replayName, string(message))
+            if err != nil {
+                code, resp = errorResponse, err.Error()
+            } else {
+                code, resp = okResponse, ""
+            }
+        case declareReplay:
+            // <replayID>;<replayName>;<isLastReplay>
+            fields := strings.Split(string(message), ";")
+            if len(fields) != 3 {
+                code, resp = errorResponse, "invalid replay declaration"
+                break
+            }
+            srv.mutex.Lock()
+            srv.declaredReplays = append(srv.declaredReplays, string(message))
+            srv.mutex.Unlock()
+            replayID, replayName, err = srv.setCurrentReplay(fields[0], fields[1])
+            if err != nil {
+                code, resp = errorResponse, err.Error()
+            } else {
+                code, resp = okResponse, ask4PermissionOkStatus + ";" + strconv.Itoa(srv.config.SamplesPerReplay)
+            }
+        case analyzeTest:
+            ks2Result := srv.analyze(userID, historyCount)
+            if srv.config.DropAnalysis {
+                return
+            }
+            data, err := json.Marshal(ks2Result)
+            if err != nil {
+                code, resp = errorResponse, err.Error()
+            } else {
+                code, resp = okResponse, string(data)
+            }
+        default:
+            code, resp = errorResponse, fmt.Sprintf("unknown opcode %d", op)
+        }
+
+        err = writeResponse(conn, code, resp)
+        if err != nil {
+            return
+        }
+    }
+}
+
+// Sets the replay that the TCP and UDP listeners should run.
+// replayIDStr: the type of replay
+// replayName: the name of the replay
+// Returns the replay ID and name, or an error if the server does not have the replay
+func (srv *Server) setCurrentReplay(replayIDStr string, replayName string) (int, string, error) {
+    replayID, err := strconv.Atoi(replayIDStr)
+    if err != nil {
+        return 0, "", err
+    }
+    if _, ok := srv.replays[replayName]; !ok {
+        return 0, "", fmt.Errorf("unknown replay %s", replayName)
+    }
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    srv.currentReplay = replayName
+    return replayID, replayName, nil
+}
+
+// Gets the replay that the client declared most recently.
+// Returns the replay and true, or false if no replay has been declared
+func (srv *Server) getCurrentReplay() (testdata.ReplayInfo, bool) {
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    replay, ok := srv.replays[srv.currentReplay]
+    return replay, ok
+}
+
+// Stores the throughputs that the client sent for a replay.
+// replayID: the type of replay
+// replayName: the name of the replay
+// message: the message in the format <replayDuration>;[[<throughputs>],[<sampleTimes>]]
+// Returns any errors
+func (srv *Server) addReplayReport(replayID int, replayName string, message string) error {
+    durationAndSamples := strings.SplitN(message, ";", 2)
+    if len(durationAndSamples) != 2 {
+        return fmt.Errorf("invalid throughputs message")
+    }
+    duration, err := strconv.ParseFloat(durationAndSamples[0], 64)
+    if err != nil {
+        return err
+    }
+    var samples [][]float64
+    err = json.Unmarshal([]byte(durationAndSamples[1]), &samples)
+    if err != nil {
+        return err
+    }
+    if len(samples) != 2 {
+        return fmt.Errorf("expected throughputs and sample times, got %d lists", len(samples))
+    }
+
+    report := ReplayReport{
+        ReplayID: replayID,
+        ReplayName: replayName,
+        Duration: duration,
+        Throughputs: samples[0],
+        SampleTimes: samples[1],
+    }
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    srv.replayReports = append(srv.replayReports, report)
+    srv.unanalyzed = append(srv.unanalyzed, report)
+    return nil
+}
+
+// Analyzes the replays that have run since the last analysis, and stores the analysis for the
+// results endpoint.
+// userID: the user that ran the test
+// historyCount: the test ID of the test for the user
+// Returns the canned analysis if one was configured, or the analysis of the throughputs
+func (srv *Server) analyze(userID string, historyCount int) testdata.KS2Result {
+    srv.mutex.Lock()
+    unanalyzed := srv.unanalyzed
+    srv.unanalyzed = nil
+    srv.mutex.Unlock()
+    ks2Result := srv.computeAnalysis(unanalyzed)
+    srv.storeAnalysis(userID, historyCount, unanalyzed, ks2Result)
+    return ks2Result
+}
+
+// Analyzes replays.
+// unanalyzed: the replays to analyze
+// Returns the canned analysis if one was configured, or the analysis of the throughputs
+func (srv *Server) computeAnalysis(unanalyzed []ReplayReport) testdata.KS2Result {
+    if srv.config.KS2Result != nil {
+        return *srv.config.KS2Result
+    }
+
+    var original []float64
+    var random []float64
+    for _, report := range unanalyzed {
+        if report.ReplayID == 0 {
+            original = append(original, report.Throughputs...)
+        } else {
+            random = append(random, report.Throughputs...)
+        }
+    }
+    return computeKS2Result(original, random)
+}
+
+// Writes a response on the side channel.
+// conn: the side channel connection
+// code: okResponse or errorResponse
+// message: the body of the response
+// Returns any errors
+func writeResponse(conn net.Conn, code byte, message string) error {
+    // 4 byte big-endian length, followed by the response code and the message
+    resp := make([]byte, 5, 5 + len(message))
+    binary.BigEndian.PutUint32(resp, uint32(len(message) + 1))
+    resp[4] = code
+    resp = append(resp, message...)
+    _, err := conn.Write(resp)
+    return err
+}
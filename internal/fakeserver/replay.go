@@ -0,0 +1,190 @@
+package fakeserver
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "time"
+
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    publicIPRequest = "WHATSMYIPMAN" // sent by the client over UDP, or as the path of an HTTP GET over TCP, to get its public IP
+    httpPublicIPRequest = "GET /" + publicIPRequest
+    responseChunkSize = 4096 // number of bytes written at a time for a TCP response
+)
+
+// Listens on a random port for each server port of a replay that does not already have a listener.
+// A UDP replay can have flows to more than one server port.
+// replay: the replay to listen for
+// Returns any errors
+func (srv *Server) listenForReplay(replay testdata.ReplayInfo) error {
+    if replay.IsTCP {
+        return srv.listenOnPort(replay.CSPair.ServerPort, true)
+    }
+    for _, flow := range replay.UDPFlows {
+        err := srv.listenOnPort(flow.CSPair.ServerPort, false)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Listens on a random port for a server port in the replay files, if it does not already have a
+// listener.
+// port: the server port in the replay files
+// isTCP: true to listen for a TCP replay; false to listen for a UDP replay
+// Returns any errors
+func (srv *Server) listenOnPort(port int, isTCP bool) error {
+    _, hasTCP := srv.tcpListeners[port]
+    _, hasUDP := srv.udpConns[port]
+    if (isTCP && hasUDP) || (!isTCP && hasTCP) {
+        return fmt.Errorf("Fake server cannot run both TCP and UDP replays on port %d.", port)
+    }
+    if hasTCP || hasUDP {
+        return nil
+    }
+
+    if isTCP {
+        listener, err := net.Listen("tcp", net.JoinHostPort(srv.config.ListenIP, "0"))
+        if err != nil {
+            return err
+        }
+        srv.tcpListeners[port] = listener
+        srv.wg.Add(1)
+        go srv.acceptTCP(listener)
+    } else {
+        addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(srv.config.ListenIP, "0"))
+        if err != nil {
+            return err
+        }
+        conn, err := net.ListenUDP("udp", addr)
+        if err != nil {
+            return err
+        }
+        srv.udpConns[port] = conn
+        srv.wg.Add(1)
+        go srv.serveUDP(conn)
+    }
+    return nil
+}
+
+// Accepts TCP connections until the server is closed.
+// listener: the listener of a replay port
+func (srv *Server) acceptTCP(listener net.Listener) {
+    defer srv.wg.Done()
+    for {
+        conn, err := listener.Accept()
+        if err != nil {
+            return
+        }
+        srv.trackConn(conn)
+        srv.wg.Add(1)
+        go srv.handleTCP(conn)
+    }
+}
+
+// Handles a TCP connection. The connection is either an HTTP request for the client's public IP,
+// or a TCP replay.
+// conn: the TCP connection
+func (srv *Server) handleTCP(conn net.Conn) {
+    defer srv.wg.Done()
+    defer srv.closeConn(conn)
+
+    reader := bufio.NewReader(conn)
+    isPublicIPRequest, err := hasPrefix(reader, httpPublicIPRequest)
+    if err != nil {
+        return
+    }
+    if isPublicIPRequest {
+        ip := getIP(conn.RemoteAddr())
+        fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n%s", len(ip), ip)
+        return
+    }
+
+    replay, ok := srv.getCurrentReplay()
+    if !ok || !replay.IsTCP {
+        srv.config.Logger.Error("Fake server received a TCP replay before a TCP replay was declared")
+        return
+    }
+
+    // wait for each packet that the client sends, and answer with the recorded response length
+    response := make([]byte, responseChunkSize)
+    sent := 0
+    for i, p := range replay.Packets {
+        packet := p.(*testdata.TCPPacket)
+        _, err = io.ReadFull(reader, make([]byte, len(packet.Payload)))
+        if err != nil {
+            srv.config.Logger.Debug("Fake server stopped TCP replay at packet %d: %v", i + 1, err)
+            return
+        }
+        for remaining := packet.ResponseLength; remaining > 0; remaining -= len(response) {
+            numBytes := min(remaining, len(response))
+            if srv.config.TCPResponseLimit > 0 && sent + numBytes > srv.config.TCPResponseLimit {
+                conn.Write(response[:srv.config.TCPResponseLimit - sent])
+                srv.config.Logger.Debug("Fake server cut off TCP replay after %d bytes", srv.config.TCPResponseLimit)
+                return
+            }
+            _, err = conn.Write(response[:numBytes])
+            if err != nil {
+                return
+            }
+            sent += numBytes
+            if srv.config.TCPThrottle > 0 {
+                time.Sleep(time.Duration(numBytes) * time.Second / time.Duration(srv.config.TCPThrottle))
+            }
+        }
+    }
+    // the client stops receiving when the server closes the connection
+}
+
+// Answers UDP datagrams until the server is closed. Requests for the client's public IP are
+// answered with the IP; every other datagram is answered with a datagram of the same length.
+// conn: the UDP connection of a replay port
+func (srv *Server) serveUDP(conn *net.UDPConn) {
+    defer srv.wg.Done()
+    buffer := make([]byte, 65535)
+    for {
+        numBytes, addr, err := conn.ReadFromUDP(buffer)
+        if err != nil {
+            return
+        }
+        if string(buffer[:numBytes]) == publicIPRequest {
+            conn.WriteToUDP([]byte(addr.IP.String()), addr)
+            continue
+        }
+        conn.WriteToUDP(make([]byte, numBytes), addr)
+    }
+}
+
+// Checks if a stream starts with a prefix. Only the bytes needed to rule out the prefix are read,
+// and they are left in the reader.
+// reader: the stream
+// prefix: the prefix to look for
+// Returns true if the stream starts with prefix, or any errors
+func hasPrefix(reader *bufio.Reader, prefix string) (bool, error) {
+    for i := 1; i <= len(prefix); i++ {
+        data, err := reader.Peek(i)
+        if err != nil {
+            return false, err
+        }
+        if data[i - 1] != prefix[i - 1] {
+            return false, nil
+        }
+    }
+    return true, nil
+}
+
+// Gets the IP of an address.
+// addr: a TCP address
+// Returns the IP
+func getIP(addr net.Addr) string {
+    host, _, err := net.SplitHostPort(addr.String())
+    if err != nil {
+        return addr.String()
+    }
+    return host
+}
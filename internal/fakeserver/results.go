@@ -0,0 +1,151 @@
+package fakeserver
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    singleResultCommand = "singleResult"
+    multiResultsCommand = "multiResults"
+)
+
+// An analysis that the server stored for the results endpoint.
+type storedAnalysis struct {
+    userID string // the user that ran the test
+    historyCount int // the test ID of the test for the user
+    replayID int // the replay ID that the analysis is stored under
+    fields map[string]interface{} // the analysis as the results endpoint returns it
+}
+
+// Stores the analysis of a test so that it can be retrieved from the results endpoint. Like the
+// real server, the analysis is stored under the ID of the random replay, and numbers are sent as
+// strings along with stats that the client does not know about.
+// userID: the user that ran the test
+// historyCount: the test ID of the test for the user
+// reports: the replays that were analyzed
+// ks2Result: the analysis of the replays
+func (srv *Server) storeAnalysis(userID string, historyCount int, reports []ReplayReport, ks2Result testdata.KS2Result) {
+    if len(reports) == 0 {
+        return
+    }
+    random := reports[len(reports) - 1]
+    var originalMax, randomMax float64
+    for _, report := range reports {
+        if report.ReplayID != 0 {
+            random = report
+        }
+        for _, throughput := range report.Throughputs {
+            if report.ReplayID == 0 {
+                originalMax = max(originalMax, throughput)
+            } else {
+                randomMax = max(randomMax, throughput)
+            }
+        }
+    }
+
+    analysis := storedAnalysis{
+        userID: userID,
+        historyCount: historyCount,
+        replayID: random.ReplayID,
+        fields: map[string]interface{}{
+            "userID": userID,
+            "historyCount": strconv.Itoa(historyCount),
+            "testID": strconv.Itoa(random.ReplayID),
+            "replayName": random.ReplayName,
+            "date": time.Now().UTC().Format("2006-01-02 15:04:05"),
+            "extraString": "",
+            "area_test": ks2Result.Area0var,
+            "ks2_ratio_test": 1,
+            "xput_avg_original": ks2Result.OriginalAvgThroughput,
+            "xput_avg_test": ks2Result.RandomAvgThroughput,
+            "ks2dVal": 0,
+            "ks2pVal": strconv.FormatFloat(ks2Result.KS2pVal, 'f', -1, 64),
+            "xput_max_original": originalMax,
+            "xput_max_test": randomMax,
+        },
+    }
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    srv.analyses = append(srv.analyses, analysis)
+}
+
+// Gets the number of requests that the results endpoint has received.
+// Returns the number of requests
+func (srv *Server) NumResultsRequests() int {
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    return srv.numResultsRequests
+}
+
+// Answers a request to the results endpoint. The singleResult command returns the analysis of one
+// test, and the multiResults command returns the analyses of a user's tests up to maxHistoryCount.
+// w: writes the response
+// r: the request
+func (srv *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    srv.mutex.Lock()
+    defer srv.mutex.Unlock()
+    srv.numResultsRequests += 1
+
+    resp := map[string]interface{}{"success": false}
+    if srv.numResultsRequests <= srv.config.ResultsNotReady {
+        resp["error"] = "Result not ready yet"
+        writeJSON(w, resp)
+        return
+    }
+
+    userID := query.Get("userID")
+    switch query.Get("command") {
+    case singleResultCommand:
+        historyCount, err1 := strconv.Atoi(query.Get("historyCount"))
+        replayID, err2 := strconv.Atoi(query.Get("testID"))
+        if err1 != nil || err2 != nil {
+            http.Error(w, "invalid historyCount or testID", http.StatusBadRequest)
+            return
+        }
+        resp["error"] = "No result found"
+        for _, analysis := range srv.analyses {
+            if analysis.userID == userID && analysis.historyCount == historyCount && analysis.replayID == replayID {
+                resp = map[string]interface{}{"success": true, "response": analysis.fields}
+            }
+        }
+    case multiResultsCommand:
+        maxHistoryCount, err := strconv.Atoi(query.Get("maxHistoryCount"))
+        if err != nil {
+            http.Error(w, "invalid maxHistoryCount", http.StatusBadRequest)
+            return
+        }
+        var analyses []storedAnalysis
+        for _, analysis := range srv.analyses {
+            if analysis.userID == userID && analysis.historyCount <= maxHistoryCount {
+                analyses = append(analyses, analysis)
+            }
+        }
+        sort.SliceStable(analyses, func(i, j int) bool {
+            return analyses[i].historyCount < analyses[j].historyCount
+        })
+        history := []map[string]interface{}{}
+        for _, analysis := range analyses {
+            history = append(history, analysis.fields)
+        }
+        resp = map[string]interface{}{"success": true, "response": history}
+    default:
+        http.Error(w, "unknown command", http.StatusBadRequest)
+        return
+    }
+    writeJSON(w, resp)
+}
+
+// Writes a JSON response.
+// w: writes the response
+// resp: the response to encode
+func writeJSON(w http.ResponseWriter, resp interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,20 @@
+package fakeserver
+
+import (
+    "wehe-cmdline-client/internal/stats"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+// Analyzes the throughputs of the original and random replays.
+// original: the throughput samples of the original replays
+// random: the throughput samples of the random replays
+// Returns the analysis
+func computeKS2Result(original []float64, random []float64) testdata.KS2Result {
+    area, pValue := stats.KS2Test(original, random)
+    return testdata.KS2Result{
+        Area0var: area,
+        KS2pVal: pValue,
+        OriginalAvgThroughput: stats.Average(original),
+        RandomAvgThroughput: stats.Average(random),
+    }
+}
@@ -0,0 +1,556 @@
+// Imports replays from packet captures, so that the traffic of any app can be tested without waiting
+// for a replay to be published.
+package importer
+
+import (
+    "crypto/sha1"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net/netip"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+
+    "wehe-cmdline-client/internal/pcap"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    replayFilename = "%s_%s.pcap_client_all.json" // <name>_<date>.pcap_client_all.json, like the published replays
+    replayName = "%s-%s" // <name>-<date>
+    replayDateFormat = "01022006" // MMDDYYYY
+    randomSuffix = "Random" // added to the name of the random replay
+)
+
+// names of apps can only be used in file names and replay names if they are alphanumeric
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// What to import from a capture file.
+type Options struct {
+    CaptureFile string // path to the pcap or pcapng file
+    Protocol string // pcap.ProtocolTCP or pcap.ProtocolUDP; empty to import whichever protocol the flows use
+    ServerPort int // port of the server whose flows are imported; ignored if Flow is given
+    Flow string // the flow to import, in the format <client_ip>:<client_port>-<server_ip>:<server_port>; empty to pick the flows by ServerPort
+    Name string // alphanumeric name of the app, used in the names of the replays and replay files
+    ReplaysDir string // directory to write the replay files to
+    Overwrite bool // true if existing replay files can be replaced
+}
+
+// The replays that were imported from a capture file.
+type Report struct {
+    Name string // name of the app
+    Protocol string // either pcap.ProtocolTCP or pcap.ProtocolUDP
+    Flows []string // the CS pairs of the flows that were imported
+    NumPackets int // number of packets in each replay
+    ClientBytes int // number of payload bytes sent by the client
+    ServerBytes int // number of payload bytes sent by the server
+    Duration time.Duration // time between the first packet of the flows and the last packet the client sent
+    ReplayName string // name of the original replay
+    RandomReplayName string // name of the bit-inverted random replay
+    ReplayFile string // file name of the original replay in the replays directory
+    RandomReplayFile string // file name of the random replay in the replays directory
+    Warnings []string // parts of the capture that could not be imported
+}
+
+// A flow between a client and a server.
+type flowKey struct {
+    protocol string // either pcap.ProtocolTCP or pcap.ProtocolUDP
+    client netip.AddrPort // the client of the flow
+    server netip.AddrPort // the server of the flow
+}
+
+// The segments of a flow, in capture order.
+type flow struct {
+    key flowKey
+    segments []pcap.Segment
+    payloadBytes int // number of payload bytes sent in both directions
+}
+
+// Imports the original replay and a bit-inverted random replay from a capture file, and writes
+// them to the replays directory. The replay files can be loaded with testdata.ParseReplayJSON.
+// options: what to import
+// Returns what was imported, or any errors
+func Import(options Options) (Report, error) {
+    if !namePattern.MatchString(options.Name) {
+        return Report{}, fmt.Errorf("Name '%s' is invalid. Names can only have letters and numbers.", options.Name)
+    }
+    if options.Protocol != "" && options.Protocol != pcap.ProtocolTCP && options.Protocol != pcap.ProtocolUDP {
+        return Report{}, fmt.Errorf("Protocol '%s' is invalid. Choose %s or %s.", options.Protocol, pcap.ProtocolTCP, pcap.ProtocolUDP)
+    }
+    var selectedFlow *flowKey
+    if options.Flow != "" {
+        client, server, err := parseFlow(options.Flow)
+        if err != nil {
+            return Report{}, err
+        }
+        selectedFlow = &flowKey{protocol: options.Protocol, client: client, server: server}
+    } else if options.ServerPort <= 0 || options.ServerPort > 65535 {
+        return Report{}, fmt.Errorf("A server port or a flow is needed to pick the traffic to import.")
+    }
+
+    flows, warnings, err := readFlows(options, selectedFlow)
+    if err != nil {
+        return Report{}, err
+    }
+    flows, flowWarnings, err := pickFlows(flows, options.Protocol)
+    if err != nil {
+        return Report{}, err
+    }
+    warnings = append(warnings, flowWarnings...)
+
+    var imported replay
+    if flows[0].key.protocol == pcap.ProtocolTCP {
+        imported, err = buildTCPReplay(flows[0])
+    } else {
+        imported, err = buildUDPReplay(flows)
+    }
+    if err != nil {
+        return Report{}, err
+    }
+    warnings = append(warnings, imported.warnings...)
+
+    date := imported.startTime.UTC().Format(replayDateFormat)
+    report := Report{
+        Name: options.Name,
+        Protocol: flows[0].key.protocol,
+        NumPackets: len(imported.packets),
+        ClientBytes: imported.clientBytes,
+        ServerBytes: imported.serverBytes,
+        Duration: imported.duration,
+        ReplayName: fmt.Sprintf(replayName, options.Name, date),
+        RandomReplayName: fmt.Sprintf(replayName, options.Name + randomSuffix, date),
+        ReplayFile: fmt.Sprintf(replayFilename, options.Name, date),
+        RandomReplayFile: fmt.Sprintf(replayFilename, options.Name + randomSuffix, date),
+        Warnings: warnings,
+    }
+    for _, f := range flows {
+        report.Flows = append(report.Flows, formatCSPair(f.key))
+    }
+
+    original := imported.toReplayFile(report.ReplayName, false)
+    random := imported.toReplayFile(report.RandomReplayName, true)
+    err = writeReplayFiles(options.ReplaysDir, options.Overwrite, map[string]testdata.ReplayFile{
+        report.ReplayFile: original,
+        report.RandomReplayFile: random,
+    })
+    if err != nil {
+        return Report{}, err
+    }
+    return report, nil
+}
+
+// Parses a flow given on the command line.
+// flowString: the flow in the format <client_ip>:<client_port>-<server_ip>:<server_port>; IPv6
+//             addresses are in brackets, ex. [2001:db8::1]:50000
+// Returns the client and server of the flow, or any errors
+func parseFlow(flowString string) (netip.AddrPort, netip.AddrPort, error) {
+    clientServer := strings.Split(flowString, "-")
+    if len(clientServer) != 2 {
+        return netip.AddrPort{}, netip.AddrPort{}, fmt.Errorf("Flow '%s' is invalid. Should be in format <client_ip>:<client_port>-<server_ip>:<server_port>", flowString)
+    }
+    client, err := netip.ParseAddrPort(clientServer[0])
+    if err != nil {
+        return netip.AddrPort{}, netip.AddrPort{}, fmt.Errorf("Client of flow '%s' is invalid: %v", flowString, err)
+    }
+    server, err := netip.ParseAddrPort(clientServer[1])
+    if err != nil {
+        return netip.AddrPort{}, netip.AddrPort{}, fmt.Errorf("Server of flow '%s' is invalid: %v", flowString, err)
+    }
+    return client, server, nil
+}
+
+// Reads the segments of the flows that match the options from the capture file.
+// options: what to import
+// selectedFlow: the flow to import; nil to pick the flows by options.ServerPort
+// Returns the flows in the order that they first appear, warnings about frames that could not be
+//     decoded, or any errors
+func readFlows(options Options, selectedFlow *flowKey) ([]*flow, []string, error) {
+    file, err := os.Open(options.CaptureFile)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer file.Close()
+    reader, err := pcap.NewReader(file)
+    if err != nil {
+        return nil, nil, fmt.Errorf("%s: %v", options.CaptureFile, err)
+    }
+
+    var flows []*flow
+    flowIndexes := make(map[flowKey]int)
+    numFragments := 0
+    numUndecoded := 0
+    for {
+        frame, err := reader.Next()
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        if err != nil {
+            return nil, nil, fmt.Errorf("%s: %v", options.CaptureFile, err)
+        }
+        segment, err := pcap.Decode(frame)
+        if errors.Is(err, pcap.ErrNotTCPOrUDP) {
+            continue
+        } else if errors.Is(err, pcap.ErrFragment) {
+            numFragments += 1
+            continue
+        } else if err != nil {
+            numUndecoded += 1
+            continue
+        }
+        if options.Protocol != "" && segment.Protocol != options.Protocol {
+            continue
+        }
+
+        key, ok := getFlowKey(segment, selectedFlow, options.ServerPort, flowIndexes)
+        if !ok {
+            continue
+        }
+        i, ok := flowIndexes[key]
+        if !ok {
+            i = len(flows)
+            flowIndexes[key] = i
+            flows = append(flows, &flow{key: key})
+        }
+        if segment.Truncated && len(segment.Payload) > 0 {
+            return nil, nil, fmt.Errorf("%s: frame %d of flow %s was cut short by the snap length of the capture. Capture again with a larger snap length (ex. tcpdump -s 0).", options.CaptureFile, segment.FrameIndex, formatCSPair(key))
+        }
+        flows[i].segments = append(flows[i].segments, segment)
+        flows[i].payloadBytes += len(segment.Payload)
+    }
+
+    var warnings []string
+    if numFragments > 0 {
+        warnings = append(warnings, fmt.Sprintf("%d IP fragments were skipped; fragmented packets cannot be imported.", numFragments))
+    }
+    if numUndecoded > 0 {
+        warnings = append(warnings, fmt.Sprintf("%d frames could not be decoded and were skipped.", numUndecoded))
+    }
+    return flows, warnings, nil
+}
+
+// Gets the flow that a segment belongs to.
+// segment: the segment
+// selectedFlow: the flow to import; nil to pick the flows by serverPort
+// serverPort: port of the server whose flows are imported
+// flowIndexes: the flows found so far, so that a flow keeps the direction it was first seen in
+// Returns the flow, and false if the segment is not part of a flow that is imported
+func getFlowKey(segment pcap.Segment, selectedFlow *flowKey, serverPort int, flowIndexes map[flowKey]int) (flowKey, bool) {
+    forward := flowKey{protocol: segment.Protocol, client: segment.Src, server: segment.Dst}
+    backward := flowKey{protocol: segment.Protocol, client: segment.Dst, server: segment.Src}
+    if selectedFlow != nil {
+        for _, key := range []flowKey{forward, backward} {
+            if key.client == selectedFlow.client && key.server == selectedFlow.server {
+                return key, true
+            }
+        }
+        return flowKey{}, false
+    }
+
+    if _, ok := flowIndexes[forward]; ok {
+        return forward, true
+    }
+    if _, ok := flowIndexes[backward]; ok {
+        return backward, true
+    }
+    if int(segment.Dst.Port()) == serverPort {
+        return forward, true
+    }
+    if int(segment.Src.Port()) == serverPort {
+        return backward, true
+    }
+    return flowKey{}, false
+}
+
+// Picks the flows to import. A TCP replay has a single connection, so the TCP flow that carries the
+// most data is picked. A UDP replay has a flow for each client port, so all the UDP flows are
+// picked.
+// flows: the flows that match the options
+// protocol: the protocol to import; empty if it was not given
+// Returns the flows to import, warnings about flows that were left out, or any errors
+func pickFlows(flows []*flow, protocol string) ([]*flow, []string, error) {
+    var tcpFlows, udpFlows []*flow
+    for _, f := range flows {
+        if f.key.protocol == pcap.ProtocolTCP {
+            tcpFlows = append(tcpFlows, f)
+        } else {
+            udpFlows = append(udpFlows, f)
+        }
+    }
+    if len(tcpFlows) == 0 && len(udpFlows) == 0 {
+        return nil, nil, fmt.Errorf("No TCP or UDP flows in the capture match.")
+    }
+    if len(tcpFlows) > 0 && len(udpFlows) > 0 {
+        return nil, nil, fmt.Errorf("Both TCP and UDP flows match. Choose one with the protocol option.")
+    }
+    if len(udpFlows) > 0 {
+        return udpFlows, nil, nil
+    }
+
+    sort.SliceStable(tcpFlows, func(i, j int) bool {
+        return tcpFlows[i].payloadBytes > tcpFlows[j].payloadBytes
+    })
+    var warnings []string
+    for _, f := range tcpFlows[1:] {
+        warnings = append(warnings, fmt.Sprintf("TCP flow %s (%d bytes) was not imported; a replay has one TCP connection. Choose it with the flow option to import it instead.", formatCSPair(f.key), f.payloadBytes))
+    }
+    return tcpFlows[:1], warnings, nil
+}
+
+// A packet of a replay being imported.
+type replayPacket struct {
+    csPair string // the CS pair of the flow of the packet
+    timestamp time.Duration // time since the start of the replay
+    payload []byte // the bytes that the client sends
+    response []byte // the bytes that the server sends in response, TCP only
+    end bool // true if this is the last packet of its flow, UDP only
+}
+
+// A replay being imported.
+type replay struct {
+    isTCP bool // true if the replay is TCP; false if it is UDP
+    packets []*replayPacket // the packets that the client sends
+    csPairs []string // the CS pairs of the flows of the replay
+    udpClientPorts []string // the client ports of the flows of a UDP replay
+    startTime time.Time // time of the first packet of the flows
+    duration time.Duration // time between the first packet of the flows and the last packet the client sent
+    clientBytes int // number of payload bytes sent by the client
+    serverBytes int // number of payload bytes sent by the server
+    warnings []string // parts of the flows that could not be imported
+}
+
+// Builds a TCP replay from a TCP flow. The client and server streams are reassembled, skipping
+// retransmissions. Each segment of the client stream is a packet, and the bytes that the server
+// sends until the next client segment are its response.
+// f: the TCP flow
+// Returns the replay, or any errors
+func buildTCPReplay(f *flow) (replay, error) {
+    r := replay{
+        isTCP: true,
+        csPairs: []string{formatCSPair(f.key)},
+        startTime: f.segments[0].Timestamp,
+    }
+    var clientStream, serverStream tcpStream
+    var lastTimestamp time.Duration
+    unansweredBytes := 0 // bytes the server sent before the client sent anything
+    for _, segment := range f.segments {
+        timestamp := max(segment.Timestamp.Sub(r.startTime), lastTimestamp) // timestamps cannot go backwards
+        lastTimestamp = timestamp
+        if segment.Src == f.key.client {
+            for _, data := range clientStream.add(segment) {
+                r.packets = append(r.packets, &replayPacket{
+                    csPair: r.csPairs[0],
+                    timestamp: timestamp,
+                    payload: data,
+                })
+                r.clientBytes += len(data)
+                r.duration = timestamp
+            }
+        } else {
+            for _, data := range serverStream.add(segment) {
+                r.serverBytes += len(data)
+                if len(r.packets) == 0 {
+                    unansweredBytes += len(data)
+                    continue
+                }
+                lastPacket := r.packets[len(r.packets) - 1]
+                lastPacket.response = append(lastPacket.response, data...)
+            }
+        }
+    }
+
+    if len(r.packets) == 0 {
+        return replay{}, fmt.Errorf("The client did not send any data in TCP flow %s.", r.csPairs[0])
+    }
+    if unansweredBytes > 0 {
+        r.warnings = append(r.warnings, fmt.Sprintf("The server sent %d bytes before the client sent anything; replays start with the client, so they were left out.", unansweredBytes))
+    }
+    if missingBytes := clientStream.pendingBytes(); missingBytes > 0 {
+        r.warnings = append(r.warnings, fmt.Sprintf("%d bytes that the client sent came after data that was not captured and were left out.", missingBytes))
+    }
+    if missingBytes := serverStream.pendingBytes(); missingBytes > 0 {
+        r.warnings = append(r.warnings, fmt.Sprintf("%d bytes that the server sent came after data that was not captured and were left out.", missingBytes))
+    }
+    return r, nil
+}
+
+// Builds a UDP replay from UDP flows. Each datagram that a client sends is a packet, and the last
+// datagram of each flow ends the flow.
+// flows: the UDP flows, in the order that they first appear
+// Returns the replay, or any errors
+func buildUDPReplay(flows []*flow) (replay, error) {
+    var segments []pcap.Segment
+    lastPackets := make(map[string]*replayPacket) // the last packet of each flow, keyed by CS pair
+    r := replay{isTCP: false}
+    for _, f := range flows {
+        csPair := formatCSPair(f.key)
+        r.csPairs = append(r.csPairs, csPair)
+        r.udpClientPorts = append(r.udpClientPorts, fmt.Sprint(f.key.client.Port()))
+        segments = append(segments, f.segments...)
+    }
+    // merge the flows back into capture order
+    sort.SliceStable(segments, func(i, j int) bool {
+        return segments[i].FrameIndex < segments[j].FrameIndex
+    })
+    r.startTime = segments[0].Timestamp
+
+    var lastTimestamp time.Duration
+    for _, segment := range segments {
+        timestamp := max(segment.Timestamp.Sub(r.startTime), lastTimestamp) // timestamps cannot go backwards
+        lastTimestamp = timestamp
+        for _, f := range flows {
+            if segment.Src == f.key.client && segment.Dst == f.key.server {
+                packet := &replayPacket{
+                    csPair: formatCSPair(f.key),
+                    timestamp: timestamp,
+                    payload: segment.Payload,
+                }
+                r.packets = append(r.packets, packet)
+                lastPackets[packet.csPair] = packet
+                r.clientBytes += len(segment.Payload)
+                r.duration = timestamp
+            } else if segment.Src == f.key.server && segment.Dst == f.key.client {
+                r.serverBytes += len(segment.Payload)
+            }
+        }
+    }
+
+    if len(r.packets) == 0 {
+        return replay{}, fmt.Errorf("The client did not send any datagrams in UDP flows %s.", strings.Join(r.csPairs, ", "))
+    }
+    for _, packet := range lastPackets {
+        packet.end = true
+    }
+    // flows where the client never sent anything cannot be replayed
+    var udpClientPorts []string
+    for i, csPair := range r.csPairs {
+        if _, ok := lastPackets[csPair]; ok {
+            udpClientPorts = append(udpClientPorts, r.udpClientPorts[i])
+        } else {
+            r.warnings = append(r.warnings, fmt.Sprintf("The client did not send any datagrams in UDP flow %s, so it was left out.", csPair))
+        }
+    }
+    r.udpClientPorts = udpClientPorts
+    return r, nil
+}
+
+// Converts the replay into a replay file.
+// name: the name of the replay
+// invert: true to invert every bit of the payloads and responses, making the random replay
+// Returns the replay file
+func (r replay) toReplayFile(name string, invert bool) testdata.ReplayFile {
+    replayFile := testdata.ReplayFile{
+        Version: testdata.ReplayFileVersion,
+        ReplayName: name,
+        Protocol: testdata.ProtocolUDP,
+        UDPClientPorts: r.udpClientPorts,
+    }
+    if r.isTCP {
+        replayFile.Protocol = testdata.ProtocolTCP
+        replayFile.UDPClientPorts = nil
+        replayFile.TCPCSPairs = r.csPairs
+    }
+
+    for _, packet := range r.packets {
+        payload := packet.payload
+        response := packet.response
+        if invert {
+            payload = invertBits(payload)
+            response = invertBits(response)
+        }
+        replayFilePacket := testdata.ReplayFilePacket{
+            CSPair: packet.csPair,
+            // timestamps are rounded to microseconds, like in the published replays
+            Timestamp: math.Round(packet.timestamp.Seconds() * 1e6) / 1e6,
+            Payload: hex.EncodeToString(payload),
+        }
+        if r.isTCP {
+            responseLength := len(response)
+            replayFilePacket.ResponseLength = &responseLength
+            if responseLength > 0 {
+                hash := sha1.Sum(response)
+                responseHash := hex.EncodeToString(hash[:])
+                replayFilePacket.ResponseHash = &responseHash
+            }
+        } else {
+            end := packet.end
+            replayFilePacket.End = &end
+        }
+        replayFile.Packets = append(replayFile.Packets, replayFilePacket)
+    }
+    return replayFile
+}
+
+// Inverts every bit of some bytes. The random replays of Wehe are the original replays with every
+// bit inverted, so that they are the same size and timing but cannot be classified by their content.
+// data: the bytes to invert
+// Returns the inverted bytes
+func invertBits(data []byte) []byte {
+    inverted := make([]byte, len(data))
+    for i, b := range data {
+        inverted[i] = ^b
+    }
+    return inverted
+}
+
+// Formats a flow as a CS pair, the format used by the replay files.
+// key: the flow
+// Returns the CS pair in the form {client_IP}.{client_port}-{server_IP}.{server_port}
+func formatCSPair(key flowKey) string {
+    return formatCSPairAddress(key.client) + "-" + formatCSPairAddress(key.server)
+}
+
+// Formats one side of a flow for a CS pair. IPv4 octets are zero-padded to 3 digits and ports to
+// 5 digits, like in the published replays.
+// addrPort: the IP and port
+// Returns the IP and port in the form {IP}.{port}
+func formatCSPairAddress(addrPort netip.AddrPort) string {
+    addr := addrPort.Addr().Unmap()
+    if addr.Is4() {
+        octets := addr.As4()
+        return fmt.Sprintf("%03d.%03d.%03d.%03d.%05d", octets[0], octets[1], octets[2], octets[3], addrPort.Port())
+    }
+    return fmt.Sprintf("%s.%05d", addr, addrPort.Port())
+}
+
+// Validates and writes replay files. No file is written unless all of them are valid and none of
+// them would replace an existing file, unless overwrite is true.
+// replaysDir: directory to write the replay files to
+// overwrite: true if existing replay files can be replaced
+// replayFiles: the replay files to write, keyed by file name
+// Returns any errors
+func writeReplayFiles(replaysDir string, overwrite bool, replayFiles map[string]testdata.ReplayFile) error {
+    encoded := make(map[string][]byte)
+    for filename, replayFile := range replayFiles {
+        _, err := replayFile.ReplayInfo()
+        if err != nil {
+            return fmt.Errorf("Imported replay %s is invalid: %v", filename, err)
+        }
+        data, err := replayFile.Encode()
+        if err != nil {
+            return err
+        }
+        path := filepath.Join(replaysDir, filename)
+        if _, err := os.Stat(path); err == nil && !overwrite {
+            return fmt.Errorf("Replay file %s already exists. Use the overwrite option to replace it.", path)
+        }
+        encoded[path] = data
+    }
+
+    err := os.MkdirAll(replaysDir, 0755)
+    if err != nil {
+        return err
+    }
+    for path, data := range encoded {
+        err = os.WriteFile(path, append(data, '\n'), 0644)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
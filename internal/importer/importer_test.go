@@ -0,0 +1,297 @@
+package importer
+
+import (
+    "crypto/sha1"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "wehe-cmdline-client/internal/pcap"
+    "wehe-cmdline-client/internal/pcap/pcaptest"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    testClient = "10.0.0.1:50000"
+    testServer = "10.0.0.2:443"
+)
+
+// Writes a capture file.
+func writeCapture(t *testing.T, dir string, filename string, data []byte) string {
+    captureFile := filepath.Join(dir, filename)
+    err := os.WriteFile(captureFile, data, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return captureFile
+}
+
+// Writes a pcap file with raw IP frames.
+func writePcap(t *testing.T, dir string, packets []pcaptest.Packet) string {
+    return writeCapture(t, dir, "capture.pcap", pcaptest.Pcap(binary.LittleEndian, false, pcap.LinkTypeRaw, packets...))
+}
+
+// Makes the packets of a TCP connection in which the client sends "hello" and "bye", and the server
+// answers "world" to the first.
+func tcpPackets(client string, server string) []pcaptest.Packet {
+    return []pcaptest.Packet{
+        {Protocol: pcaptest.ProtocolTCP, Src: client, Dst: server, Seq: 99, SYN: true},
+        {Protocol: pcaptest.ProtocolTCP, Src: server, Dst: client, Seq: 499, SYN: true, Time: 10 * time.Millisecond},
+        {Protocol: pcaptest.ProtocolTCP, Src: client, Dst: server, Seq: 100, Payload: "hello", Time: 20 * time.Millisecond},
+        {Protocol: pcaptest.ProtocolTCP, Src: client, Dst: server, Seq: 100, Payload: "hello", Time: 30 * time.Millisecond}, // retransmission
+        {Protocol: pcaptest.ProtocolTCP, Src: server, Dst: client, Seq: 503, Payload: "ld", Time: 40 * time.Millisecond}, // out of order
+        {Protocol: pcaptest.ProtocolTCP, Src: server, Dst: client, Seq: 500, Payload: "wor", Time: 50 * time.Millisecond},
+        {Protocol: pcaptest.ProtocolTCP, Src: client, Dst: server, Seq: 105, Payload: "bye", Time: 1500 * time.Millisecond},
+    }
+}
+
+func sha1Hex(data string) string {
+    hash := sha1.Sum([]byte(data))
+    return hex.EncodeToString(hash[:])
+}
+
+func TestImportTCP(t *testing.T) {
+    dir := t.TempDir()
+    replaysDir := filepath.Join(dir, "replays")
+    captureFile := writePcap(t, dir, append(tcpPackets(testClient, testServer),
+        pcaptest.Packet{Protocol: pcaptest.ProtocolUDP, Src: "10.0.0.1:53000", Dst: "10.0.0.3:53", Payload: "dns", Time: 1600 * time.Millisecond}, // other traffic
+    ))
+
+    report, err := Import(Options{CaptureFile: captureFile, ServerPort: 443, Name: "Test", ReplaysDir: replaysDir})
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if report.Protocol != pcap.ProtocolTCP || report.NumPackets != 2 || report.ClientBytes != 8 || report.ServerBytes != 5 || len(report.Warnings) != 0 {
+        t.Errorf("Unexpected report: %+v", report)
+    }
+    if report.ReplayFile != "Test_11142023.pcap_client_all.json" || report.RandomReplayName != "TestRandom-11142023" {
+        t.Errorf("Unexpected replay names: %+v", report)
+    }
+
+    replayInfo, err := testdata.ParseReplayJSON(filepath.Join(replaysDir, report.ReplayFile))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if !replayInfo.IsTCP || replayInfo.ReplayName != "Test-11142023" || replayInfo.CSPair.ServerPort != 443 || len(replayInfo.Packets) != 2 {
+        t.Fatalf("Unexpected replay info: %+v", replayInfo)
+    }
+    first := replayInfo.Packets[0].(*testdata.TCPPacket)
+    last := replayInfo.Packets[1].(*testdata.TCPPacket)
+    if string(first.Payload) != "hello" || first.ResponseLength != 5 || first.ResponseHash != sha1Hex("world") {
+        t.Errorf("Unexpected first packet: %+v", first)
+    }
+    if string(last.Payload) != "bye" || last.ResponseLength != 0 || last.ResponseHash != "" || last.Timestamp.Milliseconds() != 1500 {
+        t.Errorf("Unexpected last packet: %+v", last)
+    }
+
+    randomInfo, err := testdata.ParseReplayJSON(filepath.Join(replaysDir, report.RandomReplayFile))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    random := randomInfo.Packets[0].(*testdata.TCPPacket)
+    if string(invertBits(random.Payload)) != "hello" || random.ResponseHash != sha1Hex(string(invertBits([]byte("world")))) {
+        t.Errorf("Unexpected random packet: %+v", random)
+    }
+
+    // replay files are not replaced unless asked to
+    _, err = Import(Options{CaptureFile: captureFile, ServerPort: 443, Name: "Test", ReplaysDir: replaysDir})
+    if err == nil || !strings.Contains(err.Error(), "already exists") {
+        t.Errorf("Expected error about existing replay file, got %v", err)
+    }
+    _, err = Import(Options{CaptureFile: captureFile, Flow: testClient + "-" + testServer, Name: "Test", ReplaysDir: replaysDir, Overwrite: true})
+    if err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+}
+
+func TestImportCaptureFormats(t *testing.T) {
+    dir := t.TempDir()
+    ipv6Client := "[2001:db8::1]:50000"
+    ipv6Server := "[2001:db8::2]:443"
+    tests := []struct {
+        name string
+        captureFile string
+        options Options
+        csPair string
+    }{
+        {"pcapng nanoseconds", writeCapture(t, dir, "ns.pcapng", pcaptest.Pcapng(pcap.LinkTypeEthernet, 9, tcpPackets(testClient, testServer)...)),
+            Options{ServerPort: 443}, "010.000.000.001.50000-010.000.000.002.00443"},
+        {"pcapng binary resolution", writeCapture(t, dir, "binary.pcapng", pcaptest.Pcapng(pcap.LinkTypeRaw, 0x80 | 20, tcpPackets(testClient, testServer)...)),
+            Options{ServerPort: 443}, "010.000.000.001.50000-010.000.000.002.00443"},
+        {"pcapng milliseconds", writeCapture(t, dir, "ms.pcapng", pcaptest.Pcapng(pcap.LinkTypeRaw, 3, tcpPackets(testClient, testServer)...)),
+            Options{ServerPort: 443}, "010.000.000.001.50000-010.000.000.002.00443"},
+        {"ipv6", writeCapture(t, dir, "ipv6.pcapng", pcaptest.Pcapng(pcap.LinkTypeEthernet, 6, tcpPackets(ipv6Client, ipv6Server)...)),
+            Options{Flow: ipv6Client + "-" + ipv6Server}, "2001:db8::1.50000-2001:db8::2.00443"},
+    }
+    for i, test := range tests {
+        test.options.CaptureFile = test.captureFile
+        test.options.Name = fmt.Sprintf("Test%d", i)
+        test.options.ReplaysDir = dir
+        report, err := Import(test.options)
+        if err != nil {
+            t.Fatalf("%s: unexpected error: %v", test.name, err)
+        }
+        if report.NumPackets != 2 || report.ClientBytes != 8 || report.ServerBytes != 5 || strings.Join(report.Flows, ",") != test.csPair || report.Duration != 1500 * time.Millisecond {
+            t.Errorf("%s: unexpected report: %+v", test.name, report)
+        }
+        replayInfo, err := testdata.ParseReplayJSON(filepath.Join(dir, report.ReplayFile))
+        if err != nil {
+            t.Fatalf("%s: unexpected error: %v", test.name, err)
+        }
+        first := replayInfo.Packets[0].(*testdata.TCPPacket)
+        last := replayInfo.Packets[1].(*testdata.TCPPacket)
+        if string(first.Payload) != "hello" || first.ResponseHash != sha1Hex("world") || last.Timestamp.Milliseconds() != 1500 {
+            t.Errorf("%s: unexpected packets: %+v, %+v", test.name, first, last)
+        }
+    }
+}
+
+func TestImportTruncated(t *testing.T) {
+    dir := t.TempDir()
+
+    // headers without payloads can be cut short, since only the payloads are replayed
+    packets := tcpPackets(testClient, testServer)
+    packets[0].SnapLength = 40
+    captureFile := writePcap(t, dir, packets)
+    _, err := Import(Options{CaptureFile: captureFile, ServerPort: 443, Name: "Test", ReplaysDir: dir})
+    if err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+
+    // the payload of a request cannot be replayed if it was not captured
+    packets = tcpPackets(testClient, testServer)
+    packets[2].SnapLength = 42
+    captureFile = writePcap(t, dir, packets)
+    _, err = Import(Options{CaptureFile: captureFile, ServerPort: 443, Name: "Test", ReplaysDir: dir, Overwrite: true})
+    if err == nil || !strings.Contains(err.Error(), "snap length") {
+        t.Errorf("Expected error about the snap length, got %v", err)
+    }
+}
+
+func TestImportUDP(t *testing.T) {
+    dir := t.TempDir()
+    captureFile := writePcap(t, dir, []pcaptest.Packet{
+        {Protocol: pcaptest.ProtocolUDP, Src: "10.0.0.1:50001", Dst: "10.0.0.2:9000", Payload: "a1"},
+        {Protocol: pcaptest.ProtocolUDP, Src: "10.0.0.1:50002", Dst: "10.0.0.2:9000", Payload: "b1", Time: 5 * time.Millisecond},
+        {Protocol: pcaptest.ProtocolUDP, Src: "10.0.0.2:9000", Dst: "10.0.0.1:50001", Payload: "reply", Time: 7 * time.Millisecond},
+        {Protocol: pcaptest.ProtocolUDP, Src: "10.0.0.1:50001", Dst: "10.0.0.2:9000", Payload: "a2", Time: 20 * time.Millisecond},
+        {Protocol: pcaptest.ProtocolUDP, Src: "10.0.0.1:50002", Dst: "10.0.0.2:9000", Payload: "b2", Time: 25 * time.Millisecond},
+        {Protocol: pcaptest.ProtocolUDP, Src: "10.0.0.1:50001", Dst: "10.0.0.2:9000", Payload: "a3", Time: 40 * time.Millisecond},
+    })
+
+    report, err := Import(Options{CaptureFile: captureFile, ServerPort: 9000, Name: "Call", ReplaysDir: dir})
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if report.Protocol != pcap.ProtocolUDP || len(report.Flows) != 2 || report.NumPackets != 5 || report.ServerBytes != 5 {
+        t.Errorf("Unexpected report: %+v", report)
+    }
+
+    replayInfo, err := testdata.ParseReplayJSON(filepath.Join(dir, report.ReplayFile))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if replayInfo.IsTCP || len(replayInfo.UDPFlows) != 2 || replayInfo.UDPFlows[0].CSPair.ClientPort != 50001 {
+        t.Fatalf("Unexpected replay info: %+v", replayInfo)
+    }
+    var ends []string
+    for _, p := range replayInfo.Packets {
+        packet := p.(*testdata.UDPPacket)
+        if packet.End {
+            ends = append(ends, string(packet.Payload))
+        }
+    }
+    if strings.Join(ends, ",") != "b2,a3" {
+        t.Errorf("Expected the last packet of each flow to end it, got %v", ends)
+    }
+
+    _, err = Import(Options{CaptureFile: captureFile, ServerPort: 9000, Protocol: pcap.ProtocolTCP, Name: "Call", ReplaysDir: dir})
+    if err == nil {
+        t.Errorf("Expected error when no flows match")
+    }
+    _, err = Import(Options{CaptureFile: captureFile, ServerPort: 9000, Name: "Call-2", ReplaysDir: dir})
+    if err == nil {
+        t.Errorf("Expected error for invalid name")
+    }
+}
+
+func TestAddTest(t *testing.T) {
+    testsConfigFile := filepath.Join(t.TempDir(), "tests_list.json")
+    report := Report{Name: "Test", ClientBytes: 300000, ServerBytes: 600000, ReplayFile: "a.json", RandomReplayFile: "b.json"}
+    test, err := report.NewTest("", "video")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if test.Name != "Test" || test.Image != "test" || test.Category != testdata.CategoryVideo || test.Size != 2 || test.Time != 1 || !test.Imported {
+        t.Errorf("Unexpected test: %+v", test)
+    }
+    _, err = report.NewTest("", "games")
+    if err == nil {
+        t.Errorf("Expected error for invalid category")
+    }
+
+    replaced, err := AddTest(testsConfigFile, test, false)
+    if err != nil || replaced {
+        t.Fatalf("Expected test to be added, got %v, %v", replaced, err)
+    }
+    test.Name = "Test App"
+    replaced, err = AddTest(testsConfigFile, test, false)
+    if err != nil || !replaced {
+        t.Fatalf("Expected test to be replaced, got %v, %v", replaced, err)
+    }
+    tests, err := testdata.LoadTests(testsConfigFile)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(tests) != 1 || tests[0].Name != "Test App" || tests[0].DataFile != "a.json" || !tests[0].Imported {
+        t.Errorf("Unexpected tests: %+v", tests)
+    }
+
+    // a test from the update server is only replaced with the overwrite option
+    published := testdata.Test{Name: "Published", Image: "test", DataFile: "c.json", RandomDataFile: "d.json", Category: testdata.CategoryVideo}
+    err = os.WriteFile(testsConfigFile, []byte(`[{"name": "Published", "image": "test", "datafile": "c.json", "randomdatafile": "d.json", "category": "VIDEO"}]`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = CheckTest(testsConfigFile, test, false)
+    if err == nil {
+        t.Errorf("Expected error when replacing a test that was not imported")
+    }
+    _, err = AddTest(testsConfigFile, test, false)
+    if err == nil {
+        t.Errorf("Expected error when replacing a test that was not imported")
+    }
+    tests, err = testdata.LoadTests(testsConfigFile)
+    if err != nil || len(tests) != 1 || tests[0] != published {
+        t.Errorf("Expected the published test to be kept, got %+v, %v", tests, err)
+    }
+    replaced, err = AddTest(testsConfigFile, test, true)
+    if err != nil || !replaced {
+        t.Errorf("Expected test to be replaced, got %v, %v", replaced, err)
+    }
+
+    // the fields of other tests that Test does not have are kept
+    err = os.WriteFile(testsConfigFile, []byte(`[{"name": "Published", "image": "published", "datafile": "c.json", "randomdatafile": "d.json", "category": "VIDEO", "popularity": 3}]`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = AddTest(testsConfigFile, test, false)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    data, err := os.ReadFile(testsConfigFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var entries []map[string]interface{}
+    err = json.Unmarshal(data, &entries)
+    if err != nil || len(entries) != 2 || entries[0]["popularity"] != 3.0 || entries[1]["image"] != "test" {
+        t.Errorf("Expected unknown fields to be kept, got %s, %v", data, err)
+    }
+}
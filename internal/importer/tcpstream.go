@@ -0,0 +1,77 @@
+package importer
+
+import (
+    "sort"
+
+    "wehe-cmdline-client/internal/pcap"
+)
+
+// Reassembles one direction of a TCP connection. Retransmitted bytes are dropped, and segments that
+// arrive out of order are held until the bytes before them arrive.
+type tcpStream struct {
+    started bool // true once the first sequence number of the stream is known
+    next uint32 // the sequence number of the next byte of the stream
+    pending []pcap.Segment // segments after a gap in the stream, ordered by sequence number
+}
+
+// Adds a segment to the stream.
+// segment: a segment sent in the direction of the stream
+// Returns the new bytes of the stream that the segment completes, one slice per segment, in order
+func (s *tcpStream) add(segment pcap.Segment) [][]byte {
+    if segment.SYN {
+        if !s.started {
+            s.started = true
+            s.next = segment.Seq + 1
+        }
+        return nil
+    }
+    if len(segment.Payload) == 0 {
+        return nil
+    }
+    if !s.started {
+        // the capture started after the connection was opened
+        s.started = true
+        s.next = segment.Seq
+    }
+
+    s.pending = append(s.pending, segment)
+    sort.SliceStable(s.pending, func(i, j int) bool {
+        return seqBefore(s.pending[i].Seq, s.pending[j].Seq)
+    })
+
+    var data [][]byte
+    for len(s.pending) > 0 {
+        p := s.pending[0]
+        if seqBefore(s.next, p.Seq) {
+            // the bytes before this segment have not arrived yet
+            break
+        }
+        s.pending = s.pending[1:]
+        end := p.Seq + uint32(len(p.Payload))
+        if !seqBefore(s.next, end) {
+            // retransmission of bytes that already arrived
+            continue
+        }
+        data = append(data, p.Payload[s.next - p.Seq:])
+        s.next = end
+    }
+    return data
+}
+
+// Gets the number of bytes that are held after a gap in the stream.
+// Returns the number of bytes
+func (s *tcpStream) pendingBytes() int {
+    numBytes := 0
+    for _, p := range s.pending {
+        numBytes += len(p.Payload)
+    }
+    return numBytes
+}
+
+// Compares two sequence numbers, which wrap around.
+// a: a sequence number
+// b: a sequence number
+// Returns true if a comes before b; false otherwise
+func seqBefore(a uint32, b uint32) bool {
+    return int32(a - b) < 0
+}
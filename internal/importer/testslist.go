@@ -0,0 +1,128 @@
+package importer
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "wehe-cmdline-client/internal/testdata"
+)
+
+// Makes the entry of the tests list for the imported replays. The size and time are estimated from
+// the capture, for both the original and random replays.
+// displayName: name of the test that is displayed to the user; the name of the app if empty
+// category: type of app the test replays; one of the testdata.Category constants, in any case
+// Returns the test, or an error if the category is invalid
+func (r Report) NewTest(displayName string, category string) (testdata.Test, error) {
+    category = strings.ToUpper(category)
+    validCategory := false
+    for _, c := range testdata.Categories {
+        if category == c {
+            validCategory = true
+        }
+    }
+    if !validCategory {
+        return testdata.Test{}, fmt.Errorf("%s is not a category. Choose from %s.", category, strings.ToLower(strings.Join(testdata.Categories, ", ")))
+    }
+    if displayName == "" {
+        displayName = r.Name
+    }
+
+    return testdata.Test{
+        Name: displayName,
+        Size: max(1, int(math.Ceil(float64(2 * (r.ClientBytes + r.ServerBytes)) / 1e6))),
+        Time: max(1, int(math.Ceil(2 * r.Duration.Seconds()))),
+        Image: strings.ToLower(r.Name),
+        DataFile: r.ReplayFile,
+        RandomDataFile: r.RandomReplayFile,
+        Category: category,
+        Imported: true,
+    }, nil
+}
+
+// Checks that a test can be added to the tests list. A test that was imported can always be
+// replaced, so that importing an app again updates its entry, but a test that came from the update
+// server is only replaced if overwrite is true.
+// testsConfigFile: the configuration file name containing information about all the tests
+// test: the test to add
+// overwrite: true if a test that was not imported can be replaced
+// Returns any errors
+func CheckTest(testsConfigFile string, test testdata.Test, overwrite bool) error {
+    _, _, err := loadTestsForAdd(testsConfigFile, test, overwrite)
+    return err
+}
+
+// Adds a test to the tests list. A test in the list with the same selector is replaced if it was
+// imported or if overwrite is true. The tests list is created if it does not exist.
+// testsConfigFile: the configuration file name containing information about all the tests
+// test: the test to add
+// overwrite: true if a test that was not imported can be replaced
+// Returns true if a test was replaced, or any errors
+func AddTest(testsConfigFile string, test testdata.Test, overwrite bool) (bool, error) {
+    entries, index, err := loadTestsForAdd(testsConfigFile, test, overwrite)
+    if err != nil {
+        return false, err
+    }
+    entry, err := json.Marshal(test)
+    if err != nil {
+        return false, err
+    }
+    replaced := index >= 0
+    if replaced {
+        entries[index] = entry
+    } else {
+        entries = append(entries, entry)
+    }
+
+    // the other entries are written back as they were read so that fields this client does not know
+    // about are not lost
+    data, err := json.MarshalIndent(entries, "", "  ")
+    if err != nil {
+        return false, err
+    }
+    err = os.MkdirAll(filepath.Dir(testsConfigFile), 0755)
+    if err != nil {
+        return false, err
+    }
+    // write to a temporary file first so that the tests list is never left half written
+    tmpFile := testsConfigFile + ".import"
+    err = os.WriteFile(tmpFile, append(data, '\n'), 0644)
+    if err != nil {
+        return false, err
+    }
+    err = os.Rename(tmpFile, testsConfigFile)
+    if err != nil {
+        os.Remove(tmpFile)
+        return false, err
+    }
+    return replaced, nil
+}
+
+// Loads the tests list and finds the test that a new test would replace.
+// testsConfigFile: the configuration file name containing information about all the tests
+// test: the test to add
+// overwrite: true if a test that was not imported can be replaced
+// Returns the entries of the tests list, the index of the test with the same selector or -1 if there
+//     is none, or an error if the tests list cannot be read or the test cannot be replaced
+func loadTestsForAdd(testsConfigFile string, test testdata.Test, overwrite bool) ([]json.RawMessage, int, error) {
+    tests, entries, err := testdata.LoadTestEntries(testsConfigFile)
+    if os.IsNotExist(err) {
+        return []json.RawMessage{}, -1, nil
+    } else if err != nil {
+        return nil, -1, err
+    }
+
+    for i, t := range tests {
+        if t.Selector() != test.Selector() {
+            continue
+        }
+        if !t.Imported && !overwrite {
+            return nil, -1, fmt.Errorf("Test %s in %s was not imported. Use the overwrite option to replace it.", t.Selector(), testsConfigFile)
+        }
+        return entries, i, nil
+    }
+    return entries, -1, nil
+}
@@ -0,0 +1,120 @@
+// Provides a leveled logger so that only messages at or above the configured log level are shown.
+package logging
+
+import (
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+    "time"
+)
+
+type Level int // how important a log message is; lower levels are more important
+
+// The log levels match the log_level values in the config file.
+const (
+    UI Level = iota // user-facing progress; always shown
+    WTF // errors that should never happen
+    Error
+    Warn
+    Info
+    Debug // protocol traces
+)
+
+const (
+    timeFormat = "2006-01-02 15:04:05.000"
+)
+
+// Gets the name of the log level.
+// Returns the name of the log level as it appears in the config file
+func (level Level) String() string {
+    switch level {
+    case UI:
+        return "ui"
+    case WTF:
+        return "wtf"
+    case Error:
+        return "error"
+    case Warn:
+        return "warn"
+    case Info:
+        return "info"
+    case Debug:
+        return "debug"
+    default:
+        return "unknown"
+    }
+}
+
+type Logger struct {
+    level Level // messages above this level are not written
+    writer io.Writer // where log messages are written to
+    mutex sync.Mutex // makes sure messages from concurrent replays don't interleave
+}
+
+// Creates a new Logger.
+// level: the highest level of messages to write
+// writer: where log messages are written to
+// Returns a new Logger
+func New(level Level, writer io.Writer) *Logger {
+    return &Logger{
+        level: level,
+        writer: writer,
+    }
+}
+
+// Checks if messages of a log level will be written.
+// level: the log level to check
+// Returns true if messages of the level are written; false otherwise
+func (l *Logger) Enabled(level Level) bool {
+    return l != nil && level <= l.level
+}
+
+// Writes a user-facing progress message. These are always written.
+func (l *Logger) UI(format string, args ...interface{}) {
+    l.log(UI, format, args...)
+}
+
+// Writes a message about something that should never happen.
+func (l *Logger) WTF(format string, args ...interface{}) {
+    l.log(WTF, format, args...)
+}
+
+// Writes an error message.
+func (l *Logger) Error(format string, args ...interface{}) {
+    l.log(Error, format, args...)
+}
+
+// Writes a warning message.
+func (l *Logger) Warn(format string, args ...interface{}) {
+    l.log(Warn, format, args...)
+}
+
+// Writes an informational message.
+func (l *Logger) Info(format string, args ...interface{}) {
+    l.log(Info, format, args...)
+}
+
+// Writes a debugging message, such as a protocol trace.
+func (l *Logger) Debug(format string, args ...interface{}) {
+    l.log(Debug, format, args...)
+}
+
+// Writes a message if its log level is enabled. UI messages are written as is; all other messages
+// are prefixed with the time and log level.
+// level: the log level of the message
+// format: the format string of the message
+// args: the arguments of the format string
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+    if !l.Enabled(level) {
+        return
+    }
+    message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+    if level != UI {
+        message = fmt.Sprintf("%s %s: %s", time.Now().Format(timeFormat), strings.ToUpper(level.String()), message)
+    }
+
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    fmt.Fprintln(l.writer, message)
+}
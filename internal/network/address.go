@@ -0,0 +1,89 @@
+// Helpers for choosing which IP addresses of a server to connect to.
+package network
+
+import (
+    "fmt"
+    "net"
+)
+
+const (
+    IPFamilyAuto = "auto" // use the addresses of any family, in the order they were resolved
+    IPFamilyV4 = "v4" // use only IPv4 addresses
+    IPFamilyV6 = "v6" // use only IPv6 addresses
+
+    IPv4 = "IPv4"
+    IPv6 = "IPv6"
+)
+
+// Checks that an address family preference is valid.
+// ipFamily: the address family preference
+// Returns an error if the preference is not IPFamilyAuto, IPFamilyV4, or IPFamilyV6
+func CheckIPFamily(ipFamily string) error {
+    switch ipFamily {
+    case IPFamilyAuto, IPFamilyV4, IPFamilyV6:
+        return nil
+    }
+    return fmt.Errorf("%s is not an IP family. Choose from %s, %s, or %s.", ipFamily, IPFamilyAuto, IPFamilyV4, IPFamilyV6)
+}
+
+// Determines if an IP address is IPv4 or IPv6.
+// ipAddress: the IP address
+// Returns IPv4 if the address is a v4 address, IPv6 if the address is a v6 address, or an error
+func AddressFamily(ipAddress string) (string, error) {
+    addr := net.ParseIP(ipAddress)
+    if addr == nil {
+        return "", fmt.Errorf("%s is an invalid IP address.\n", ipAddress)
+    }
+
+    if ip4 := addr.To4(); ip4 != nil {
+        return IPv4, nil
+    }
+
+    if ip6 := addr.To16(); ip6 != nil {
+        return IPv6, nil
+    }
+
+    return "", fmt.Errorf("%s is an unknown IP type", ipAddress)
+}
+
+// Gets the network to dial an IP address with, so that the connection uses the address family of
+// the IP even if it could be mapped to the other family.
+// protocol: either "tcp" or "udp"
+// ipAddress: the IP address to dial
+// Returns the network, such as "tcp4" or "udp6"; protocol if the family of the IP is unknown
+func dialNetwork(protocol string, ipAddress string) string {
+    family, err := AddressFamily(ipAddress)
+    if err != nil {
+        return protocol
+    }
+    if family == IPv4 {
+        return protocol + "4"
+    }
+    return protocol + "6"
+}
+
+// Filters IP addresses by address family.
+// ipAddresses: the IP addresses, such as the ones returned by a DNS lookup
+// ipFamily: the address family preference; one of IPFamilyAuto, IPFamilyV4, or IPFamilyV6
+// Returns the addresses of the family, in their original order, or an error if there are none
+func FilterAddresses(ipAddresses []string, ipFamily string) ([]string, error) {
+    err := CheckIPFamily(ipFamily)
+    if err != nil {
+        return nil, err
+    }
+
+    var filtered []string
+    for _, ipAddress := range ipAddresses {
+        family, err := AddressFamily(ipAddress)
+        if err != nil {
+            continue
+        }
+        if ipFamily == IPFamilyAuto || (ipFamily == IPFamilyV4 && family == IPv4) || (ipFamily == IPFamilyV6 && family == IPv6) {
+            filtered = append(filtered, ipAddress)
+        }
+    }
+    if len(filtered) == 0 {
+        return nil, fmt.Errorf("No addresses of IP family %s in %v", ipFamily, ipAddresses)
+    }
+    return filtered, nil
+}
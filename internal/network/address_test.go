@@ -0,0 +1,65 @@
+package network
+
+import (
+    "testing"
+)
+
+func TestFilterAddresses(t *testing.T) {
+    ips := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}
+
+    // Test each family
+    tests := map[string][]string{
+        IPFamilyAuto: ips,
+        IPFamilyV4: {"192.0.2.1", "192.0.2.2"},
+        IPFamilyV6: {"2001:db8::1", "2001:db8::2"},
+    }
+    for ipFamily, expected := range tests {
+        result, err := FilterAddresses(ips, ipFamily)
+        if err != nil {
+            t.Errorf("Unexpected error for %s: %v", ipFamily, err)
+            continue
+        }
+        if len(result) != len(expected) {
+            t.Errorf("Expected %v for %s, got %v", expected, ipFamily, result)
+            continue
+        }
+        for i := range expected {
+            if result[i] != expected[i] {
+                t.Errorf("Expected %v for %s, got %v", expected, ipFamily, result)
+                break
+            }
+        }
+    }
+
+    // Test no addresses in family
+    _, err := FilterAddresses([]string{"192.0.2.1"}, IPFamilyV6)
+    if err == nil {
+        t.Error("Expected error for missing IPv6 address, but got none.")
+    }
+
+    // Test invalid family
+    _, err = FilterAddresses(ips, "v5")
+    if err == nil {
+        t.Error("Expected error for invalid family, but got none.")
+    } else {
+        expectedError := "v5 is not an IP family. Choose from auto, v4, or v6."
+        if err.Error() != expectedError {
+            t.Errorf("Expected error '%s', got '%v'", expectedError, err)
+        }
+    }
+}
+
+func TestAddressFamily(t *testing.T) {
+    result, err := AddressFamily("::ffff:192.0.2.1")
+    if err != nil || result != IPv4 {
+        t.Errorf("Expected %s, got %s (%v)", IPv4, result, err)
+    }
+    result, err = AddressFamily("::1")
+    if err != nil || result != IPv6 {
+        t.Errorf("Expected %s, got %s (%v)", IPv6, result, err)
+    }
+    _, err = AddressFamily("localhost")
+    if err == nil {
+        t.Error("Expected error for hostname, but got none.")
+    }
+}
@@ -0,0 +1,202 @@
+// Validates the responses that the server sends during a TCP replay.
+package network
+
+import (
+    "context"
+    "crypto/sha1"
+    "encoding/hex"
+    "errors"
+    "hash"
+    "strings"
+    "sync"
+    "time"
+
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    ResponsesOK = "ok" // every response that arrived matched the replay file
+    ResponsesBlocked = "blocked" // the connection closed before the responses to the packets that were sent all arrived
+    ResponsesModified = "content modified" // a response did not match the replay file
+)
+
+var (
+    ErrResponseTimeout = errors.New("Timed out waiting for a response from the server")
+    ErrResponsesClosed = errors.New("Server closed the connection before the responses arrived")
+)
+
+// The result of checking the responses of a TCP replay.
+type ResponseCheck struct {
+    Status string // one of ResponsesOK, ResponsesBlocked, or ResponsesModified
+    ExpectedResponses int // number of responses to the packets that were sent
+    CompleteResponses int // number of responses that fully arrived
+    ModifiedResponses int // number of responses that arrived with a hash that did not match the replay file
+    ExpectedBytes int // number of response bytes to the packets that were sent
+    ReceivedBytes int // number of bytes that arrived
+}
+
+// The response to a packet, as recorded in the replay file.
+type expectedResponse struct {
+    length int // the length of the response
+    hash string // the SHA-1 hash of the response, in hex; empty if there is no hash
+}
+
+// Tracks the bytes that arrive during a TCP replay and matches them against the responses in the
+// replay file. The server sends the responses in order, one after another, so each byte belongs to
+// the response after the last complete one. The sender can wait for responses while the receiver
+// adds bytes.
+type ResponseValidator struct {
+    mutex sync.Mutex // guards the fields below
+    progress chan struct{} // signalled when bytes arrive or the connection closes
+    closed bool // true if the connection closed or the replay stopped waiting for responses
+    responses []expectedResponse // the responses in the replay file, in order
+    sent int // number of responses whose packets have been sent; only these responses are expected
+    current int // index of the response that is arriving
+    currentBytes int // number of bytes of the current response that have arrived
+    hasher hash.Hash // hash of the bytes of the current response that have arrived
+    check ResponseCheck // the results so far
+}
+
+// Creates a new ResponseValidator.
+// packets: the packets of the TCP replay
+// Returns a new ResponseValidator
+func NewResponseValidator(packets []testdata.Packet) *ResponseValidator {
+    validator := &ResponseValidator{
+        progress: make(chan struct{}, 1),
+        hasher: sha1.New(),
+        check: ResponseCheck{
+            Status: ResponsesOK,
+        },
+    }
+    for _, p := range packets {
+        packet, ok := p.(*testdata.TCPPacket)
+        if !ok || packet.ResponseLength <= 0 {
+            continue
+        }
+        validator.responses = append(validator.responses, expectedResponse{
+            length: packet.ResponseLength,
+            hash: strings.ToLower(packet.ResponseHash),
+        })
+    }
+    return validator
+}
+
+// Marks that the packet with the next response in the replay file is being sent, so its response is
+// expected. Call it before writing the packet, since the response can arrive before the write
+// returns.
+func (v *ResponseValidator) Sending() {
+    v.mutex.Lock()
+    defer v.mutex.Unlock()
+    if v.sent >= len(v.responses) {
+        return
+    }
+    v.check.ExpectedBytes += v.responses[v.sent].length
+    v.sent += 1
+    v.check.ExpectedResponses = v.sent
+}
+
+// Adds bytes that arrived from the server.
+// data: the bytes that arrived
+func (v *ResponseValidator) Add(data []byte) {
+    v.mutex.Lock()
+    defer v.mutex.Unlock()
+    v.check.ReceivedBytes += len(data)
+    for len(data) > 0 {
+        if v.current >= len(v.responses) {
+            // more bytes than the replay file has, such as an injected page
+            v.check.Status = ResponsesModified
+            return
+        }
+        response := v.responses[v.current]
+        numBytes := min(response.length - v.currentBytes, len(data))
+        v.hasher.Write(data[:numBytes])
+        v.currentBytes += numBytes
+        data = data[numBytes:]
+
+        if v.currentBytes == response.length {
+            if response.hash != "" && hex.EncodeToString(v.hasher.Sum(nil)) != response.hash {
+                v.check.ModifiedResponses += 1
+                v.check.Status = ResponsesModified
+            }
+            v.check.CompleteResponses += 1
+            v.current += 1
+            v.currentBytes = 0
+            v.hasher.Reset()
+        }
+    }
+    // any bytes show that the server is still answering, even if a large response is not complete
+    v.signal()
+}
+
+// Marks that no more responses will arrive because the server closed or reset the connection. If a
+// response to a packet that was sent is missing, the replay was blocked; the responses to packets
+// that were never sent are not expected.
+func (v *ResponseValidator) Closed() {
+    v.mutex.Lock()
+    defer v.mutex.Unlock()
+    v.closed = true
+    if v.check.Status == ResponsesOK && v.check.CompleteResponses < v.sent {
+        v.check.Status = ResponsesBlocked
+    }
+    v.signal()
+}
+
+// Waits until a number of responses have fully arrived. The wait only times out if no bytes arrive
+// for the timeout, so that a slow response, such as a throttled one, is waited for.
+// numResponses: the number of responses to wait for, counted from the start of the replay
+// timeout: the maximum time to wait without any bytes arriving
+// ctx: context that stops the wait when cancelled or when its deadline is reached
+// Returns nil once the responses have arrived, ErrResponseTimeout if the timeout is reached,
+//     ErrResponsesClosed if the connection closed first, or the context's error
+func (v *ResponseValidator) WaitFor(numResponses int, timeout time.Duration, ctx context.Context) error {
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    receivedBytes := -1
+    for {
+        v.mutex.Lock()
+        complete := v.check.CompleteResponses
+        closed := v.closed
+        received := v.check.ReceivedBytes
+        v.mutex.Unlock()
+        if complete >= numResponses {
+            return nil
+        }
+        if closed {
+            return ErrResponsesClosed
+        }
+        if receivedBytes != -1 && received > receivedBytes {
+            if !timer.Stop() {
+                select {
+                case <-timer.C:
+                default:
+                }
+            }
+            timer.Reset(timeout)
+        }
+        receivedBytes = received
+
+        select {
+        case <-v.progress:
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-timer.C:
+            return ErrResponseTimeout
+        }
+    }
+}
+
+// Gets the result of checking the responses.
+// Returns the result
+func (v *ResponseValidator) Result() ResponseCheck {
+    v.mutex.Lock()
+    defer v.mutex.Unlock()
+    return v.check
+}
+
+// Wakes up a sender waiting for responses. Must be called with the mutex held.
+func (v *ResponseValidator) signal() {
+    select {
+    case v.progress <- struct{}{}:
+    default:
+    }
+}
@@ -0,0 +1,169 @@
+package network
+
+import (
+    "context"
+    "crypto/sha1"
+    "encoding/hex"
+    "testing"
+    "time"
+
+    "wehe-cmdline-client/internal/testdata"
+)
+
+// Gets the SHA-1 hash of data in hex.
+func sha1Hex(data []byte) string {
+    hash := sha1.Sum(data)
+    return hex.EncodeToString(hash[:])
+}
+
+func TestResponseValidator(t *testing.T) {
+    first := []byte("HTTP/1.1 200 OK\r\n\r\nhello")
+    second := []byte("world")
+    packets := []testdata.Packet{
+        &testdata.TCPPacket{ResponseLength: len(first), ResponseHash: sha1Hex(first)},
+        &testdata.TCPPacket{ResponseLength: 0},
+        &testdata.TCPPacket{ResponseLength: len(second)},
+    }
+
+    // Test responses that match, split across reads
+    validator := NewResponseValidator(packets)
+    validator.Sending()
+    validator.Sending()
+    validator.Add(first[:10])
+    validator.Add(append(first[10:], second[:2]...))
+    validator.Add(second[2:])
+    validator.Closed()
+    result := validator.Result()
+    expected := ResponseCheck{Status: ResponsesOK, ExpectedResponses: 2, CompleteResponses: 2, ExpectedBytes: 29, ReceivedBytes: 29}
+    if result != expected {
+        t.Errorf("Expected %v, got %v", expected, result)
+    }
+
+    // Test rewritten response
+    validator = NewResponseValidator(packets)
+    validator.Add([]byte("HTTP/1.1 403 Forbidden\r\nhi"))
+    result = validator.Result()
+    if result.Status != ResponsesModified || result.ModifiedResponses != 1 {
+        t.Errorf("Expected 1 modified response, got %v", result)
+    }
+
+    // Test extra bytes
+    validator = NewResponseValidator(packets)
+    validator.Add(append(append(first, second...), []byte("injected")...))
+    result = validator.Result()
+    if result.Status != ResponsesModified || result.ModifiedResponses != 0 {
+        t.Errorf("Expected extra bytes to be flagged as modified, got %v", result)
+    }
+
+    // Test connection closed before the response to a packet that was sent
+    validator = NewResponseValidator(packets)
+    validator.Sending()
+    validator.Sending()
+    validator.Add(first)
+    validator.Closed()
+    result = validator.Result()
+    if result.Status != ResponsesBlocked || result.CompleteResponses != 1 {
+        t.Errorf("Expected blocked after 1 response, got %v", result)
+    }
+
+    // Test connection closed before a packet was sent, with every response to the sent packets
+    validator = NewResponseValidator(packets)
+    validator.Sending()
+    validator.Add(first)
+    validator.Closed()
+    result = validator.Result()
+    expected = ResponseCheck{Status: ResponsesOK, ExpectedResponses: 1, CompleteResponses: 1, ExpectedBytes: 24, ReceivedBytes: 24}
+    if result != expected {
+        t.Errorf("Expected %v, got %v", expected, result)
+    }
+
+    // Test replay stopped before all the responses arrived
+    validator = NewResponseValidator(packets)
+    validator.Add(first)
+    result = validator.Result()
+    if result.Status != ResponsesOK {
+        t.Errorf("Expected %s, got %v", ResponsesOK, result)
+    }
+}
+
+func TestResponseValidatorWaitFor(t *testing.T) {
+    packets := []testdata.Packet{
+        &testdata.TCPPacket{ResponseLength: 5},
+        &testdata.TCPPacket{ResponseLength: 5},
+    }
+    ctx := context.Background()
+
+    // Test response that arrives while waiting
+    validator := NewResponseValidator(packets)
+    validator.Sending()
+    validator.Sending()
+    go func() {
+        time.Sleep(10 * time.Millisecond)
+        validator.Add([]byte("hello"))
+    }()
+    err := validator.WaitFor(1, time.Second, ctx)
+    if err != nil {
+        t.Errorf("Expected response to arrive, got %v", err)
+    }
+
+    // Test response that already arrived
+    err = validator.WaitFor(1, 0, ctx)
+    if err != nil {
+        t.Errorf("Expected response to have arrived, got %v", err)
+    }
+
+    // Test response that never arrives
+    err = validator.WaitFor(2, 10 * time.Millisecond, ctx)
+    if err != ErrResponseTimeout {
+        t.Errorf("Expected %v, got %v", ErrResponseTimeout, err)
+    }
+
+    // Test connection closed while waiting
+    go func() {
+        time.Sleep(10 * time.Millisecond)
+        validator.Closed()
+    }()
+    err = validator.WaitFor(2, time.Second, ctx)
+    if err != ErrResponsesClosed {
+        t.Errorf("Expected %v, got %v", ErrResponsesClosed, err)
+    }
+    if validator.Result().Status != ResponsesBlocked {
+        t.Errorf("Expected %s, got %v", ResponsesBlocked, validator.Result())
+    }
+
+    // Test cancelled context
+    validator = NewResponseValidator(packets)
+    cancelledCtx, cancel := context.WithCancel(ctx)
+    cancel()
+    err = validator.WaitFor(1, time.Second, cancelledCtx)
+    if err != context.Canceled {
+        t.Errorf("Expected %v, got %v", context.Canceled, err)
+    }
+}
+
+func TestResponseValidatorWaitForSlowResponse(t *testing.T) {
+    packets := []testdata.Packet{&testdata.TCPPacket{ResponseLength: 100}}
+    validator := NewResponseValidator(packets)
+    validator.Sending()
+
+    // bytes arrive more often than the timeout, but the response takes longer than the timeout
+    go func() {
+        for i := 0; i < 10; i++ {
+            time.Sleep(10 * time.Millisecond)
+            validator.Add(make([]byte, 10))
+        }
+    }()
+    err := validator.WaitFor(1, 30 * time.Millisecond, context.Background())
+    if err != nil {
+        t.Errorf("Expected the slow response to arrive, got %v", err)
+    }
+
+    // the deadline of the context still ends the wait
+    validator = NewResponseValidator(packets)
+    ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+    defer cancel()
+    err = validator.WaitFor(1, time.Second, ctx)
+    if err != context.DeadlineExceeded {
+        t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+    }
+}
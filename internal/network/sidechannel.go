@@ -12,11 +12,12 @@ import (
     "strings"
     "time"
 
+    "wehe-cmdline-client/internal/logging"
     "wehe-cmdline-client/internal/testdata"
 )
 
 const (
-    sideChannelPort = 55556
+    DefaultSideChannelPort = 55556 // port that Wehe servers listen on for the side channel
 )
 
 type opcode byte // request type to the server
@@ -42,21 +43,25 @@ const (
 type SideChannel struct {
     id int // ID of SideChannel instance
     conn net.Conn // connection to server
+    logger *logging.Logger // logger to write protocol traces to
 }
 
 // Creates a new SideChannel struct.
 // id: ID of the SideChannel instance
 // ip: IP of the server to connect to
+// port: port of the side channel on the server
 // tlsConfig: TLS configuration containing the server cert
+// logger: logger to write protocol traces to
 // Returns new SideChannel struct or any errors
-func NewSideChannel(id int, ip string, tlsConfig *tls.Config) (SideChannel, error) {
-    conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", ip, sideChannelPort), tlsConfig)
+func NewSideChannel(id int, ip string, port int, tlsConfig *tls.Config, logger *logging.Logger) (SideChannel, error) {
+    conn, err := tls.Dial(dialNetwork("tcp", ip), net.JoinHostPort(ip, strconv.Itoa(port)), tlsConfig)
     if err != nil {
         return SideChannel{}, err
     }
     return SideChannel{
         id: id,
         conn: conn,
+        logger: logger,
     }, nil
 }
 
@@ -77,7 +82,7 @@ func (sideChannel SideChannel) SendID(userID string, replayID int, replayName st
     isLastReplayString := strings.Title(strconv.FormatBool(isLastReplay))
 
     message := strings.Join([]string{userID, replayIDString, replayName, numMLabTriesString, testIDString, isLastReplayString, publicIP, clientVersion}, ";")
-    fmt.Println(message)
+    sideChannel.logger.Debug("Side channel %d sending ID: %s", sideChannel.id, message)
     opcodeAndMessageLength := make([]byte, 4)
     binary.BigEndian.PutUint32(opcodeAndMessageLength, uint32(len(message)))
     opcodeAndMessageLength[0] = byte(receiveID)
@@ -108,6 +113,14 @@ func (sideChannel SideChannel) Ask4Permission() ([]string, error) {
     return permission, nil
 }
 
+// Sends information about the client's host and network to the server.
+// stats: JSON string containing the information about the client
+// Returns any errors
+func (sideChannel SideChannel) SendMobileStats(stats string) error {
+    _, err := sideChannel.sendAndReceive(mobileStats, stats)
+    return err
+}
+
 // Send replay duration, throughputs, and sample times to the server after a replay has run.
 // replayDuration: the actual amount of time that was used to run the replay
 // throughputData: the data rate (in Mbps) of each sample
@@ -178,7 +191,7 @@ func (sideChannel SideChannel) CleanUp() {
 // message: the data to send to the server
 // Returns the server response or any errors
 func (sideChannel SideChannel) sendAndReceive(op opcode, message string) (string, error) {
-    fmt.Println("sending:", message)
+    sideChannel.logger.Debug("Side channel %d sending opcode %d: %s", sideChannel.id, op, message)
 
     // send length of request
     // first byte is opcode, last 3 bytes is 24-bit big-endian unsigned message length
@@ -212,15 +225,12 @@ func (sideChannel SideChannel) sendAndReceive(op opcode, message string) (string
     }
 
     responseCode := resp[0]
-    fmt.Println("response code:", responseCode)
+    sideChannel.logger.Debug("Side channel %d received response code %d: %s", sideChannel.id, responseCode, string(resp[1:]))
     if responseCode == byte(okResponse) {
-        fmt.Println("receiving:", string(resp[1:]))
         return string(resp[1:]), nil
     } else if responseCode == byte(errorResponse) {
         return "", fmt.Errorf("Server unable to process request.")
     } else {
         return "", fmt.Errorf("Unknown error.")
     }
-    fmt.Println("receiving:", string(resp))
-    return string(resp), nil
 }
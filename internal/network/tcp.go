@@ -4,18 +4,22 @@ package network
 
 import (
     "context"
-    "fmt"
+    "errors"
     "io"
     "net"
+    "strconv"
+    "syscall"
     "time"
 
     "wehe-cmdline-client/internal/analyzer"
+    "wehe-cmdline-client/internal/logging"
     "wehe-cmdline-client/internal/testdata"
 )
 
 const (
     TCPReplayTimeout = 45 * time.Second // each TCP replay is limited to 40 seconds so that user doesn't have to wait forever
     PortReplayTimeout = 30 * time.Second // port replays run only for 30 seconds though
+    TCPResponseTimeout = 10 * time.Second // how long the responses to earlier packets may stop arriving before the rest of the packets are sent without waiting
 )
 
 type TCPClient struct {
@@ -23,15 +27,20 @@ type TCPClient struct {
     Port int // port that the client should connect to
     Conn *net.Conn // the TCP connection to the server
     Timeout time.Duration // maximum time to run replay
+    ResponseTimeout time.Duration // how long the responses may stop arriving before the rest of the packets are sent without waiting for them
+    Responses *ResponseValidator // checks the responses from the server against the replay file
+    logger *logging.Logger // logger to write packet traces to
 }
 
 // Makes a new TCP client.
 // ip: IP of the server
 // port: port of the server
 // isPortTest: true if replay is a port test; false otherwise
+// packets: the packets of the replay, used to check the responses from the server
+// logger: logger to write packet traces to
 // Returns a new TCP client or any errors
-func NewTCPClient(ip string, port int, isPortTest bool) (TCPClient, error) {
-    conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", ip, port))
+func NewTCPClient(ip string, port int, isPortTest bool, packets []testdata.Packet, logger *logging.Logger) (TCPClient, error) {
+    conn, err := net.Dial(dialNetwork("tcp", ip), net.JoinHostPort(ip, strconv.Itoa(port)))
     if err != nil {
         return TCPClient{}, err
     }
@@ -44,18 +53,30 @@ func NewTCPClient(ip string, port int, isPortTest bool) (TCPClient, error) {
         Port: port,
         Conn: &conn,
         Timeout: timeout,
+        ResponseTimeout: TCPResponseTimeout,
+        Responses: NewResponseValidator(packets),
+        logger: logger,
     }, nil
 }
 
-// Sends TCP packets to the server.
+// Sends TCP packets to the server. If timing is true, the replay follows the request/response
+// structure of the replay file, like the app that was recorded: each packet is sent only after the
+// responses to the earlier packets have arrived. The packets still keep their spacing; if the
+// responses arrive late, the rest of the packets are sent that much later. Slow responses are
+// waited for as long as bytes keep arriving, since throttling is what the replay measures; if no
+// bytes arrive for ResponseTimeout, the rest of the packets are sent without waiting.
 // packets: the packets to send to the server
-// timing: true if packets should be sent at their timestamps; false otherwise
+// timing: true if packets should be sent at their timestamps and wait for responses; false to send
+//         them as fast as possible
 // ctx: context to help with stopping all TCP sending and receiving threads when error occurs
 // cancel: the cancel function to call when error occurs to stop all TCP sending and receiving threads
 // errChan: channel to return any errors
 func (tcpClient TCPClient) SendPackets(packets []testdata.Packet, timing bool, ctx context.Context, cancel context.CancelFunc, errChan chan<- error) {
     startTime := time.Now()
     packetLen := len(packets)
+    numResponses := 0 // number of responses to the packets that have been sent
+    var delay time.Duration // how much later than their timestamps the packets are sent because of slow responses
+    waitForResponses := timing // false once the responses stop arriving
     for i, p := range packets {
         select {
         case <-ctx.Done():
@@ -68,25 +89,68 @@ func (tcpClient TCPClient) SendPackets(packets []testdata.Packet, timing bool, c
             // replays stop after a certain amount of time so that user doesn't have to wait too long
             elapsedTime := time.Now().Sub(startTime)
             if elapsedTime > tcpClient.Timeout {
-                fmt.Println("TIMEOUT:", elapsedTime, tcpClient.Timeout)
+                tcpClient.logger.Info("TCP replay stopped after %s; replays are limited to %s", elapsedTime, tcpClient.Timeout)
                 cancel()
                 errChan <- nil
                 return
             }
 
+            if waitForResponses && numResponses > 0 {
+                // wait for the server to answer the earlier packets, for as long as the replay can run
+                waitCtx, cancelWait := context.WithDeadline(ctx, startTime.Add(tcpClient.Timeout))
+                err := tcpClient.Responses.WaitFor(numResponses, tcpClient.ResponseTimeout, waitCtx)
+                cancelWait()
+                if err == context.DeadlineExceeded {
+                    tcpClient.logger.Info("TCP replay stopped after %s; replays are limited to %s", time.Now().Sub(startTime), tcpClient.Timeout)
+                    cancel()
+                    errChan <- nil
+                    return
+                } else if err == ErrResponseTimeout {
+                    // a server that stops answering is not blocked unless it closes the connection,
+                    // so keep replaying and let the throughput show what happened
+                    tcpClient.logger.Info("No responses arrived for %s before packet %d/%d; sending the rest of the packets without waiting for responses", tcpClient.ResponseTimeout, i + 1, packetLen)
+                    waitForResponses = false
+                } else if err == ErrResponsesClosed {
+                    // the server closed the connection, such as when a middlebox blocks the replay
+                    tcpClient.logger.Info("TCP replay stopped before packet %d/%d: %v", i + 1, packetLen, err)
+                    cancel()
+                    errChan <- nil
+                    return
+                } else if err != nil {
+                    // another SendPackets or RecvPackets thread has errored out
+                    errChan <- nil
+                    return
+                }
+                lateness := time.Now().Sub(startTime.Add(packet.Timestamp + delay))
+                if lateness > 0 {
+                    delay += lateness
+                }
+            }
+
             // allows packets to be sent at the time of the timestamp
             if timing {
-                sleepTime := startTime.Add(packet.Timestamp).Sub(time.Now())
-                time.Sleep(sleepTime)
+                sleepTime := startTime.Add(packet.Timestamp + delay).Sub(time.Now())
+                select {
+                case <-ctx.Done():
+                    errChan <- nil
+                    return
+                case <-time.After(sleepTime):
+                }
             }
 
-            fmt.Printf("Sending packet %d/%d at %s\n", i + 1, packetLen, packet.Timestamp)
+            tcpClient.logger.Debug("Sending packet %d/%d at %s", i + 1, packetLen, packet.Timestamp)
+            if packet.ResponseLength > 0 {
+                tcpClient.Responses.Sending()
+            }
             _, err := (*tcpClient.Conn).Write(packet.Payload)
             if err != nil {
                 cancel()
                 errChan <- nil
                 return
             }
+            if packet.ResponseLength > 0 {
+                numResponses += 1
+            }
         }
     }
     errChan <- nil
@@ -99,21 +163,32 @@ func (tcpClient TCPClient) SendPackets(packets []testdata.Packet, timing bool, c
 // errChan: channel to return any errors
 func (tcpClient TCPClient) RecvPackets(throughputCalculator *analyzer.Analyzer, ctx context.Context, cancel context.CancelFunc, errChan chan<- error) {
     throughputCalculator.Run()
-    defer throughputCalculator.Stop()
+    err := tcpClient.recvPackets(throughputCalculator, ctx, cancel)
+    // stop the analyzer before returning so that the throughputs are ready when the caller is told
+    // that receiving has finished
+    throughputCalculator.Stop()
+    errChan <- err
+}
 
+// Receives TCP packets from the server until the server is done sending or the replay is stopped.
+// The bytes are checked against the responses in the replay file; if the server closes or resets
+// the connection before all the responses arrive, the replay is marked as blocked.
+// throughputCalculator: analyzer to calculate throughputs
+// ctx: context to help with stopping all TCP sending and receiving threads when error occurs
+// cancel: the cancel function to call when error occurs to stop all TCP sending and receiving threads
+// Returns any errors
+func (tcpClient TCPClient) recvPackets(throughputCalculator *analyzer.Analyzer, ctx context.Context, cancel context.CancelFunc) error {
     for {
         select {
         case <-ctx.Done():
             // another SendPackets or RecvPackets thread has errored out
-            errChan <- nil
-            return
+            return nil
         default:
             // don't block trying to read, so that check above can be done to see if another thread has finished
             err := (*tcpClient.Conn).SetReadDeadline(time.Now().Add(1 * time.Second))
             if err != nil {
                 cancel()
-                errChan <- err
-                return
+                return err
             }
 
             buffer := make([]byte, 4096)
@@ -124,20 +199,24 @@ func (tcpClient TCPClient) RecvPackets(throughputCalculator *analyzer.Analyzer,
                     break
                 } else if err == io.EOF {
                     // server finished sending packets and closed its connection
-                    errChan <- nil
-                    return
+                    tcpClient.Responses.Closed()
+                    return nil
+                } else if errors.Is(err, syscall.ECONNRESET) {
+                    // the connection was reset, such as by a middlebox blocking the replay
+                    tcpClient.logger.Warn("TCP replay connection was reset after %d bytes", tcpClient.Responses.Result().ReceivedBytes)
+                    tcpClient.Responses.Closed()
+                    return nil
                 } else {
                     cancel()
-                    errChan <- err
-                    return
+                    return err
                 }
             }
 
+            tcpClient.Responses.Add(buffer[:numBytes])
             throughputCalculator.AddBytesRead(numBytes)
-            fmt.Printf("Received %d bytes from server.\n", numBytes)
+            tcpClient.logger.Debug("Received %d bytes from server.", numBytes)
         }
     }
-    errChan <- nil
 }
 
 
@@ -0,0 +1,105 @@
+package network
+
+import (
+    "context"
+    "io"
+    "net"
+    "testing"
+    "time"
+
+    "wehe-cmdline-client/internal/testdata"
+)
+
+// Runs SendPackets over a pipe. The server reads each packet and then calls respond with the index
+// of the packet, in place of the receiving thread.
+// Returns the time since the start of the replay that each packet was read, and the client
+func sendPackets(t *testing.T, packets []testdata.Packet, respond func(tcpClient TCPClient, i int)) ([]time.Duration, TCPClient) {
+    clientConn, serverConn := net.Pipe()
+    t.Cleanup(func() {
+        clientConn.Close()
+        serverConn.Close()
+    })
+    tcpClient := TCPClient{
+        Conn: &clientConn,
+        Timeout: 5 * time.Second,
+        ResponseTimeout: 100 * time.Millisecond,
+        Responses: NewResponseValidator(packets),
+    }
+
+    startTime := time.Now()
+    readTimes := make(chan []time.Duration)
+    go func() {
+        var times []time.Duration
+        for i, p := range packets {
+            _, err := io.ReadFull(serverConn, make([]byte, len(p.(*testdata.TCPPacket).Payload)))
+            if err != nil {
+                break
+            }
+            times = append(times, time.Now().Sub(startTime))
+            respond(tcpClient, i)
+        }
+        readTimes <- times
+    }()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    errChan := make(chan error, 1)
+    tcpClient.SendPackets(packets, true, ctx, cancel, errChan)
+    if err := <-errChan; err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if ctx.Err() != nil {
+        t.Errorf("Expected the replay to finish without being stopped")
+    }
+    return <-readTimes, tcpClient
+}
+
+var pacedPackets = []testdata.Packet{
+    &testdata.TCPPacket{Payload: []byte("GET /1"), ResponseLength: 100},
+    &testdata.TCPPacket{Timestamp: 10 * time.Millisecond, Payload: []byte("GET /2"), ResponseLength: 100},
+    &testdata.TCPPacket{Timestamp: 20 * time.Millisecond, Payload: []byte("ACK")},
+}
+
+func TestSendPacketsSlowResponse(t *testing.T) {
+    // the first response trickles in over 3 times the response timeout
+    readTimes, tcpClient := sendPackets(t, pacedPackets, func(tcpClient TCPClient, i int) {
+        numChunks := map[int]int{0: 10, 1: 1}[i]
+        for j := 0; j < numChunks; j++ {
+            time.Sleep(30 * time.Millisecond)
+            tcpClient.Responses.Add(make([]byte, 100 / numChunks))
+        }
+    })
+    if len(readTimes) != 3 {
+        t.Fatalf("Expected 3 packets, got %d", len(readTimes))
+    }
+    if readTimes[1] < 300 * time.Millisecond {
+        t.Errorf("Expected the second packet to wait for the first response, sent at %s", readTimes[1])
+    }
+    if result := tcpClient.Responses.Result(); result.Status != ResponsesOK || result.CompleteResponses != 2 {
+        t.Errorf("Expected 2 complete responses, got %v", result)
+    }
+}
+
+func TestSendPacketsStalledResponse(t *testing.T) {
+    // the server stops answering partway through the first response
+    readTimes, tcpClient := sendPackets(t, pacedPackets, func(tcpClient TCPClient, i int) {
+        if i == 0 {
+            tcpClient.Responses.Add(make([]byte, 50))
+        }
+    })
+    if len(readTimes) != 3 {
+        t.Fatalf("Expected the rest of the packets to be sent, got %d", len(readTimes))
+    }
+    if readTimes[1] < 100 * time.Millisecond || readTimes[2] - readTimes[1] > 100 * time.Millisecond {
+        t.Errorf("Expected the packets after the response timeout to be sent without waiting, sent at %v", readTimes)
+    }
+    if result := tcpClient.Responses.Result(); result.Status != ResponsesOK {
+        t.Errorf("Expected a stalled response not to be blocked, got %v", result)
+    }
+
+    // the responses are blocked only if the server closes the connection
+    tcpClient.Responses.Closed()
+    if result := tcpClient.Responses.Result(); result.Status != ResponsesBlocked {
+        t.Errorf("Expected %s, got %v", ResponsesBlocked, result)
+    }
+}
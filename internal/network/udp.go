@@ -3,37 +3,40 @@ package network
 
 import (
     "context"
-    "fmt"
     "net"
     "strconv"
     "time"
 
     "wehe-cmdline-client/internal/analyzer"
+    "wehe-cmdline-client/internal/logging"
     "wehe-cmdline-client/internal/testdata"
 )
 
 //TODO: make sure code when timeout isn't hit on both client and server
 const (
     UDPReplayTimeout = 40 * time.Second // each UDP replay is limited to 45 seconds so that user doesn't have to wait forever
+    UDPFlowIdleTimeout = 2 * time.Second // once a flow has sent its last packet, it stops receiving after the server is quiet for this long
 )
 
 type UDPClient struct {
     IP string // IP that the client should connect to
     Port int // port that the client should connect to
     Conn *net.UDPConn // the UDP connection to the server
+    sent chan struct{} // closed once SendPackets has finished sending
+    logger *logging.Logger // logger to write packet traces to
 }
 
 // Makes a new UDP client.
 // ip: IP of the server
 // port: port of the server
+// logger: logger to write packet traces to
 // Returns a new UDP client or any errors
-func NewUDPClient(ip string, port int) (UDPClient, error) {
-    portStr := strconv.Itoa(port)
-    udpServer, err := net.ResolveUDPAddr("udp", ip + ":" + portStr)
+func NewUDPClient(ip string, port int, logger *logging.Logger) (UDPClient, error) {
+    udpServer, err := net.ResolveUDPAddr(dialNetwork("udp", ip), net.JoinHostPort(ip, strconv.Itoa(port)))
     if err != nil {
         return UDPClient{}, err
     }
-    conn, err := net.DialUDP("udp", nil, udpServer)
+    conn, err := net.DialUDP(dialNetwork("udp", ip), nil, udpServer)
     if err != nil {
         return UDPClient{}, err
     }
@@ -41,18 +44,22 @@ func NewUDPClient(ip string, port int) (UDPClient, error) {
         IP: ip,
         Port: port,
         Conn: conn,
+        sent: make(chan struct{}),
+        logger: logger,
     }, nil
 }
 
-// Sends UDP packets to the server.
+// Sends UDP packets to the server. Sending stops after the packet marked as the end of the flow.
 // packets: the packets to send to the server
 // timing: true if packets should be sent at their timestamps; false otherwise
 // ctx: context to help with stopping all UDP sending and receiving threads when error occurs
 // cancel: the cancel function to call when error occurs to stop all UDP sending and receiving threads
 // errChan: channel to return any errors
 func (udpClient UDPClient) SendPackets(packets []testdata.Packet, timing bool, ctx context.Context, cancel context.CancelFunc, errChan chan<- error) {
+    defer close(udpClient.sent)
     startTime := time.Now()
     packetLen := len(packets)
+sendLoop:
     for i, p := range packets {
         select {
         case <-ctx.Done():
@@ -65,7 +72,7 @@ func (udpClient UDPClient) SendPackets(packets []testdata.Packet, timing bool, c
             // replays stop after a certain amount of time so that user doesn't have to wait too long
             elapsedTime := time.Now().Sub(startTime)
             if elapsedTime > UDPReplayTimeout {
-                fmt.Println("TIMEOUT:", elapsedTime, UDPReplayTimeout)
+                udpClient.logger.Info("UDP replay stopped after %s; replays are limited to %s", elapsedTime, UDPReplayTimeout)
                 cancel()
                 errChan <- nil
                 return
@@ -74,30 +81,42 @@ func (udpClient UDPClient) SendPackets(packets []testdata.Packet, timing bool, c
             // allows packets to be sent at the time of the timestamp
             if timing {
                 sleepTime := startTime.Add(packet.Timestamp).Sub(time.Now())
-                time.Sleep(sleepTime)
+                select {
+                case <-ctx.Done():
+                    errChan <- nil
+                    return
+                case <-time.After(sleepTime):
+                }
             }
 
-            fmt.Printf("Sending packet %d/%d at %s\n", i + 1, packetLen, packet.Timestamp)
+            udpClient.logger.Debug("Sending packet %d/%d at %s", i + 1, packetLen, packet.Timestamp)
             _, err := udpClient.Conn.Write(packet.Payload)
             if err != nil {
                 cancel()
                 errChan <- err
                 return
             }
+            if packet.End {
+                if i + 1 < packetLen {
+                    udpClient.logger.Debug("Flow ended at packet %d/%d; the rest of its packets are not sent", i + 1, packetLen)
+                }
+                break sendLoop
+            }
         }
     }
     errChan <- nil
 }
 
-// Receives UDP packets from the server.
-// throughputCalculator: analyzer to calculate throughputs
+// Receives UDP packets from the server until the replay is stopped, or until the server has been
+// quiet for UDPFlowIdleTimeout after SendPackets has finished. The bytes received are added to each
+// of the analyzers, which must already be running.
+// throughputCalculators: analyzers to calculate throughputs, such as one for the flow and one for the
+//                        whole replay
 // ctx: context to help with stopping all UDP sending and receiving threads when error occurs
 // cancel: the cancel function to call when error occurs to stop all UDP sending and receiving threads
 // errChan: channel to return any errors
-func (udpClient UDPClient) RecvPackets(throughputCalculator *analyzer.Analyzer, ctx context.Context, cancel context.CancelFunc, errChan chan<- error) {
-    throughputCalculator.Run()
-    defer throughputCalculator.Stop()
-
+func (udpClient UDPClient) RecvPackets(throughputCalculators []*analyzer.Analyzer, ctx context.Context, cancel context.CancelFunc, errChan chan<- error) {
+    lastRecvTime := time.Now()
     for {
         select {
         case <-ctx.Done():
@@ -105,8 +124,20 @@ func (udpClient UDPClient) RecvPackets(throughputCalculator *analyzer.Analyzer,
             errChan <- nil
             return
         default:
+            select {
+            case <-udpClient.sent:
+                // the flow has ended; stop once the server is done answering it
+                idleTime := time.Now().Sub(lastRecvTime)
+                if idleTime >= UDPFlowIdleTimeout {
+                    udpClient.logger.Debug("No packets received from server for %s after the flow ended", idleTime)
+                    errChan <- nil
+                    return
+                }
+            default:
+            }
+
             // don't block trying to read, so that check above can be done to see if another thread has finished
-            err := udpClient.Conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+            err := udpClient.Conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
             if err != nil {
                 cancel()
                 errChan <- err
@@ -126,8 +157,11 @@ func (udpClient UDPClient) RecvPackets(throughputCalculator *analyzer.Analyzer,
                 }
             }
 
-            throughputCalculator.AddBytesRead(numBytes)
-            fmt.Printf("Received %d bytes from server.\n", numBytes)
+            lastRecvTime = time.Now()
+            for _, throughputCalculator := range throughputCalculators {
+                throughputCalculator.AddBytesRead(numBytes)
+            }
+            udpClient.logger.Debug("Received %d bytes from server.", numBytes)
         }
     }
 }
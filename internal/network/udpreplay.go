@@ -0,0 +1,96 @@
+// Runs UDP replays that have one or more flows.
+package network
+
+import (
+    "context"
+
+    "wehe-cmdline-client/internal/analyzer"
+    "wehe-cmdline-client/internal/logging"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+// A UDP replay, sent over one socket for each flow in the replay file.
+type UDPReplay struct {
+    Flows []*UDPFlow // the flows of the replay, in the order of the replay file
+}
+
+// A flow of a UDP replay and the socket that it is sent over.
+type UDPFlow struct {
+    CSPair testdata.CSPair // the client & server of the flow in the original packet capture
+    Packets []testdata.Packet // the packets of the flow
+    Client UDPClient // the client that sends and receives the packets of the flow
+    ThroughputCalculator *analyzer.Analyzer // analyzer to calculate the throughputs of only this flow
+}
+
+// Makes a new UDP replay, opening a socket for each flow.
+// ip: IP of the server
+// flows: the flows of the replay
+// replayPort: gets the port that the server listens on from the server port in the replay file
+// logger: logger to write packet traces to
+// Returns a new UDP replay or any errors
+func NewUDPReplay(ip string, flows []testdata.UDPFlow, replayPort func(int) int, logger *logging.Logger) (*UDPReplay, error) {
+    udpReplay := &UDPReplay{}
+    for _, flow := range flows {
+        udpClient, err := NewUDPClient(ip, replayPort(flow.CSPair.ServerPort), logger)
+        if err != nil {
+            udpReplay.CleanUp()
+            return nil, err
+        }
+        udpReplay.Flows = append(udpReplay.Flows, &UDPFlow{
+            CSPair: flow.CSPair,
+            Packets: flow.Packets,
+            Client: udpClient,
+        })
+    }
+    return udpReplay, nil
+}
+
+// Sends and receives the packets of every flow at the same time. The bytes received on every flow
+// are added to throughputCalculator, and the bytes received on each flow are also added to the
+// flow's own analyzer. All of the analyzers are stopped before returning, so that the throughputs
+// are ready.
+// throughputCalculator: analyzer to calculate the throughputs of the whole replay
+// newFlowCalculator: makes the analyzer of a flow
+// timing: true if packets should be sent at their timestamps; false otherwise
+// ctx: context to help with stopping all UDP sending and receiving threads when error occurs
+// cancel: the cancel function to call when error occurs to stop all UDP sending and receiving threads
+// Returns any errors
+func (udpReplay *UDPReplay) Run(throughputCalculator *analyzer.Analyzer, newFlowCalculator func() *analyzer.Analyzer, timing bool, ctx context.Context, cancel context.CancelFunc) error {
+    throughputCalculator.Run()
+    for _, flow := range udpReplay.Flows {
+        flow.ThroughputCalculator = newFlowCalculator()
+        flow.ThroughputCalculator.Run()
+    }
+
+    // start a sender and receiver for each flow
+    var errChans []chan error
+    for _, flow := range udpReplay.Flows {
+        sendErrChan := make(chan error)
+        recvErrChan := make(chan error)
+        go flow.Client.SendPackets(flow.Packets, timing, ctx, cancel, sendErrChan)
+        go flow.Client.RecvPackets([]*analyzer.Analyzer{throughputCalculator, flow.ThroughputCalculator}, ctx, cancel, recvErrChan)
+        errChans = append(errChans, sendErrChan, recvErrChan)
+    }
+
+    // wait for all the senders and receivers to finish
+    var err error
+    for _, errChan := range errChans {
+        flowErr := <-errChan
+        if err == nil {
+            err = flowErr
+        }
+    }
+
+    throughputCalculator.Stop()
+    for _, flow := range udpReplay.Flows {
+        flow.ThroughputCalculator.Stop()
+    }
+    return err
+}
+
+// Closes the sockets of all the flows of the replay.
+func (udpReplay *UDPReplay) CleanUp() {
+    for _, flow := range udpReplay.Flows {
+        flow.Client.CleanUp()
+    }
+}
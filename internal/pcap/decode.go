@@ -0,0 +1,273 @@
+package pcap
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net/netip"
+    "time"
+)
+
+// Link layers of the frames. See https://www.tcpdump.org/linktypes.html.
+const (
+    LinkTypeNull = 0 // BSD loopback
+    LinkTypeEthernet = 1
+    LinkTypeRaw = 101 // raw IPv4 or IPv6
+    LinkTypeLinuxSLL = 113 // Linux cooked capture
+    LinkTypeIPv4 = 228
+    LinkTypeIPv6 = 229
+    LinkTypeLinuxSLL2 = 276 // Linux cooked capture v2
+)
+
+const (
+    ProtocolTCP = "tcp"
+    ProtocolUDP = "udp"
+
+    etherTypeIPv4 = 0x0800
+    etherTypeIPv6 = 0x86dd
+    etherTypeVLAN = 0x8100
+    etherTypeQinQ = 0x88a8
+
+    ipProtocolTCP = 6
+    ipProtocolUDP = 17
+
+    tcpFlagFIN = 0x01
+    tcpFlagSYN = 0x02
+    tcpFlagRST = 0x04
+)
+
+var (
+    ErrNotTCPOrUDP = errors.New("Frame is not a TCP or UDP packet")
+    ErrFragment = errors.New("Frame is an IP fragment")
+)
+
+// A TCP segment or UDP datagram decoded from a frame.
+type Segment struct {
+    Timestamp time.Time // time the frame was captured
+    FrameIndex int // index of the frame in the capture file
+    Protocol string // either ProtocolTCP or ProtocolUDP
+    Src netip.AddrPort // the sender of the segment
+    Dst netip.AddrPort // the receiver of the segment
+    Seq uint32 // the TCP sequence number; 0 for UDP
+    SYN bool // true if the TCP SYN flag is set
+    FIN bool // true if the TCP FIN flag is set
+    RST bool // true if the TCP RST flag is set
+    Payload []byte // the TCP or UDP payload
+    Truncated bool // true if the frame was cut short by the snap length, so the payload is incomplete
+}
+
+// Decodes the TCP segment or UDP datagram in a frame.
+// frame: the captured frame
+// Returns the segment, ErrNotTCPOrUDP if the frame does not contain a TCP or UDP packet,
+//     ErrFragment if it is an IP fragment, or any other errors
+func Decode(frame Frame) (Segment, error) {
+    etherType, ipPacket, err := decodeLinkLayer(frame.LinkType, frame.Data)
+    if err != nil {
+        return Segment{}, err
+    }
+
+    var ip ipPacketInfo
+    switch etherType {
+    case etherTypeIPv4:
+        ip, err = decodeIPv4(ipPacket)
+    case etherTypeIPv6:
+        ip, err = decodeIPv6(ipPacket)
+    default:
+        return Segment{}, ErrNotTCPOrUDP
+    }
+    if err != nil {
+        return Segment{}, err
+    }
+
+    segment := Segment{
+        Timestamp: frame.Timestamp,
+        FrameIndex: frame.Index,
+        Truncated: ip.truncated,
+    }
+    transport := ip.payload
+    switch ip.protocol {
+    case ipProtocolTCP:
+        if len(transport) < 20 {
+            return Segment{}, fmt.Errorf("TCP header is too short.")
+        }
+        dataOffset := int(transport[12] >> 4) * 4
+        if dataOffset < 20 || dataOffset > len(transport) {
+            return Segment{}, fmt.Errorf("TCP data offset %d is invalid.", dataOffset)
+        }
+        flags := transport[13]
+        segment.Protocol = ProtocolTCP
+        segment.Seq = binary.BigEndian.Uint32(transport[4:8])
+        segment.SYN = flags & tcpFlagSYN != 0
+        segment.FIN = flags & tcpFlagFIN != 0
+        segment.RST = flags & tcpFlagRST != 0
+        segment.Payload = transport[dataOffset:]
+    case ipProtocolUDP:
+        if len(transport) < 8 {
+            return Segment{}, fmt.Errorf("UDP header is too short.")
+        }
+        length := int(binary.BigEndian.Uint16(transport[4:6]))
+        if length < 8 || (length > len(transport) && !ip.truncated) {
+            return Segment{}, fmt.Errorf("UDP length %d is invalid.", length)
+        }
+        length = min(length, len(transport))
+        segment.Protocol = ProtocolUDP
+        segment.Payload = transport[8:length]
+    default:
+        return Segment{}, ErrNotTCPOrUDP
+    }
+    segment.Src = netip.AddrPortFrom(ip.src, binary.BigEndian.Uint16(transport[0:2]))
+    segment.Dst = netip.AddrPortFrom(ip.dst, binary.BigEndian.Uint16(transport[2:4]))
+    return segment, nil
+}
+
+// Removes the link layer header of a frame.
+// linkType: the link layer of the frame
+// data: the frame
+// Returns the ether type of the network layer and the network layer packet, or any errors
+func decodeLinkLayer(linkType int, data []byte) (int, []byte, error) {
+    switch linkType {
+    case LinkTypeEthernet:
+        if len(data) < 14 {
+            return 0, nil, fmt.Errorf("Ethernet header is too short.")
+        }
+        etherType := int(binary.BigEndian.Uint16(data[12:14]))
+        data = data[14:]
+        // skip any VLAN tags
+        for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+            if len(data) < 4 {
+                return 0, nil, fmt.Errorf("VLAN tag is too short.")
+            }
+            etherType = int(binary.BigEndian.Uint16(data[2:4]))
+            data = data[4:]
+        }
+        return etherType, data, nil
+    case LinkTypeRaw:
+        if len(data) < 1 {
+            return 0, nil, fmt.Errorf("Raw IP packet is empty.")
+        }
+        switch data[0] >> 4 {
+        case 4:
+            return etherTypeIPv4, data, nil
+        case 6:
+            return etherTypeIPv6, data, nil
+        }
+        return 0, nil, ErrNotTCPOrUDP
+    case LinkTypeIPv4:
+        return etherTypeIPv4, data, nil
+    case LinkTypeIPv6:
+        return etherTypeIPv6, data, nil
+    case LinkTypeNull:
+        if len(data) < 4 {
+            return 0, nil, fmt.Errorf("Loopback header is too short.")
+        }
+        // the address family is in the byte order of the capturing host
+        family := binary.LittleEndian.Uint32(data[0:4])
+        if family > 0xffff {
+            family = binary.BigEndian.Uint32(data[0:4])
+        }
+        switch family {
+        case 2:
+            return etherTypeIPv4, data[4:], nil
+        case 10, 24, 28, 30: // AF_INET6 on Linux, NetBSD/OpenBSD, FreeBSD, and macOS
+            return etherTypeIPv6, data[4:], nil
+        }
+        return 0, nil, ErrNotTCPOrUDP
+    case LinkTypeLinuxSLL:
+        if len(data) < 16 {
+            return 0, nil, fmt.Errorf("Linux cooked capture header is too short.")
+        }
+        return int(binary.BigEndian.Uint16(data[14:16])), data[16:], nil
+    case LinkTypeLinuxSLL2:
+        if len(data) < 20 {
+            return 0, nil, fmt.Errorf("Linux cooked capture v2 header is too short.")
+        }
+        return int(binary.BigEndian.Uint16(data[0:2])), data[20:], nil
+    default:
+        return 0, nil, fmt.Errorf("Link type %d is not supported.", linkType)
+    }
+}
+
+// The fields of an IP packet needed to decode its TCP or UDP payload.
+type ipPacketInfo struct {
+    protocol int // the protocol of the payload
+    src netip.Addr // the source IP
+    dst netip.Addr // the destination IP
+    payload []byte // the payload, after any extension headers
+    truncated bool // true if the packet was cut short by the snap length
+}
+
+// Decodes an IPv4 packet.
+// data: the IPv4 packet
+// Returns the decoded packet, or any errors
+func decodeIPv4(data []byte) (ipPacketInfo, error) {
+    if len(data) < 20 {
+        return ipPacketInfo{}, fmt.Errorf("IPv4 header is too short.")
+    }
+    headerLength := int(data[0] & 0x0f) * 4
+    totalLength := int(binary.BigEndian.Uint16(data[2:4]))
+    if headerLength < 20 || totalLength < headerLength {
+        return ipPacketInfo{}, fmt.Errorf("IPv4 header length %d or total length %d is invalid.", headerLength, totalLength)
+    }
+    // frames can be cut short by the snap length, or padded by the link layer
+    truncated := totalLength > len(data)
+    if !truncated {
+        data = data[:totalLength]
+    }
+    if headerLength > len(data) {
+        return ipPacketInfo{}, fmt.Errorf("IPv4 header is too short.")
+    }
+    fragment := binary.BigEndian.Uint16(data[6:8])
+    if fragment & 0x3fff != 0 {
+        // more fragments flag or fragment offset is set
+        return ipPacketInfo{}, ErrFragment
+    }
+    return ipPacketInfo{
+        protocol: int(data[9]),
+        src: netip.AddrFrom4([4]byte(data[12:16])),
+        dst: netip.AddrFrom4([4]byte(data[16:20])),
+        payload: data[headerLength:],
+        truncated: truncated,
+    }, nil
+}
+
+// Decodes an IPv6 packet. Hop-by-hop, routing, and destination options extension headers are
+// skipped.
+// data: the IPv6 packet
+// Returns the decoded packet, or any errors
+func decodeIPv6(data []byte) (ipPacketInfo, error) {
+    if len(data) < 40 {
+        return ipPacketInfo{}, fmt.Errorf("IPv6 header is too short.")
+    }
+    payloadLength := int(binary.BigEndian.Uint16(data[4:6]))
+    nextHeader := int(data[6])
+    payload := data[40:]
+    truncated := payloadLength > len(payload)
+    if !truncated {
+        payload = payload[:payloadLength]
+    }
+
+    for {
+        switch nextHeader {
+        case 0, 43, 60: // hop-by-hop options, routing, destination options
+            if len(payload) < 8 {
+                return ipPacketInfo{}, fmt.Errorf("IPv6 extension header is too short.")
+            }
+            length := (int(payload[1]) + 1) * 8
+            if length > len(payload) {
+                return ipPacketInfo{}, fmt.Errorf("IPv6 extension header is too short.")
+            }
+            nextHeader = int(payload[0])
+            payload = payload[length:]
+        case 44: // fragment
+            return ipPacketInfo{}, ErrFragment
+        default:
+            return ipPacketInfo{
+                protocol: nextHeader,
+                src: netip.AddrFrom16([16]byte(data[8:24])),
+                dst: netip.AddrFrom16([16]byte(data[24:40])),
+                payload: payload,
+                truncated: truncated,
+            }, nil
+        }
+    }
+}
@@ -0,0 +1,349 @@
+// Reads packets from pcap and pcapng capture files.
+package pcap
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "time"
+)
+
+const (
+    pcapMagicMicroseconds = 0xa1b2c3d4 // pcap file with microsecond timestamps
+    pcapMagicNanoseconds = 0xa1b23c4d // pcap file with nanosecond timestamps
+    pcapHeaderLength = 24
+    pcapRecordHeaderLength = 16
+
+    pcapngSectionHeaderBlock = 0x0a0d0d0a
+    pcapngInterfaceDescriptionBlock = 0x00000001
+    pcapngObsoletePacketBlock = 0x00000002
+    pcapngSimplePacketBlock = 0x00000003
+    pcapngEnhancedPacketBlock = 0x00000006
+    pcapngByteOrderMagic = 0x1a2b3c4d
+    pcapngTSResolOption = 9 // if_tsresol option of an interface description block
+
+    maxBlockLength = 64 * 1024 * 1024 // larger blocks are treated as a corrupt capture
+)
+
+// A frame captured on an interface.
+type Frame struct {
+    Timestamp time.Time // time the frame was captured
+    LinkType int // the link layer of the frame; one of the LinkType constants
+    Data []byte // the captured bytes, starting at the link layer
+    Index int // index of the frame in the capture file, starting at 0
+}
+
+// An interface described by a pcapng interface description block.
+type pcapngInterface struct {
+    linkType int // the link layer of the interface
+    tsUnit time.Duration // duration of one unit of the timestamps; 0 if less than a nanosecond
+    tsDivisor uint64 // number of units per second, used when tsUnit is 0
+}
+
+// Reads the frames of a pcap or pcapng capture file.
+type Reader struct {
+    reader *bufio.Reader // the capture file
+    isPcapng bool // true if the capture file is pcapng; false if it is pcap
+    byteOrder binary.ByteOrder // byte order of the pcap file or of the current pcapng section
+    linkType int // link layer of every frame in a pcap file
+    nanoseconds bool // true if the timestamps of a pcap file are in nanoseconds
+    interfaces []pcapngInterface // the interfaces of the current pcapng section
+    index int // index of the next frame
+}
+
+// Creates a new Reader. The format of the capture file is detected from its first bytes.
+// r: the capture file
+// Returns a new Reader, or an error if the file is not a pcap or pcapng capture file
+func NewReader(r io.Reader) (*Reader, error) {
+    reader := &Reader{reader: bufio.NewReader(r)}
+    magic, err := reader.reader.Peek(4)
+    if err != nil {
+        return nil, fmt.Errorf("Unable to read capture file header: %v", err)
+    }
+
+    if binary.LittleEndian.Uint32(magic) == pcapngSectionHeaderBlock {
+        reader.isPcapng = true
+        return reader, nil
+    }
+    err = reader.readPcapHeader()
+    if err != nil {
+        return nil, err
+    }
+    return reader, nil
+}
+
+// Reads the file header of a pcap file.
+// Returns any errors
+func (r *Reader) readPcapHeader() error {
+    header := make([]byte, pcapHeaderLength)
+    _, err := io.ReadFull(r.reader, header)
+    if err != nil {
+        return fmt.Errorf("Unable to read pcap file header: %v", err)
+    }
+
+    for _, byteOrder := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+        switch byteOrder.Uint32(header[0:4]) {
+        case pcapMagicMicroseconds:
+            r.byteOrder = byteOrder
+        case pcapMagicNanoseconds:
+            r.byteOrder = byteOrder
+            r.nanoseconds = true
+        default:
+            continue
+        }
+        // the FCS length is in the top bits of the link type field
+        r.linkType = int(byteOrder.Uint32(header[20:24]) & 0x0fffffff)
+        return nil
+    }
+    return fmt.Errorf("Not a pcap or pcapng capture file (magic number %x).", header[0:4])
+}
+
+// Reads the next frame of the capture file.
+// Returns the frame, io.EOF at the end of the file, or any errors
+func (r *Reader) Next() (Frame, error) {
+    var frame Frame
+    var err error
+    if r.isPcapng {
+        frame, err = r.nextPcapng()
+    } else {
+        frame, err = r.nextPcap()
+    }
+    if err != nil {
+        return Frame{}, err
+    }
+    frame.Index = r.index
+    r.index += 1
+    return frame, nil
+}
+
+// Reads the next record of a pcap file.
+// Returns the frame, io.EOF at the end of the file, or any errors
+func (r *Reader) nextPcap() (Frame, error) {
+    header := make([]byte, pcapRecordHeaderLength)
+    _, err := io.ReadFull(r.reader, header)
+    if err == io.EOF {
+        return Frame{}, io.EOF
+    }
+    if err != nil {
+        return Frame{}, fmt.Errorf("Unable to read header of frame %d: %v", r.index, err)
+    }
+
+    seconds := int64(r.byteOrder.Uint32(header[0:4]))
+    fraction := int64(r.byteOrder.Uint32(header[4:8]))
+    capturedLength := r.byteOrder.Uint32(header[8:12])
+    if capturedLength > maxBlockLength {
+        return Frame{}, fmt.Errorf("Frame %d is %d bytes long; the capture file is likely corrupt.", r.index, capturedLength)
+    }
+    data := make([]byte, capturedLength)
+    _, err = io.ReadFull(r.reader, data)
+    if err != nil {
+        return Frame{}, fmt.Errorf("Unable to read frame %d: %v", r.index, err)
+    }
+
+    if !r.nanoseconds {
+        fraction *= 1000
+    }
+    return Frame{
+        Timestamp: time.Unix(seconds, fraction),
+        LinkType: r.linkType,
+        Data: data,
+    }, nil
+}
+
+// Reads pcapng blocks until the next block that contains a frame.
+// Returns the frame, io.EOF at the end of the file, or any errors
+func (r *Reader) nextPcapng() (Frame, error) {
+    for {
+        blockType, body, err := r.readBlock()
+        if err != nil {
+            return Frame{}, err
+        }
+
+        switch blockType {
+        case pcapngSectionHeaderBlock:
+            // a new section can have a different byte order and its own interfaces
+            r.interfaces = nil
+        case pcapngInterfaceDescriptionBlock:
+            if len(body) < 8 {
+                return Frame{}, fmt.Errorf("Interface description block is too short.")
+            }
+            iface, err := r.parseInterface(body)
+            if err != nil {
+                return Frame{}, err
+            }
+            r.interfaces = append(r.interfaces, iface)
+        case pcapngEnhancedPacketBlock, pcapngObsoletePacketBlock:
+            return r.parsePacketBlock(blockType, body)
+        case pcapngSimplePacketBlock:
+            if len(body) < 4 || len(r.interfaces) == 0 {
+                return Frame{}, fmt.Errorf("Simple packet block %d is invalid.", r.index)
+            }
+            originalLength := r.byteOrder.Uint32(body[0:4])
+            data := body[4:]
+            if uint32(len(data)) > originalLength {
+                data = data[:originalLength]
+            }
+            // simple packet blocks do not have timestamps
+            return Frame{LinkType: r.interfaces[0].linkType, Data: data}, nil
+        }
+        // other blocks, such as statistics and name resolution, are skipped
+    }
+}
+
+// Reads a pcapng block. The byte order is updated when a section header block is read.
+// Returns the block type and the body of the block, io.EOF at the end of the file, or any errors
+func (r *Reader) readBlock() (uint32, []byte, error) {
+    header := make([]byte, 8)
+    _, err := io.ReadFull(r.reader, header)
+    if err == io.EOF {
+        return 0, nil, io.EOF
+    }
+    if err != nil {
+        return 0, nil, fmt.Errorf("Unable to read pcapng block header: %v", err)
+    }
+
+    blockType := binary.LittleEndian.Uint32(header[0:4])
+    if blockType == pcapngSectionHeaderBlock {
+        // the byte order of a section is given by the magic number after the block length
+        byteOrderMagic, err := r.reader.Peek(4)
+        if err != nil {
+            return 0, nil, fmt.Errorf("Unable to read pcapng section header: %v", err)
+        }
+        if binary.LittleEndian.Uint32(byteOrderMagic) == pcapngByteOrderMagic {
+            r.byteOrder = binary.LittleEndian
+        } else if binary.BigEndian.Uint32(byteOrderMagic) == pcapngByteOrderMagic {
+            r.byteOrder = binary.BigEndian
+        } else {
+            return 0, nil, fmt.Errorf("pcapng section header has an invalid byte order magic number %x.", byteOrderMagic)
+        }
+    } else if r.byteOrder == nil {
+        return 0, nil, fmt.Errorf("pcapng file does not start with a section header block.")
+    }
+    blockType = r.byteOrder.Uint32(header[0:4])
+
+    blockLength := r.byteOrder.Uint32(header[4:8])
+    if blockLength < 12 || blockLength % 4 != 0 || blockLength > maxBlockLength {
+        return 0, nil, fmt.Errorf("pcapng block has an invalid length %d; the capture file is likely corrupt.", blockLength)
+    }
+    // the body is followed by a copy of the block length
+    rest := make([]byte, blockLength - 8)
+    _, err = io.ReadFull(r.reader, rest)
+    if err != nil {
+        return 0, nil, fmt.Errorf("Unable to read pcapng block: %v", err)
+    }
+    return blockType, rest[:len(rest) - 4], nil
+}
+
+// Parses the link type and timestamp resolution of a pcapng interface description block.
+// body: the body of the block
+// Returns the interface, or an error if the timestamp resolution is too fine for a 64-bit timestamp
+func (r *Reader) parseInterface(body []byte) (pcapngInterface, error) {
+    iface := pcapngInterface{
+        linkType: int(r.byteOrder.Uint16(body[0:2])),
+        tsUnit: time.Microsecond, // default resolution is 10^-6 seconds
+    }
+
+    options := body[8:]
+    for len(options) >= 4 {
+        code := r.byteOrder.Uint16(options[0:2])
+        length := int(r.byteOrder.Uint16(options[2:4]))
+        if code == 0 || 4 + length > len(options) {
+            break
+        }
+        if code == pcapngTSResolOption && length >= 1 {
+            tsresol := options[4]
+            // the number of units per second must fit in 64 bits: 2^63 and 10^19 are the largest
+            if (tsresol & 0x80 != 0 && tsresol & 0x7f > 63) || (tsresol & 0x80 == 0 && tsresol > 19) {
+                return pcapngInterface{}, fmt.Errorf("Interface %d has an unsupported timestamp resolution of 0x%02x.", len(r.interfaces), tsresol)
+            }
+            if tsresol & 0x80 != 0 {
+                // negative power of 2
+                iface.tsUnit = 0
+                iface.tsDivisor = 1 << (tsresol & 0x7f)
+            } else if tsresol <= 9 {
+                // negative power of 10
+                iface.tsUnit = time.Second
+                for i := uint8(0); i < tsresol; i++ {
+                    iface.tsUnit /= 10
+                }
+            } else {
+                iface.tsUnit = 0
+                iface.tsDivisor = 1
+                for i := uint8(0); i < tsresol; i++ {
+                    iface.tsDivisor *= 10
+                }
+            }
+        }
+        // options are padded to 32 bits
+        options = options[4 + (length + 3) / 4 * 4:]
+    }
+    return iface, nil
+}
+
+// Parses an enhanced packet block or an obsolete packet block.
+// blockType: either pcapngEnhancedPacketBlock or pcapngObsoletePacketBlock
+// body: the body of the block
+// Returns the frame, or any errors
+func (r *Reader) parsePacketBlock(blockType uint32, body []byte) (Frame, error) {
+    if len(body) < 20 {
+        return Frame{}, fmt.Errorf("Packet block %d is too short.", r.index)
+    }
+    var interfaceID int
+    if blockType == pcapngEnhancedPacketBlock {
+        interfaceID = int(r.byteOrder.Uint32(body[0:4]))
+    } else {
+        interfaceID = int(r.byteOrder.Uint16(body[0:2]))
+    }
+    if interfaceID >= len(r.interfaces) {
+        return Frame{}, fmt.Errorf("Packet block %d refers to interface %d, which is not described.", r.index, interfaceID)
+    }
+    iface := r.interfaces[interfaceID]
+
+    timestamp := uint64(r.byteOrder.Uint32(body[4:8])) << 32 | uint64(r.byteOrder.Uint32(body[8:12]))
+    capturedLength := r.byteOrder.Uint32(body[12:16])
+    if uint64(capturedLength) > uint64(len(body) - 20) {
+        return Frame{}, fmt.Errorf("Packet block %d has a captured length of %d bytes, longer than the block.", r.index, capturedLength)
+    }
+
+    return Frame{
+        Timestamp: iface.toTime(timestamp),
+        LinkType: iface.linkType,
+        Data: body[20:20 + capturedLength],
+    }, nil
+}
+
+// Converts a pcapng timestamp of an interface into a time.
+// timestamp: the number of units of the interface since the epoch
+// Returns the time
+func (iface pcapngInterface) toTime(timestamp uint64) time.Time {
+    if iface.tsUnit > 0 {
+        unitsPerSecond := uint64(time.Second / iface.tsUnit)
+        return time.Unix(int64(timestamp / unitsPerSecond), int64(timestamp % unitsPerSecond) * int64(iface.tsUnit))
+    }
+    seconds := timestamp / iface.tsDivisor
+    fraction := timestamp % iface.tsDivisor
+    return time.Unix(int64(seconds), int64(float64(fraction) / float64(iface.tsDivisor) * float64(time.Second)))
+}
+
+// Reads all the frames of a capture file.
+// r: the capture file
+// Returns the frames, or any errors
+func ReadAll(r io.Reader) ([]Frame, error) {
+    reader, err := NewReader(r)
+    if err != nil {
+        return nil, err
+    }
+    var frames []Frame
+    for {
+        frame, err := reader.Next()
+        if errors.Is(err, io.EOF) {
+            return frames, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        frames = append(frames, frame)
+    }
+}
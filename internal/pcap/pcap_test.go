@@ -0,0 +1,135 @@
+package pcap
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "net/netip"
+    "testing"
+    "time"
+
+    "wehe-cmdline-client/internal/pcap/pcaptest"
+)
+
+func TestReadPcap(t *testing.T) {
+    packets := []pcaptest.Packet{
+        {Protocol: ipProtocolUDP, Src: "10.0.0.1:50000", Dst: "10.0.0.2:9000", Payload: "hello"},
+        {Protocol: ipProtocolUDP, Src: "10.0.0.1:50000", Dst: "10.0.0.2:9000", Payload: "hello", Time: time.Second + 1500 * time.Nanosecond},
+    }
+    packet := packets[0].Bytes()
+    tests := []struct {
+        name string
+        file []byte
+        fraction time.Duration
+    }{
+        {"little endian", pcaptest.Pcap(binary.LittleEndian, false, LinkTypeRaw, packets...), time.Microsecond},
+        {"big endian", pcaptest.Pcap(binary.BigEndian, false, LinkTypeRaw, packets...), time.Microsecond},
+        {"nanoseconds", pcaptest.Pcap(binary.LittleEndian, true, LinkTypeRaw, packets...), 1500 * time.Nanosecond},
+    }
+    for _, test := range tests {
+        frames, err := ReadAll(bytes.NewReader(test.file))
+        if err != nil {
+            t.Fatalf("%s: unexpected error: %v", test.name, err)
+        }
+        if len(frames) != 2 || frames[1].Index != 1 || frames[0].LinkType != LinkTypeRaw || !bytes.Equal(frames[0].Data, packet) {
+            t.Fatalf("%s: unexpected frames: %v", test.name, frames)
+        }
+        expected := pcaptest.Start.Add(time.Second + test.fraction)
+        if !frames[1].Timestamp.Equal(expected) {
+            t.Errorf("%s: expected timestamp %v, got %v", test.name, expected, frames[1].Timestamp)
+        }
+    }
+
+    _, err := NewReader(bytes.NewReader([]byte("not a capture file at all")))
+    if err == nil {
+        t.Errorf("Expected an error for a file that is not a capture")
+    }
+}
+
+func TestReadPcapng(t *testing.T) {
+    packet := pcaptest.Packet{Protocol: ipProtocolTCP, Src: "10.0.0.1:50000", Dst: "10.0.0.2:80", Seq: 1000, SYN: true, Payload: "GET /", Time: 250}
+    ethernetFrame := append([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0x08, 0x00}, packet.Bytes()...)
+
+    // ethernet interface with nanosecond timestamps
+    file := pcaptest.Pcapng(LinkTypeEthernet, 9, packet)
+    file = append(file, pcaptest.PcapngBlock(5, []byte{1, 2, 3, 4})...) // statistics block is skipped
+    frames, err := ReadAll(bytes.NewReader(file))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(frames) != 1 || frames[0].LinkType != LinkTypeEthernet || !bytes.Equal(frames[0].Data, ethernetFrame) {
+        t.Fatalf("Unexpected frames: %v", frames)
+    }
+    if !frames[0].Timestamp.Equal(pcaptest.Start.Add(250)) {
+        t.Errorf("Unexpected timestamp %v", frames[0].Timestamp)
+    }
+
+    segment, err := Decode(frames[0])
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if segment.Protocol != ProtocolTCP || !segment.SYN || segment.Seq != 1000 || string(segment.Payload) != "GET /" || segment.Dst != netip.MustParseAddrPort("10.0.0.2:80") {
+        t.Errorf("Unexpected segment: %v", segment)
+    }
+
+    // timestamps in units of 2^-10 and 10^-3 seconds
+    packet.Time = 1500 * time.Millisecond
+    for _, tsresol := range []byte{0x80 | 10, 3} {
+        frames, err := ReadAll(bytes.NewReader(pcaptest.Pcapng(LinkTypeRaw, tsresol, packet)))
+        if err != nil || len(frames) != 1 || !frames[0].Timestamp.Equal(pcaptest.Start.Add(packet.Time)) {
+            t.Errorf("Resolution 0x%02x: unexpected frames %v, %v", tsresol, frames, err)
+        }
+    }
+}
+
+func TestDecode(t *testing.T) {
+    packet := pcaptest.Packet{Protocol: ipProtocolUDP, Src: "10.0.0.1:50000", Dst: "10.0.0.2:9000", Payload: "hello"}.Bytes()
+    loopback := append([]byte{2, 0, 0, 0}, packet...)
+    cooked := append(make([]byte, 14), 0x08, 0x00)
+    cooked = append(cooked, packet...)
+    vlan := append([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0x81, 0x00, 0, 1, 0x08, 0x00}, packet...)
+    for _, frame := range []Frame{
+        {LinkType: LinkTypeRaw, Data: packet},
+        {LinkType: LinkTypeIPv4, Data: packet},
+        {LinkType: LinkTypeNull, Data: loopback},
+        {LinkType: LinkTypeLinuxSLL, Data: cooked},
+        {LinkType: LinkTypeEthernet, Data: vlan},
+    } {
+        segment, err := Decode(frame)
+        if err != nil {
+            t.Fatalf("Link type %d: unexpected error: %v", frame.LinkType, err)
+        }
+        if segment.Protocol != ProtocolUDP || string(segment.Payload) != "hello" || segment.Src != netip.MustParseAddrPort("10.0.0.1:50000") {
+            t.Errorf("Link type %d: unexpected segment: %v", frame.LinkType, segment)
+        }
+    }
+
+    // the payload of a frame cut short by the snap length is incomplete
+    segment, err := Decode(Frame{LinkType: LinkTypeRaw, Data: packet[:len(packet) - 2]})
+    if err != nil || !segment.Truncated || string(segment.Payload) != "hel" {
+        t.Errorf("Expected truncated segment, got %v, %v", segment, err)
+    }
+
+    fragment := append([]byte{}, packet...)
+    fragment[6] = 0x20 // more fragments
+    _, err = Decode(Frame{LinkType: LinkTypeRaw, Data: fragment})
+    if !errors.Is(err, ErrFragment) {
+        t.Errorf("Expected ErrFragment, got %v", err)
+    }
+
+    icmp := pcaptest.Packet{Protocol: 1, Src: "10.0.0.1:0", Dst: "10.0.0.2:0"}.Bytes()
+    _, err = Decode(Frame{LinkType: LinkTypeRaw, Data: icmp})
+    if !errors.Is(err, ErrNotTCPOrUDP) {
+        t.Errorf("Expected ErrNotTCPOrUDP, got %v", err)
+    }
+}
+
+func TestReadPcapngTimestampResolution(t *testing.T) {
+    // units of 2^-100, 2^-64 and 10^-20 seconds do not fit in 64 bits
+    for _, tsresol := range []byte{0x80 | 100, 0x80 | 64, 20} {
+        _, err := ReadAll(bytes.NewReader(pcaptest.Pcapng(LinkTypeRaw, tsresol)))
+        if err == nil {
+            t.Errorf("Resolution 0x%02x: expected an error", tsresol)
+        }
+    }
+}
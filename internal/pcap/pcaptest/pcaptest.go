@@ -0,0 +1,199 @@
+// Builds pcap and pcapng capture files for tests.
+package pcaptest
+
+import (
+    "encoding/binary"
+    "math/big"
+    "net/netip"
+    "time"
+)
+
+const (
+    ProtocolTCP = 6 // IP protocol number of TCP
+    ProtocolUDP = 17 // IP protocol number of UDP
+
+    linkTypeEthernet = 1
+    etherTypeIPv4 = 0x0800
+    etherTypeIPv6 = 0x86dd
+    tcpFlagSYN = 0x02
+
+    pcapMagicMicroseconds = 0xa1b2c3d4
+    pcapMagicNanoseconds = 0xa1b23c4d
+
+    pcapngSectionHeaderBlock = 0x0a0d0d0a
+    pcapngInterfaceDescriptionBlock = 0x00000001
+    pcapngEnhancedPacketBlock = 0x00000006
+    pcapngByteOrderMagic = 0x1a2b3c4d
+    pcapngTSResolOption = 9
+)
+
+// time of the start of every capture
+var Start = time.Unix(1700000000, 0)
+
+// A packet written to a test capture.
+type Packet struct {
+    Protocol byte // ProtocolTCP, ProtocolUDP, or another IP protocol, which gets a UDP header
+    Src string // sender of the packet, ip:port; IPv6 addresses are in brackets
+    Dst string // receiver of the packet, ip:port; must be the same IP version as Src
+    Seq uint32 // the TCP sequence number
+    SYN bool // true if the TCP SYN flag is set
+    Payload string // the payload of the packet
+    Time time.Duration // time of the packet since Start
+    SnapLength int // number of bytes of the frame that are captured; 0 to capture the whole frame
+}
+
+// Builds the IP packet, an IPv4 or IPv6 packet depending on the addresses, containing a TCP
+// segment or UDP datagram.
+// Returns the packet
+func (p Packet) Bytes() []byte {
+    src := netip.MustParseAddrPort(p.Src)
+    dst := netip.MustParseAddrPort(p.Dst)
+    var transport []byte
+    if p.Protocol == ProtocolTCP {
+        transport = make([]byte, 20)
+        binary.BigEndian.PutUint32(transport[4:8], p.Seq)
+        transport[12] = 5 << 4
+        if p.SYN {
+            transport[13] = tcpFlagSYN
+        }
+    } else {
+        transport = make([]byte, 8)
+        binary.BigEndian.PutUint16(transport[4:6], uint16(8 + len(p.Payload)))
+    }
+    binary.BigEndian.PutUint16(transport[0:2], src.Port())
+    binary.BigEndian.PutUint16(transport[2:4], dst.Port())
+    transport = append(transport, p.Payload...)
+
+    if src.Addr().Is6() {
+        header := make([]byte, 40)
+        header[0] = 0x60
+        binary.BigEndian.PutUint16(header[4:6], uint16(len(transport)))
+        header[6] = p.Protocol
+        header[7] = 64
+        srcIP := src.Addr().As16()
+        dstIP := dst.Addr().As16()
+        copy(header[8:24], srcIP[:])
+        copy(header[24:40], dstIP[:])
+        return append(header, transport...)
+    }
+    header := make([]byte, 20)
+    header[0] = 0x45
+    binary.BigEndian.PutUint16(header[2:4], uint16(20 + len(transport)))
+    header[8] = 64
+    header[9] = p.Protocol
+    srcIP := src.Addr().As4()
+    dstIP := dst.Addr().As4()
+    copy(header[12:16], srcIP[:])
+    copy(header[16:20], dstIP[:])
+    return append(header, transport...)
+}
+
+// Builds the frame of the packet.
+// linkType: the link layer of the frame; Ethernet frames get an Ethernet header, and any other
+//     link type gets the bare IP packet
+// Returns the whole frame and the part of it that is captured
+func (p Packet) frame(linkType int) ([]byte, []byte) {
+    data := p.Bytes()
+    if linkType == linkTypeEthernet {
+        etherType := uint16(etherTypeIPv4)
+        if data[0] >> 4 == 6 {
+            etherType = etherTypeIPv6
+        }
+        header := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+        data = append(binary.BigEndian.AppendUint16(header, etherType), data...)
+    }
+    if p.SnapLength > 0 && p.SnapLength < len(data) {
+        return data, data[:p.SnapLength]
+    }
+    return data, data
+}
+
+// Builds a pcap file with one frame for each packet.
+// byteOrder: byte order of the file
+// nanoseconds: true if the timestamps are in nanoseconds; false for microseconds
+// linkType: the link layer of the frames
+// packets: the packets to write
+// Returns the file
+func Pcap(byteOrder binary.ByteOrder, nanoseconds bool, linkType int, packets ...Packet) []byte {
+    file := make([]byte, 24)
+    if nanoseconds {
+        byteOrder.PutUint32(file[0:4], pcapMagicNanoseconds)
+    } else {
+        byteOrder.PutUint32(file[0:4], pcapMagicMicroseconds)
+    }
+    byteOrder.PutUint16(file[4:6], 2)
+    byteOrder.PutUint16(file[6:8], 4)
+    byteOrder.PutUint32(file[16:20], 65535)
+    byteOrder.PutUint32(file[20:24], uint32(linkType))
+    for _, p := range packets {
+        data, captured := p.frame(linkType)
+        timestamp := Start.Add(p.Time)
+        fraction := timestamp.Nanosecond()
+        if !nanoseconds {
+            fraction /= 1000
+        }
+        record := make([]byte, 16)
+        byteOrder.PutUint32(record[0:4], uint32(timestamp.Unix()))
+        byteOrder.PutUint32(record[4:8], uint32(fraction))
+        byteOrder.PutUint32(record[8:12], uint32(len(captured)))
+        byteOrder.PutUint32(record[12:16], uint32(len(data)))
+        file = append(file, record...)
+        file = append(file, captured...)
+    }
+    return file
+}
+
+// Builds a little endian pcapng file with one interface and an enhanced packet block for each
+// packet.
+// linkType: the link layer of the interface
+// tsresol: the if_tsresol option of the interface; the high bit is set for a negative power of 2,
+//     otherwise it is a negative power of 10
+// packets: the packets to write
+// Returns the file
+func Pcapng(linkType int, tsresol byte, packets ...Packet) []byte {
+    sectionHeader := binary.LittleEndian.AppendUint32(nil, pcapngByteOrderMagic)
+    sectionHeader = append(sectionHeader, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+    interfaceDescription := binary.LittleEndian.AppendUint16(nil, uint16(linkType))
+    interfaceDescription = append(interfaceDescription, 0, 0, 0, 0, 0, 0, pcapngTSResolOption, 0, 1, 0, tsresol, 0, 0, 0, 0, 0, 0, 0)
+
+    file := PcapngBlock(pcapngSectionHeaderBlock, sectionHeader)
+    file = append(file, PcapngBlock(pcapngInterfaceDescriptionBlock, interfaceDescription)...)
+
+    // timestamps are in units of 1/unitsPerSecond seconds
+    unitsPerSecond := new(big.Int)
+    if tsresol & 0x80 != 0 {
+        unitsPerSecond.Lsh(big.NewInt(1), uint(tsresol & 0x7f))
+    } else {
+        unitsPerSecond.Exp(big.NewInt(10), big.NewInt(int64(tsresol)), nil)
+    }
+    for _, p := range packets {
+        data, captured := p.frame(linkType)
+        timestamp := big.NewInt(Start.Add(p.Time).UnixNano())
+        timestamp.Mul(timestamp, unitsPerSecond).Div(timestamp, big.NewInt(int64(time.Second)))
+        units := timestamp.Uint64()
+
+        enhancedPacket := binary.LittleEndian.AppendUint32(nil, 0)
+        enhancedPacket = binary.LittleEndian.AppendUint32(enhancedPacket, uint32(units >> 32))
+        enhancedPacket = binary.LittleEndian.AppendUint32(enhancedPacket, uint32(units))
+        enhancedPacket = binary.LittleEndian.AppendUint32(enhancedPacket, uint32(len(captured)))
+        enhancedPacket = binary.LittleEndian.AppendUint32(enhancedPacket, uint32(len(data)))
+        enhancedPacket = append(enhancedPacket, captured...)
+        file = append(file, PcapngBlock(pcapngEnhancedPacketBlock, enhancedPacket)...)
+    }
+    return file
+}
+
+// Builds a little endian pcapng block, padding the body to 32 bits.
+// blockType: the type of the block
+// body: the body of the block
+// Returns the block
+func PcapngBlock(blockType uint32, body []byte) []byte {
+    for len(body) % 4 != 0 {
+        body = append(body, 0)
+    }
+    block := make([]byte, 8)
+    binary.LittleEndian.PutUint32(block[0:4], blockType)
+    binary.LittleEndian.PutUint32(block[4:8], uint32(len(body) + 12))
+    block = append(block, body...)
+    return binary.LittleEndian.AppendUint32(block, uint32(len(body) + 12))
+}
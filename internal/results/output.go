@@ -0,0 +1,231 @@
+package results
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "strings"
+
+    "wehe-cmdline-client/internal/testdata"
+    "wehe-cmdline-client/internal/testorchestrator"
+)
+
+const (
+    TextOutput = "text" // human-readable results
+    JSONOutput = "json" // a JSON array containing all the results, written after all tests finish
+    NDJSONOutput = "ndjson" // one JSON object per line, written as soon as each test finishes
+)
+
+// The result of a test on one server, as written to the output.
+type OutputRecord struct {
+    TestSelector string `json:"testSelector"` // unique name of the test entered on the command line
+    TestName string `json:"testName"` // pretty name of the test
+    TestImage string `json:"testImage"` // image of the test
+    TestSize string `json:"testSize,omitempty"` // "small" or "large" for port tests
+    TestID int `json:"testID"` // the ID of the test for this specific user
+    ServerRecord
+    Tomography *TomographyRecord `json:"tomography,omitempty"` // the combined analysis of all the servers the test ran on, if there was more than one
+}
+
+// Writes test results in a given format.
+type Output struct {
+    format string // one of TextOutput, JSONOutput, or NDJSONOutput
+    writer io.Writer // where the results are written to
+    records []OutputRecord // results waiting to be written, JSONOutput only
+}
+
+// Creates a new Output.
+// format: the format to write results in; either "text", "json", or "ndjson"
+// writer: where the results are written to
+// Returns a new Output or an error if the format is invalid
+func NewOutput(format string, writer io.Writer) (*Output, error) {
+    switch format {
+    case TextOutput, JSONOutput, NDJSONOutput:
+    default:
+        return nil, fmt.Errorf("%s is not an output format. Choose from %s, %s, or %s.", format, TextOutput, JSONOutput, NDJSONOutput)
+    }
+    return &Output{
+        format: format,
+        writer: writer,
+        records: []OutputRecord{},
+    }, nil
+}
+
+// Writes the results of a test.
+// test: the test that was run
+// testResults: the results of the test for each server
+// tomography: the combined analysis of the servers; nil if the test ran on one server
+// Returns any errors
+func (o *Output) WriteResults(test *testdata.Test, testResults []testorchestrator.TestResult, tomography *testorchestrator.TomographyResult) error {
+    tomographyRecord := newTomographyRecord(tomography)
+    for _, testResult := range testResults {
+        switch o.format {
+        case TextOutput:
+            _, err := fmt.Fprintf(o.writer, "Test result for %s:\n\tStatus: %s\n\tOriginal Throughput: %f Mbps\n\tRandom Throughput: %f Mbps\n\tServer: %s (%s, %s)\n\tArea Threshold: %f\n\tKS2 P-Value Threshold: %f\n",
+                test.DisplayName(), testResult.Result, testResult.KS2Result.OriginalAvgThroughput, testResult.KS2Result.RandomAvgThroughput, testResult.ServerHostname, testResult.ServerIP, testResult.IPFamily, testResult.AreaThreshold, testResult.KS2PValueThreshold)
+            if err != nil {
+                return err
+            }
+            if testResult.ConfirmationKS2Result != nil {
+                _, err = fmt.Fprintf(o.writer, "\tInitial Status: %s\n\tConfirmation Test ID: %d\n\tConfirmation Status: %s\n\tConfirmation Original Throughput: %f Mbps\n\tConfirmation Random Throughput: %f Mbps\n",
+                    testResult.InitialResult, testResult.ConfirmationTestID, testResult.ConfirmationResult, testResult.ConfirmationKS2Result.OriginalAvgThroughput, testResult.ConfirmationKS2Result.RandomAvgThroughput)
+                if err != nil {
+                    return err
+                }
+            }
+            if testResult.ServerAnalysis != nil {
+                _, err = fmt.Fprintf(o.writer, "\tServer Area Test: %f\n\tServer KS2 P-Value: %f\n\tServer Original Throughput: %f Mbps\n\tServer Random Throughput: %f Mbps\n",
+                    testResult.ServerAnalysis.AreaTest, testResult.ServerAnalysis.KS2pVal, testResult.ServerAnalysis.XputAvgOriginal, testResult.ServerAnalysis.XputAvgTest)
+                if err != nil {
+                    return err
+                }
+            }
+            for _, analysis := range testResult.ServerHistory {
+                _, err = fmt.Fprintf(o.writer, "	Server History (test ID %d): %s on %s, Area Test: %f, KS2 P-Value: %f\n",
+                    analysis.HistoryCount, analysis.ReplayName, analysis.Date, analysis.AreaTest, analysis.KS2pVal)
+                if err != nil {
+                    return err
+                }
+            }
+            if testResult.IPFamilyComparison != nil {
+                comparison := testResult.IPFamilyComparison
+                _, err = fmt.Fprintf(o.writer, "\tIPv4 Status (test ID %d): %s\n\tIPv6 Status: %s\n\tOriginal Throughput Delta (IPv6 - IPv4): %f Mbps\n\tRandom Throughput Delta (IPv6 - IPv4): %f Mbps\n",
+                    comparison.IPv4TestID, comparison.IPv4Result, comparison.IPv6Result, comparison.OriginalThroughputDelta, comparison.RandomThroughputDelta)
+                if err != nil {
+                    return err
+                }
+            }
+            err = writeFlowsText(o.writer, testResult.Replays)
+            if err != nil {
+                return err
+            }
+        case JSONOutput:
+            o.records = append(o.records, newOutputRecord(test, testResult, tomographyRecord))
+        case NDJSONOutput:
+            data, err := json.Marshal(newOutputRecord(test, testResult, tomographyRecord))
+            if err != nil {
+                return err
+            }
+            _, err = o.writer.Write(append(data, '\n'))
+            if err != nil {
+                return err
+            }
+        }
+    }
+    if o.format == TextOutput && tomographyRecord != nil {
+        return writeTomographyText(o.writer, test, tomographyRecord)
+    }
+    return nil
+}
+
+// Writes the throughput of each flow of the UDP replays that had more than one flow as
+// human-readable text.
+// w: where the throughputs are written to
+// replayResults: the replays that ran on a server
+// Returns any errors
+func writeFlowsText(w io.Writer, replayResults []testorchestrator.ReplayResult) error {
+    for _, replayResult := range replayResults {
+        if len(replayResult.Flows) < 2 {
+            continue
+        }
+        var flowThroughputs []string
+        for _, flowResult := range replayResult.Flows {
+            flowThroughputs = append(flowThroughputs, fmt.Sprintf("port %d: %f Mbps", flowResult.ClientPort, flowResult.AverageThroughput))
+        }
+        label := replayResult.ReplayType.String()
+        if replayResult.IsConfirmation {
+            label = "confirmation " + label
+        }
+        _, err := fmt.Fprintf(w, "\tFlow Throughputs (%s replay): %s (all flows: %f Mbps)\n", label, strings.Join(flowThroughputs, ", "), replayResult.AverageThroughput)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Writes the combined analysis of a test on multiple servers as human-readable text.
+// w: where the analysis is written to
+// test: the test that was run
+// tomography: the combined analysis
+// Returns any errors
+func writeTomographyText(w io.Writer, test *testdata.Test, tomography *TomographyRecord) error {
+    _, err := fmt.Fprintf(w, "Combined result for %s across %d servers (tomography):\n\tVerdict: %s\n", test.DisplayName(), tomography.NumPaths, tomography.Verdict)
+    if err != nil {
+        return err
+    }
+    if len(tomography.DifferentiatedPaths) > 0 {
+        _, err = fmt.Fprintf(w, "\tDifferentiated Paths: %s\n", strings.Join(tomography.DifferentiatedPaths, ", "))
+        if err != nil {
+            return err
+        }
+    }
+    if len(tomography.BlockedPaths) > 0 {
+        _, err = fmt.Fprintf(w, "\tBlocked Paths: %s\n", strings.Join(tomography.BlockedPaths, ", "))
+        if err != nil {
+            return err
+        }
+    }
+    if len(tomography.ContentModifiedPaths) > 0 {
+        _, err = fmt.Fprintf(w, "\tContent Modified Paths: %s\n", strings.Join(tomography.ContentModifiedPaths, ", "))
+        if err != nil {
+            return err
+        }
+    }
+    if len(tomography.InconclusivePaths) > 0 {
+        _, err = fmt.Fprintf(w, "\tInconclusive Paths: %s\n", strings.Join(tomography.InconclusivePaths, ", "))
+        if err != nil {
+            return err
+        }
+    }
+    if tomography.Localization != "" {
+        _, err = fmt.Fprintf(w, "\tLocalization Hint: %s\n", tomography.Localization)
+        if err != nil {
+            return err
+        }
+    }
+    for _, comparison := range tomography.Comparisons {
+        treatment := "similar"
+        if comparison.OriginalTreatedDifferently {
+            treatment = "original replay treated differently"
+        }
+        _, err = fmt.Fprintf(w, "\tPath Comparison %s vs %s: Original Throughput Ratio: %f (KS2 P-Value: %f), Random Throughput Ratio: %f (KS2 P-Value: %f), %s\n",
+            comparison.ServerA, comparison.ServerB, comparison.OriginalThroughputRatio, comparison.OriginalKS2PValue, comparison.RandomThroughputRatio, comparison.RandomKS2PValue, treatment)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Writes any results that are waiting to be written. Should be called once all tests finish.
+// Returns any errors
+func (o *Output) Flush() error {
+    if o.format != JSONOutput {
+        return nil
+    }
+    data, err := json.MarshalIndent(o.records, "", "  ")
+    if err != nil {
+        return err
+    }
+    _, err = o.writer.Write(append(data, '\n'))
+    o.records = []OutputRecord{}
+    return err
+}
+
+// Creates the output record of a test result.
+// test: the test that was run
+// testResult: the result of the test on one server
+// tomography: the combined analysis of all the servers; nil if the test ran on one server
+// Returns the output record
+func newOutputRecord(test *testdata.Test, testResult testorchestrator.TestResult, tomography *TomographyRecord) OutputRecord {
+    return OutputRecord{
+        TestSelector: test.Selector(),
+        TestName: test.Name,
+        TestImage: test.Image,
+        TestSize: test.SizeLabel(),
+        TestID: test.TestID,
+        ServerRecord: newServerRecord(testResult),
+        Tomography: tomography,
+    }
+}
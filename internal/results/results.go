@@ -1,2 +1,304 @@
 // Get the results of a test
 package results
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "wehe-cmdline-client/internal/testdata"
+    "wehe-cmdline-client/internal/testorchestrator"
+)
+
+const (
+    recordFilename = "%s_%d_%s.json" // <userID>_<testID>_<test selector>.json
+    infoTimeFormat = time.RFC3339
+)
+
+// A record of a test that is saved to disk so that the result can be audited afterwards.
+type Record struct {
+    UserID string `json:"userID"` // the unique identifier for the user that ran the test
+    TestID int `json:"testID"` // the ID of the test for this specific user
+    TestSelector string `json:"testSelector"` // unique name of the test entered on the command line
+    TestName string `json:"testName"` // pretty name of the test
+    TestImage string `json:"testImage"` // image of the test
+    TestSize string `json:"testSize,omitempty"` // "small" or "large" for port tests
+    ClientVersion string `json:"clientVersion"` // client version of Wehe
+    Date time.Time `json:"date"` // time the test finished
+    ReplayOrder []string `json:"replayOrder"` // the order that the replays ran in
+    Interrupted bool `json:"interrupted,omitempty"` // true if the test was stopped before it finished
+    Servers []ServerRecord `json:"servers"` // the results of the test on each server
+    Tomography *TomographyRecord `json:"tomography,omitempty"` // the combined analysis of all the servers, if the test ran on more than one server
+}
+
+// The result of a test on one server.
+type ServerRecord struct {
+    ServerHostname string `json:"serverHostname"` // hostname that the test took place on
+    ServerIP string `json:"serverIP"` // IP of the server that the test connected to
+    IPFamily string `json:"ipFamily"` // either "IPv4" or "IPv6"
+    MLabSite string `json:"mlabSite,omitempty"` // the MLab site of the server, if it is an MLab server
+    MLabMetro string `json:"mlabMetro,omitempty"` // the metro area of the MLab site, if it is an MLab server
+    Result string `json:"result"` // the verdict of the test
+    KS2Result testdata.KS2Result `json:"ks2Result"` // the stats of the result
+    AreaThreshold float64 `json:"areaThreshold"` // the area threshold that was used to determine differentiation
+    KS2PValueThreshold float64 `json:"ks2PValueThreshold"` // the KS 2 p-value threshold that was used to determine differentiation
+    Replays []ReplayRecord `json:"replays,omitempty"` // the throughputs collected by the client for each replay
+    InitialResult string `json:"initialResult,omitempty"` // the verdict of the first original and random replays
+    ConfirmationResult string `json:"confirmationResult,omitempty"` // the verdict of the confirmation replays, if they ran
+    ConfirmationKS2Result *testdata.KS2Result `json:"confirmationKS2Result,omitempty"` // the stats of the confirmation replays, if they ran
+    ConfirmationAreaThreshold float64 `json:"confirmationAreaThreshold,omitempty"` // the area threshold that was used for the confirmation replays
+    ConfirmationTestID int `json:"confirmationTestID,omitempty"` // the ID of the test that the confirmation replays ran under, if they ran
+    ServerAnalysis *testdata.ServerAnalysis `json:"serverAnalysis,omitempty"` // the analysis from the server's results endpoint, if it was retrieved
+    ServerHistory []testdata.ServerAnalysis `json:"serverHistory,omitempty"` // the analyses of the user's tests up to this one on the server, if they were retrieved
+    IPFamilyComparison *IPFamilyComparisonRecord `json:"ipFamilyComparison,omitempty"` // the comparison with the same test over IPv4, if the test ran over both families
+}
+
+// A comparison of the results of a test on the same server over IPv4 and over IPv6.
+type IPFamilyComparisonRecord struct {
+    IPv4TestID int `json:"ipv4TestID"` // the ID of the test that ran over IPv4
+    IPv4Result string `json:"ipv4Result"` // the verdict over IPv4
+    IPv6Result string `json:"ipv6Result"` // the verdict over IPv6
+    OriginalThroughputDelta float64 `json:"originalThroughputDelta"` // average original replay throughput over IPv6 minus the one over IPv4
+    RandomThroughputDelta float64 `json:"randomThroughputDelta"` // average random replay throughput over IPv6 minus the one over IPv4
+}
+
+// The combined analysis of a test that ran on more than one server.
+type TomographyRecord struct {
+    Verdict string `json:"verdict"` // whether differentiation was detected on all, some, or none of the paths
+    NumPaths int `json:"numPaths"` // number of servers the test ran on
+    DifferentiatedPaths []string `json:"differentiatedPaths"` // hostnames of the servers where differentiation was detected
+    BlockedPaths []string `json:"blockedPaths"` // hostnames of the servers whose responses stopped arriving before the replay finished
+    ContentModifiedPaths []string `json:"contentModifiedPaths"` // hostnames of the servers whose responses did not match the replay file
+    InconclusivePaths []string `json:"inconclusivePaths"` // hostnames of the servers where the results were inconclusive
+    Localization string `json:"localization,omitempty"` // where the differentiation likely takes place
+    Comparisons []PathComparisonRecord `json:"comparisons"` // comparisons of each pair of paths
+}
+
+// A comparison of the throughputs collected by the client on two paths.
+type PathComparisonRecord struct {
+    ServerA string `json:"serverA"` // hostname of the first server
+    ServerB string `json:"serverB"` // hostname of the second server
+    OriginalThroughputRatio float64 `json:"originalThroughputRatio"` // average original replay throughput on ServerA divided by the one on ServerB
+    OriginalKS2PValue float64 `json:"originalKS2PValue"` // KS 2 p-value comparing the original replay throughputs of the two paths
+    RandomThroughputRatio float64 `json:"randomThroughputRatio"` // average random replay throughput on ServerA divided by the one on ServerB
+    RandomKS2PValue float64 `json:"randomKS2PValue"` // KS 2 p-value comparing the random replay throughputs of the two paths
+    OriginalTreatedDifferently bool `json:"originalTreatedDifferently"` // true if only the original replay throughputs differ between the two paths
+}
+
+// The throughputs collected by the client during a replay.
+type ReplayRecord struct {
+    ReplayType string `json:"replayType"` // either "original" or "random"
+    ElapsedTime float64 `json:"elapsedTime"` // number of seconds the replay ran for
+    AverageThroughput float64 `json:"averageThroughput"` // the average Mbps of the replay
+    Throughputs []float64 `json:"throughputs"` // the Mbps of each sample
+    SampleTimes []float64 `json:"sampleTimes"` // the number of seconds since the replay started that each sample was taken
+    Confirmation bool `json:"confirmation,omitempty"` // true if the replay was run to confirm differentiation
+    ResponseCheck *ResponseCheckRecord `json:"responseCheck,omitempty"` // the check of the server's responses, for TCP replays
+    Flows []FlowRecord `json:"flows,omitempty"` // the throughputs of each flow, for UDP replays
+}
+
+// The throughputs collected by the client on one flow of a UDP replay.
+type FlowRecord struct {
+    ClientPort int `json:"clientPort"` // the client port of the flow in the replay file
+    ServerPort int `json:"serverPort"` // the server port of the flow in the replay file
+    AverageThroughput float64 `json:"averageThroughput"` // the average Mbps of the flow
+    Throughputs []float64 `json:"throughputs"` // the Mbps of each sample
+    SampleTimes []float64 `json:"sampleTimes"` // the number of seconds since the replay started that each sample was taken
+}
+
+// The check of the responses that the server sent during a TCP replay.
+type ResponseCheckRecord struct {
+    Status string `json:"status"` // either "ok", "blocked", or "content modified"
+    ExpectedResponses int `json:"expectedResponses"` // number of responses to the packets that were sent
+    CompleteResponses int `json:"completeResponses"` // number of responses that fully arrived
+    ModifiedResponses int `json:"modifiedResponses"` // number of responses whose hash did not match the replay file
+    ExpectedBytes int `json:"expectedBytes"` // number of response bytes to the packets that were sent
+    ReceivedBytes int `json:"receivedBytes"` // number of bytes that arrived
+}
+
+// Creates a new Record of a test.
+// userID: the unique identifier for the user
+// clientVersion: client version of Wehe
+// test: the test that was run
+// replayOrder: the order that the replays ran in
+// testResults: the results of the test for each server
+// tomography: the combined analysis of the servers; nil if the test ran on one server
+// Returns a new Record
+func NewRecord(userID string, clientVersion string, test *testdata.Test, replayOrder []testorchestrator.ReplayType, testResults []testorchestrator.TestResult, tomography *testorchestrator.TomographyResult) Record {
+    record := Record{
+        UserID: userID,
+        TestID: test.TestID,
+        TestSelector: test.Selector(),
+        TestName: test.Name,
+        TestImage: test.Image,
+        TestSize: test.SizeLabel(),
+        ClientVersion: clientVersion,
+        Date: time.Now(),
+        ReplayOrder: []string{},
+        Servers: []ServerRecord{},
+        Tomography: newTomographyRecord(tomography),
+    }
+    for _, replayType := range replayOrder {
+        record.ReplayOrder = append(record.ReplayOrder, replayType.String())
+    }
+    for _, testResult := range testResults {
+        record.Servers = append(record.Servers, newServerRecord(testResult))
+    }
+    return record
+}
+
+// Creates the record of a test result on one server.
+// testResult: the result of the test on one server
+// Returns the server record
+func newServerRecord(testResult testorchestrator.TestResult) ServerRecord {
+    serverRecord := ServerRecord{
+        ServerHostname: testResult.ServerHostname,
+        ServerIP: testResult.ServerIP,
+        IPFamily: testResult.IPFamily,
+        MLabSite: testResult.MLabSite,
+        MLabMetro: testResult.MLabMetro,
+        Result: testResult.Result,
+        KS2Result: testResult.KS2Result,
+        AreaThreshold: testResult.AreaThreshold,
+        KS2PValueThreshold: testResult.KS2PValueThreshold,
+        InitialResult: testResult.InitialResult,
+        ConfirmationResult: testResult.ConfirmationResult,
+        ConfirmationKS2Result: testResult.ConfirmationKS2Result,
+        ConfirmationAreaThreshold: testResult.ConfirmationAreaThreshold,
+        ConfirmationTestID: testResult.ConfirmationTestID,
+        ServerAnalysis: testResult.ServerAnalysis,
+        ServerHistory: testResult.ServerHistory,
+    }
+    if testResult.IPFamilyComparison != nil {
+        comparison := IPFamilyComparisonRecord(*testResult.IPFamilyComparison)
+        serverRecord.IPFamilyComparison = &comparison
+    }
+    for _, replayResult := range testResult.Replays {
+        replayRecord := ReplayRecord{
+            ReplayType: replayResult.ReplayType.String(),
+            ElapsedTime: replayResult.ElapsedTime.Seconds(),
+            AverageThroughput: replayResult.AverageThroughput,
+            Throughputs: replayResult.Throughputs,
+            SampleTimes: replayResult.SampleTimes,
+            Confirmation: replayResult.IsConfirmation,
+        }
+        if replayResult.ResponseCheck != nil {
+            responseCheck := ResponseCheckRecord(*replayResult.ResponseCheck)
+            replayRecord.ResponseCheck = &responseCheck
+        }
+        for _, flowResult := range replayResult.Flows {
+            replayRecord.Flows = append(replayRecord.Flows, FlowRecord{
+                ClientPort: flowResult.ClientPort,
+                ServerPort: flowResult.ServerPort,
+                AverageThroughput: flowResult.AverageThroughput,
+                Throughputs: flowResult.Throughputs,
+                SampleTimes: flowResult.SampleTimes,
+            })
+        }
+        serverRecord.Replays = append(serverRecord.Replays, replayRecord)
+    }
+    return serverRecord
+}
+
+// Creates the record of the combined analysis of a test on multiple servers.
+// tomography: the combined analysis; may be nil
+// Returns the tomography record, or nil if tomography is nil
+func newTomographyRecord(tomography *testorchestrator.TomographyResult) *TomographyRecord {
+    if tomography == nil {
+        return nil
+    }
+    tomographyRecord := &TomographyRecord{
+        Verdict: tomography.Verdict,
+        NumPaths: tomography.NumPaths,
+        DifferentiatedPaths: tomography.DifferentiatedPaths,
+        BlockedPaths: tomography.BlockedPaths,
+        ContentModifiedPaths: tomography.ContentModifiedPaths,
+        InconclusivePaths: tomography.InconclusivePaths,
+        Localization: tomography.Localization,
+        Comparisons: []PathComparisonRecord{},
+    }
+    for _, comparison := range tomography.Comparisons {
+        tomographyRecord.Comparisons = append(tomographyRecord.Comparisons, PathComparisonRecord(comparison))
+    }
+    return tomographyRecord
+}
+
+// Saves the record to disk. The full record, including the throughput samples, is written to the
+// logs directory. A record without the throughput samples is written to the UI directory. A
+// summary line is appended to the info file.
+// uiDir: directory to save the summarized record to
+// logDir: directory to save the full record to
+// infoFile: file to append the summary line to
+// Returns any errors
+func (r Record) Save(uiDir string, logDir string, infoFile string) error {
+    filename := fmt.Sprintf(recordFilename, r.UserID, r.TestID, r.TestSelector)
+
+    err := writeJSON(filepath.Join(logDir, filename), r)
+    if err != nil {
+        return err
+    }
+
+    uiRecord := r
+    uiRecord.Servers = []ServerRecord{}
+    for _, serverRecord := range r.Servers {
+        serverRecord.Replays = nil
+        uiRecord.Servers = append(uiRecord.Servers, serverRecord)
+    }
+    err = writeJSON(filepath.Join(uiDir, filename), uiRecord)
+    if err != nil {
+        return err
+    }
+
+    return appendLine(infoFile, r.summary())
+}
+
+// Creates a one line summary of the record.
+// Returns the tab-separated summary: date, user ID, test ID, test selector, and the verdict for each
+//     server
+func (r Record) summary() string {
+    var verdicts []string
+    for _, serverRecord := range r.Servers {
+        verdicts = append(verdicts, fmt.Sprintf("%s=%s", serverRecord.ServerHostname, serverRecord.Result))
+    }
+    return strings.Join([]string{r.Date.Format(infoTimeFormat), r.UserID, fmt.Sprint(r.TestID), r.TestSelector, strings.Join(verdicts, ",")}, "\t")
+}
+
+// Writes a value to a file as indented JSON. The parent directory is created if it does not exist.
+// filename: path of the file to write
+// v: the value to write
+// Returns any errors
+func writeJSON(filename string, v interface{}) error {
+    data, err := json.MarshalIndent(v, "", "  ")
+    if err != nil {
+        return err
+    }
+    err = os.MkdirAll(filepath.Dir(filename), 0755)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// Appends a line to a file. The file and its parent directory are created if they do not exist.
+// filename: path of the file to append to
+// line: the line to append, without the newline
+// Returns any errors
+func appendLine(filename string, line string) error {
+    err := os.MkdirAll(filepath.Dir(filename), 0755)
+    if err != nil {
+        return err
+    }
+    file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        return err
+    }
+    _, err = file.WriteString(line + "\n")
+    closeErr := file.Close()
+    if err != nil {
+        return err
+    }
+    return closeErr
+}
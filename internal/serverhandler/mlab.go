@@ -0,0 +1,353 @@
+// Finds and connects to MLab servers.
+package serverhandler
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "wehe-cmdline-client/internal/logging"
+)
+
+const (
+    UseMLabHostname = "wehe4.meddle.mobi" // hostname used to request MLab server
+    mlabServersURL = "https://locate.measurementlab.net/v2/nearest/wehe/replay" // used to find which MLab server to use
+    mlabPriorityServersURL = "https://locate.measurementlab.net/v2/priority/nearest/wehe/replay" // used instead of mlabServersURL when there is an API key
+    mlabAccessTokenURLKey = "wss://:4443/v0/envelope/access" // key of the websocket URL in the locate API response
+    mlabHostnamePrefix = "wehe-" // the Wehe service on an MLab machine is at wehe-<machine>
+    mlabTokenLifetime = 2 * time.Minute // how long the access tokens from the locate API are valid
+    mlabTokenMargin = 15 * time.Second // tokens this close to expiring are not used
+    maxMLabQueries = 3 // number of times to query the locate API for each set of servers
+)
+
+// Determines if MLab servers should be used for the tests. Servers that are reachable only over
+// IPv6 are used directly rather than through MLab.
+// hostname: the hostname of the server that the user would like to use
+// Returns true if MLab servers should be used; false otherwise
+func UseMLab(hostname string) bool {
+    return hostname == UseMLabHostname
+}
+
+// The locate API response.
+type mlabLocateResponse struct {
+    Error *MLabLocateError `json:"error"` // set if the request failed
+    Results []mlabLocateResult `json:"results"`
+}
+
+// An error returned by the locate API.
+type MLabLocateError struct {
+    Type string `json:"type"` // the kind of error
+    Title string `json:"title"` // short description of the error
+    Status int `json:"status"` // HTTP status code
+    Detail string `json:"detail"` // longer description of the error
+}
+
+// Gets the description of the error.
+// Returns the status code, title, and detail of the error
+func (e *MLabLocateError) Error() string {
+    message := fmt.Sprintf("MLab locate API error %d (%s): %s", e.Status, http.StatusText(e.Status), e.Title)
+    if e.Detail != "" {
+        message += ": " + e.Detail
+    }
+    return message
+}
+
+// Parameters that tell the locate API where to find servers. By default, the locate API finds
+// the servers nearest to the client's geolocation.
+type MLabLocateParams struct {
+    Country string // ISO 3166-1 country code
+    Region string // ISO 3166-2 region code
+    Latitude string // latitude, given with Longitude
+    Longitude string // longitude, given with Latitude
+    Site string // a specific MLab site
+    APIKey string // API key for priority access
+}
+
+// Gets the URL of the locate API with the parameters.
+// Returns the URL
+func (params MLabLocateParams) getURL() string {
+    locateURL := mlabServersURL
+    query := url.Values{}
+    if params.APIKey != "" {
+        locateURL = mlabPriorityServersURL
+        query.Set("key", params.APIKey)
+    }
+    if params.Country != "" {
+        query.Set("country", params.Country)
+    }
+    if params.Region != "" {
+        query.Set("region", params.Region)
+    }
+    if params.Latitude != "" && params.Longitude != "" {
+        query.Set("lat", params.Latitude)
+        query.Set("lon", params.Longitude)
+    }
+    if params.Site != "" {
+        query.Set("site", params.Site)
+    }
+    if len(query) == 0 {
+        return locateURL
+    }
+    return locateURL + "?" + query.Encode()
+}
+
+// One server in the locate API response.
+type mlabLocateResult struct {
+    Machine string `json:"machine"` // ex. mlab1-lga03.mlab-oti.measurement-lab.org
+    Location struct {
+        City string `json:"city"`
+        Country string `json:"country"`
+    } `json:"location"`
+    URLs map[string]string `json:"urls"`
+}
+
+// An MLab server returned by the locate API.
+type MLabServer struct {
+    Hostname string // hostname of the MLab server
+    AccessToken string // websocket URL and access token to allow client to connect to MLab server
+    Machine string // name of the MLab machine, ex. mlab1-lga03.mlab-oti.measurement-lab.org
+    Site string // the MLab site the machine is in, ex. lga03
+    Metro string // the metro area the site is in, ex. lga
+    City string // city of the site, if returned by the locate API
+    Country string // country of the site, if returned by the locate API
+}
+
+// Gets a list of MLab servers that can be used to run tests, in the order returned by the locate
+// API.
+// locateURL: URL of the locate API, including any parameters
+// Returns list of MLab servers or an error; errors returned by the locate API are MLabLocateErrors
+func GetMLabServers(locateURL string) ([]MLabServer, error) {
+    resp, err := http.Get(locateURL)
+    if err != nil {
+        return nil, err
+    }
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err != nil {
+        return nil, err
+    }
+
+    mlabServers, err := parseMLabServers(body)
+    if err != nil {
+        if _, ok := err.(*MLabLocateError); !ok && resp.StatusCode > 299 {
+            // the locate API did not send a structured error
+            return nil, &MLabLocateError{
+                Status: resp.StatusCode,
+                Title: strings.TrimSpace(string(body)),
+            }
+        }
+        return nil, err
+    }
+    return mlabServers, nil
+}
+
+// Parses the locate API response.
+// resp: the body of the response
+// Returns list of MLab servers or an error
+func parseMLabServers(resp []byte) ([]MLabServer, error) {
+    var locateResponse mlabLocateResponse
+    err := json.Unmarshal(resp, &locateResponse)
+    if err != nil {
+        return nil, err
+    }
+    if locateResponse.Error != nil {
+        return nil, locateResponse.Error
+    }
+
+    var mlabServers []MLabServer
+    for _, result := range locateResponse.Results {
+        site, metro := parseMLabMachine(result.Machine)
+        mlabServers = append(mlabServers, MLabServer{
+            Hostname: mlabHostnamePrefix + result.Machine,
+            AccessToken: result.URLs[mlabAccessTokenURLKey],
+            Machine: result.Machine,
+            Site: site,
+            Metro: metro,
+            City: result.Location.City,
+            Country: result.Location.Country,
+        })
+    }
+    return mlabServers, nil
+}
+
+// Gets the site and metro of an MLab machine from its name.
+// machine: name of the machine in the format <machine>-<site>.<project>.measurement-lab.org, where
+//          the site is the metro followed by a number (ex. mlab1-lga03.mlab-oti.measurement-lab.org)
+// Returns the site and metro, or empty strings if the name is not in the expected format
+func parseMLabMachine(machine string) (string, string) {
+    machineAndSite := strings.SplitN(strings.Split(machine, ".")[0], "-", 2)
+    if len(machineAndSite) != 2 {
+        return "", ""
+    }
+    site := strings.ToLower(machineAndSite[1])
+    metro := strings.TrimRight(site, "0123456789")
+    return site, metro
+}
+
+// Checks if an MLab server is at one of the given sites or metros.
+// mlabServer: the MLab server
+// locations: list of sites (ex. lga03) and metros (ex. lga)
+// Returns true if the server is at one of the locations; false otherwise
+func (mlabServer MLabServer) isIn(locations []string) bool {
+    for _, location := range locations {
+        if location == mlabServer.Site || location == mlabServer.Metro {
+            return true
+        }
+    }
+    return false
+}
+
+// Removes the excluded MLab servers and moves the preferred servers to the front. Otherwise, the
+// order of the locate API is kept.
+// mlabServers: the MLab servers returned by the locate API
+// prefer: sites or metros to try first
+// exclude: sites or metros to never use
+// Returns the ranked list of servers
+func rankMLabServers(mlabServers []MLabServer, prefer []string, exclude []string) []MLabServer {
+    var preferred []MLabServer
+    var others []MLabServer
+    for _, mlabServer := range mlabServers {
+        if mlabServer.isIn(exclude) {
+            continue
+        }
+        if mlabServer.isIn(prefer) {
+            preferred = append(preferred, mlabServer)
+        } else {
+            others = append(others, mlabServer)
+        }
+    }
+    return append(preferred, others...)
+}
+
+// Finds MLab servers to run tests on. Queries the locate API again when the servers from the last
+// query run out or their access tokens are about to expire, and skips servers that have failed.
+type MLabLocator struct {
+    LocateURL string // URL of the locate API, including any parameters
+    prefer []string // sites or metros to try first
+    exclude []string // sites or metros to never use
+    ipFamily string // the address family to connect to the servers with
+    failed map[string]bool // hostnames of servers that have failed during this run
+    candidates []MLabServer // servers from the last query that have not been tried
+    queryTime time.Time // time of the last query
+    carriedTries int // tries on servers that failed during a test, counted in the next server's NumMLabTries
+    logger *logging.Logger // logger to write messages to
+}
+
+// Creates a new MLabLocator.
+// params: parameters that tell the locate API where to find servers
+// prefer: sites (ex. lga03) or metros (ex. lga) to try first
+// exclude: sites or metros to never use
+// ipFamily: the address family to connect to the servers with; one of network.IPFamilyAuto,
+//           network.IPFamilyV4, or network.IPFamilyV6
+// logger: logger to write messages to
+// Returns a new MLabLocator
+func NewMLabLocator(params MLabLocateParams, prefer []string, exclude []string, ipFamily string, logger *logging.Logger) *MLabLocator {
+    return &MLabLocator{
+        LocateURL: params.getURL(),
+        prefer: prefer,
+        exclude: exclude,
+        ipFamily: ipFamily,
+        failed: make(map[string]bool),
+        logger: logger,
+    }
+}
+
+// Connects to MLab servers. The locate API is always queried first so that the access tokens are
+// fresh.
+// numServers: number of servers to connect to
+// getPorts: gets the ports that a server listens on from its hostname
+// Returns the connected servers, or an error if not enough servers could be connected to
+func (l *MLabLocator) Connect(numServers int, getPorts func(hostname string) Ports) ([]*Server, error) {
+    var servers []*Server
+    var mlabErrors []string
+    chosen := make(map[string]bool) // hostnames connected to during this call
+    numTries := l.carriedTries // number tries before successful connection to an MLab server
+    l.candidates = nil
+    numQueries := 0
+    for len(servers) < numServers {
+        if len(l.candidates) == 0 || time.Since(l.queryTime) > mlabTokenLifetime - mlabTokenMargin {
+            if numQueries == maxMLabQueries {
+                break
+            }
+            numQueries += 1
+            err := l.query(chosen)
+            if err != nil {
+                mlabErrors = append(mlabErrors, fmt.Sprintf("Error querying MLab locate API: %v", err))
+                if locateErr, ok := err.(*MLabLocateError); ok && locateErr.Status >= 400 && locateErr.Status < 500 {
+                    // the request is wrong, such as an invalid API key, so querying again won't help
+                    break
+                }
+            }
+            continue
+        }
+
+        mlabServer := l.candidates[0]
+        l.candidates = l.candidates[1:]
+        numTries += 1
+
+        srv, err := New(mlabServer.Hostname, getPorts(mlabServer.Hostname), l.ipFamily, l.logger)
+        if err != nil {
+            l.failed[mlabServer.Hostname] = true
+            mlabErrors = append(mlabErrors, fmt.Sprintf("Error initializing server to %s: %v", mlabServer.Hostname, err))
+            continue
+        }
+
+        err = srv.OpenWebsocket(mlabServer.AccessToken)
+        if err != nil {
+            l.failed[mlabServer.Hostname] = true
+            mlabErrors = append(mlabErrors, fmt.Sprintf("Error connecting to %s websocket: %v", mlabServer.Hostname, err))
+            continue
+        }
+        l.logger.Info("Connected to MLab server %s (site %s, %s %s) after %d tries", mlabServer.Hostname, mlabServer.Site, mlabServer.City, mlabServer.Country, numTries)
+        srv.NumMLabTries = numTries
+        srv.MLabSite = mlabServer.Site
+        srv.MLabMetro = mlabServer.Metro
+        numTries = 0
+        chosen[mlabServer.Hostname] = true
+        servers = append(servers, srv)
+    }
+    l.carriedTries = 0
+
+    // In the app, if MLab fails to connect, we fall back to the EC2 serverhandler. However, because
+    // the command line client is mainly used to test connectivity to MLab, we return an error
+    // instead.
+    if len(servers) != numServers {
+        for _, srv := range servers {
+            srv.CleanUp()
+        }
+        return nil, fmt.Errorf("Initialized only %d/%d MLab servers. Errors:\n%s\n", len(servers), numServers, strings.Join(mlabErrors, "\n"))
+    }
+    return servers, nil
+}
+
+// Marks a server as failed so that it is not used again, such as when its side channel fails
+// during a test. The tries on the server are counted in the NumMLabTries of the next server.
+// srv: the server that failed
+func (l *MLabLocator) MarkFailed(srv *Server) {
+    l.failed[srv.HostName] = true
+    l.carriedTries += srv.NumMLabTries
+}
+
+// Queries the locate API for servers to try.
+// chosen: hostnames of the servers already connected to, which are skipped
+// Returns any errors
+func (l *MLabLocator) query(chosen map[string]bool) error {
+    mlabServers, err := GetMLabServers(l.LocateURL)
+    if err != nil {
+        return err
+    }
+    l.queryTime = time.Now()
+
+    l.candidates = nil
+    for _, mlabServer := range rankMLabServers(mlabServers, l.prefer, l.exclude) {
+        if !l.failed[mlabServer.Hostname] && !chosen[mlabServer.Hostname] {
+            l.candidates = append(l.candidates, mlabServer)
+        }
+    }
+    l.logger.Debug("MLab locate API returned %d servers; %d can be tried", len(mlabServers), len(l.candidates))
+    return nil
+}
@@ -0,0 +1,168 @@
+package serverhandler
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "wehe-cmdline-client/internal/network"
+)
+
+const (
+    locateResponse = `{"results": [
+        {"machine": "mlab1-lga03.mlab-oti.measurement-lab.org", "location": {"city": "New York", "country": "US"},
+         "urls": {"wss://:4443/v0/envelope/access": "wss://wehe-mlab1-lga03.mlab-oti.measurement-lab.org:4443/v0/envelope/access?access_token=a"}},
+        {"machine": "mlab2-ord02.mlab-oti.measurement-lab.org", "location": {"city": "Chicago", "country": "US"},
+         "urls": {"wss://:4443/v0/envelope/access": "wss://wehe-mlab2-ord02.mlab-oti.measurement-lab.org:4443/v0/envelope/access?access_token=b"}},
+        {"machine": "mlab1-lga07.mlab-oti.measurement-lab.org", "location": {"city": "New York", "country": "US"},
+         "urls": {"wss://:4443/v0/envelope/access": "wss://wehe-mlab1-lga07.mlab-oti.measurement-lab.org:4443/v0/envelope/access?access_token=c"}},
+        {"machine": "mlab3-den04.mlab-oti.measurement-lab.org", "location": {"city": "Denver", "country": "US"},
+         "urls": {"wss://:4443/v0/envelope/access": "wss://wehe-mlab3-den04.mlab-oti.measurement-lab.org:4443/v0/envelope/access?access_token=d"}}
+    ]}`
+)
+
+func getSites(mlabServers []MLabServer) []string {
+    var sites []string
+    for _, mlabServer := range mlabServers {
+        sites = append(sites, mlabServer.Site)
+    }
+    return sites
+}
+
+func TestParseMLabServers(t *testing.T) {
+    mlabServers, err := parseMLabServers([]byte(locateResponse))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(mlabServers) != 4 {
+        t.Fatalf("Expected 4, got %d", len(mlabServers))
+    }
+
+    expected := MLabServer{
+        Hostname: "wehe-mlab1-lga03.mlab-oti.measurement-lab.org",
+        AccessToken: "wss://wehe-mlab1-lga03.mlab-oti.measurement-lab.org:4443/v0/envelope/access?access_token=a",
+        Machine: "mlab1-lga03.mlab-oti.measurement-lab.org",
+        Site: "lga03",
+        Metro: "lga",
+        City: "New York",
+        Country: "US",
+    }
+    if mlabServers[0] != expected {
+        t.Errorf("Expected %v, got %v", expected, mlabServers[0])
+    }
+}
+
+func TestRankMLabServers(t *testing.T) {
+    mlabServers, err := parseMLabServers([]byte(locateResponse))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    // Test preferred metro and site are moved to the front in locate API order
+    sites := getSites(rankMLabServers(mlabServers, []string{"den", "ord02"}, nil))
+    expected := []string{"ord02", "den04", "lga03", "lga07"}
+    if len(sites) != len(expected) {
+        t.Fatalf("Expected %v, got %v", expected, sites)
+    }
+    for i := range expected {
+        if sites[i] != expected[i] {
+            t.Errorf("Expected %v, got %v", expected, sites)
+            break
+        }
+    }
+
+    // Test excluded metro is removed, even if it is preferred
+    sites = getSites(rankMLabServers(mlabServers, []string{"lga03"}, []string{"lga"}))
+    if len(sites) != 2 || sites[0] != "ord02" || sites[1] != "den04" {
+        t.Errorf("Expected [ord02 den04], got %v", sites)
+    }
+}
+
+func TestMLabLocateParams(t *testing.T) {
+    // Test no parameters
+    result := MLabLocateParams{}.getURL()
+    if result != mlabServersURL {
+        t.Errorf("Expected %s, got %s", mlabServersURL, result)
+    }
+
+    // Test location parameters and API key
+    result = MLabLocateParams{Country: "US", Latitude: "40.7", Longitude: "-74.0", APIKey: "secret"}.getURL()
+    expected := mlabPriorityServersURL + "?country=US&key=secret&lat=40.7&lon=-74.0"
+    if result != expected {
+        t.Errorf("Expected %s, got %s", expected, result)
+    }
+}
+
+func TestGetMLabServersError(t *testing.T) {
+    // Test structured error
+    locateServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusUnauthorized)
+        w.Write([]byte(`{"error": {"type": "?key=<key>", "title": "API key is invalid", "status": 401, "detail": "The key is not allowed for this service"}}`))
+    }))
+    defer locateServer.Close()
+
+    _, err := GetMLabServers(locateServer.URL)
+    if err == nil {
+        t.Fatal("Expected error for invalid API key, but got none.")
+    }
+    locateErr, ok := err.(*MLabLocateError)
+    if !ok {
+        t.Fatalf("Expected MLabLocateError, got %T", err)
+    }
+    expectedError := "MLab locate API error 401 (Unauthorized): API key is invalid: The key is not allowed for this service"
+    if locateErr.Error() != expectedError {
+        t.Errorf("Expected error '%s', got '%v'", expectedError, locateErr)
+    }
+
+    // Test unstructured error
+    plainServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+    }))
+    defer plainServer.Close()
+
+    _, err = GetMLabServers(plainServer.URL)
+    expectedError = "MLab locate API error 503 (Service Unavailable): upstream unavailable"
+    if err == nil || err.Error() != expectedError {
+        t.Errorf("Expected error '%s', got '%v'", expectedError, err)
+    }
+}
+
+func TestMLabLocatorQuery(t *testing.T) {
+    numQueries := 0
+    locateServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        numQueries += 1
+        w.Write([]byte(locateResponse))
+    }))
+    defer locateServer.Close()
+
+    locator := NewMLabLocator(MLabLocateParams{}, []string{"lga"}, []string{"den04"}, network.IPFamilyAuto, nil)
+    locator.LocateURL = locateServer.URL
+    locator.failed["wehe-mlab1-lga03.mlab-oti.measurement-lab.org"] = true
+
+    // Test failed servers and servers already connected to are skipped
+    chosen := map[string]bool{"wehe-mlab1-lga07.mlab-oti.measurement-lab.org": true}
+    err := locator.query(chosen)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    sites := getSites(locator.candidates)
+    if len(sites) != 1 || sites[0] != "ord02" {
+        t.Errorf("Expected [ord02], got %v", sites)
+    }
+
+    // Test tries on a failed server are carried over
+    locator.MarkFailed(&Server{HostName: "wehe-mlab2-ord02.mlab-oti.measurement-lab.org", NumMLabTries: 2})
+    if locator.carriedTries != 2 {
+        t.Errorf("Expected 2, got %d", locator.carriedTries)
+    }
+
+    // Test all servers failing re-queries the locate API before giving up
+    numQueries = 0
+    _, err = locator.Connect(1, func(hostname string) Ports { return Ports{SideChannel: network.DefaultSideChannelPort, Results: DefaultResultsPort} })
+    if err == nil {
+        t.Error("Expected error when no servers are left, but got none.")
+    }
+    if numQueries != maxMLabQueries {
+        t.Errorf("Expected %d, got %d", maxMLabQueries, numQueries)
+    }
+}
@@ -0,0 +1,23 @@
+package serverhandler
+
+const (
+    DefaultResultsPort = 56566 // port that Wehe servers listen on for the results endpoint
+)
+
+// The ports that a server listens on. Wehe servers listen on the same ports as the servers in the
+// replay files, but a server behind NAT or a local test server may listen on different ports.
+type Ports struct {
+    SideChannel int // port of the side channel
+    Results int // port of the results endpoint
+    Replay map[int]int // maps the server port in a replay file to the port the server listens on; ports not in the map are used as is
+}
+
+// Gets the port that the server listens on for a replay.
+// port: the server port in the replay file
+// Returns the remapped port, or port if it is not remapped
+func (p Ports) ReplayPort(port int) int {
+    if remappedPort, ok := p.Replay[port]; ok {
+        return remappedPort
+    }
+    return port
+}
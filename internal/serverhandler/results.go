@@ -0,0 +1,145 @@
+package serverhandler
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    resultsCommand = "singleResult" // asks the results endpoint for the analysis of one test
+    historyCommand = "multiResults" // asks the results endpoint for the analyses of the user's past tests
+    resultsTimeout = 30 * time.Second // how long to wait for the results endpoint to respond
+    resultsRetryInterval = 2 * time.Second // how long to wait before asking for results that are not ready yet
+)
+
+// The response from the server's results endpoint.
+type resultsResponse struct {
+    Success bool `json:"success"` // true if the analysis was found
+    Error string `json:"error"` // why the analysis could not be returned, if Success is false
+    Response json.RawMessage `json:"response"` // the analysis, or the list of analyses for the history, if Success is true
+}
+
+// Gets the analysis of a test from the server's results endpoint.
+// ctx: context that stops the requests when cancelled
+// userID: the unique identifier for the user
+// testID: the ID of the test for the user
+// replayID: the ID of the replay in the test that the server stored the analysis under
+// tlsConfig: TLS configuration containing the server cert
+// tries: number of times to ask for the analysis, in case the server has not finished analyzing
+// Returns the analysis stored on the server, or any errors
+func (srv *Server) GetResults(ctx context.Context, userID string, testID int, replayID int, tlsConfig *tls.Config, tries int) (testdata.ServerAnalysis, error) {
+    query := url.Values{}
+    query.Set("userID", userID)
+    query.Set("command", resultsCommand)
+    query.Set("historyCount", strconv.Itoa(testID))
+    query.Set("testID", strconv.Itoa(replayID))
+
+    var analysis testdata.ServerAnalysis
+    err := srv.queryResults(ctx, query, tlsConfig, tries, &analysis)
+    if err != nil {
+        return testdata.ServerAnalysis{}, err
+    }
+    return analysis, nil
+}
+
+// Gets the analyses of the user's past tests from the server's results endpoint, which is the
+// history that the apps show.
+// ctx: context that stops the requests when cancelled
+// userID: the unique identifier for the user
+// maxTestID: the ID of the most recent test to include
+// tlsConfig: TLS configuration containing the server cert
+// tries: number of times to ask for the history
+// Returns the analyses stored on the server in the order the server returned them, or any errors
+func (srv *Server) GetResultsHistory(ctx context.Context, userID string, maxTestID int, tlsConfig *tls.Config, tries int) ([]testdata.ServerAnalysis, error) {
+    query := url.Values{}
+    query.Set("userID", userID)
+    query.Set("command", historyCommand)
+    query.Set("maxHistoryCount", strconv.Itoa(maxTestID))
+
+    history := []testdata.ServerAnalysis{}
+    err := srv.queryResults(ctx, query, tlsConfig, tries, &history)
+    if err != nil {
+        return nil, err
+    }
+    return history, nil
+}
+
+// Asks the results endpoint a query until it succeeds, runs out of tries, or ctx is cancelled.
+// ctx: context that stops the requests when cancelled
+// query: the query of the request
+// tlsConfig: TLS configuration containing the server cert
+// tries: number of times to make the request
+// response: where the response of a successful request is decoded to
+// Returns any errors
+func (srv *Server) queryResults(ctx context.Context, query url.Values, tlsConfig *tls.Config, tries int, response interface{}) error {
+    resultsURL := srv.ResultsURL + "?" + query.Encode()
+    client := &http.Client{
+        Timeout: resultsTimeout,
+        Transport: &http.Transport{
+            TLSClientConfig: tlsConfig,
+        },
+    }
+
+    var err error
+    for try := 1; try <= tries; try++ {
+        err = getResults(ctx, client, resultsURL, response)
+        if err == nil {
+            return nil
+        }
+        srv.logger.Debug("Try %d/%d to get results from %s failed: %v", try, tries, srv.HostName, err)
+        if ctx.Err() != nil {
+            break
+        }
+        if try < tries {
+            select {
+            case <-ctx.Done():
+            case <-time.After(resultsRetryInterval):
+            }
+        }
+    }
+    return fmt.Errorf("Unable to get results from %s: %v", srv.HostName, err)
+}
+
+// Makes a request to the results endpoint.
+// ctx: context that stops the request when cancelled
+// client: the HTTP client to make the request with
+// resultsURL: the full URL of the request, including the query
+// response: where the response is decoded to if the server found the results
+// Returns any errors
+func getResults(ctx context.Context, client *http.Client, resultsURL string, response interface{}) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, resultsURL, nil)
+    if err != nil {
+        return err
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode > 299 {
+        return fmt.Errorf("GET Response failed with status code: %d and error: %s", resp.StatusCode, body)
+    }
+
+    var results resultsResponse
+    err = json.Unmarshal(body, &results)
+    if err != nil {
+        return err
+    }
+    if !results.Success {
+        return fmt.Errorf("Server returned error: %s", results.Error)
+    }
+    return json.Unmarshal(results.Response, response)
+}
@@ -0,0 +1,106 @@
+package serverhandler
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Starts a results endpoint that answers with the given responses in order, repeating the last one.
+// Returns the server, a Server that uses it, and the TLS configuration that trusts it
+func startResultsServer(t *testing.T, responses ...string) (*httptest.Server, *Server, *tls.Config) {
+    numRequests := 0
+    ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        if query.Get("userID") != "@user" || (query.Get("command") != resultsCommand && query.Get("command") != historyCommand) {
+            http.Error(w, "bad query " + r.URL.RawQuery, http.StatusBadRequest)
+            return
+        }
+        w.Write([]byte(responses[min(numRequests, len(responses) - 1)]))
+        numRequests += 1
+    }))
+    t.Cleanup(ts.Close)
+    caCertPool := x509.NewCertPool()
+    caCertPool.AddCert(ts.Certificate())
+    srv := &Server{HostName: "wehe.example.org", ResultsURL: ts.URL + "/Results"}
+    return ts, srv, &tls.Config{RootCAs: caCertPool}
+}
+
+func TestGetResults(t *testing.T) {
+    // numbers may be strings, and stats that are not known are kept
+    _, srv, tlsConfig := startResultsServer(t, `{"success": true, "response": {"userID": "@user", "historyCount": "7", "testID": "1",
+        "replayName": "VideoRandom-01012024", "area_test": 0.6, "ks2pVal": "0.001", "xput_avg_original": 1.5, "xput_avg_test": "8", "xput_max_test": 9.5}}`)
+    analysis, err := srv.GetResults(context.Background(), "@user", 7, 1, tlsConfig, 1)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if analysis.HistoryCount != 7 || analysis.TestID != 1 || analysis.AreaTest != 0.6 || analysis.KS2pVal != 0.001 || analysis.XputAvgTest != 8 {
+        t.Errorf("Unexpected analysis: %+v", analysis)
+    }
+    if len(analysis.Extra) != 1 || analysis.Extra["xput_max_test"] != 9.5 {
+        t.Errorf("Expected unknown stats in Extra, got %v", analysis.Extra)
+    }
+
+    // the server did not find the analysis
+    _, srv, tlsConfig = startResultsServer(t, `{"success": false, "error": "No result found"}`)
+    _, err = srv.GetResults(context.Background(), "@user", 7, 1, tlsConfig, 1)
+    if err == nil || !strings.Contains(err.Error(), "No result found") {
+        t.Errorf("Expected the server's error, got %v", err)
+    }
+
+    _, srv, tlsConfig = startResultsServer(t, `not json`)
+    _, err = srv.GetResults(context.Background(), "@user", 7, 1, tlsConfig, 1)
+    if err == nil {
+        t.Errorf("Expected an error for an invalid response")
+    }
+}
+
+func TestGetResultsRetry(t *testing.T) {
+    _, srv, tlsConfig := startResultsServer(t, `{"success": false, "error": "Result not ready yet"}`, `{"success": true, "response": {"historyCount": 7, "area_test": 0.2}}`)
+    analysis, err := srv.GetResults(context.Background(), "@user", 7, 1, tlsConfig, 2)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if analysis.HistoryCount != 7 || analysis.AreaTest != 0.2 || analysis.Extra != nil {
+        t.Errorf("Unexpected analysis: %+v", analysis)
+    }
+}
+
+func TestGetResultsCancelled(t *testing.T) {
+    _, srv, tlsConfig := startResultsServer(t, `{"success": false, "error": "Result not ready yet"}`)
+    ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+
+    // the retries stop as soon as the context is cancelled
+    start := time.Now()
+    _, err := srv.GetResults(ctx, "@user", 7, 1, tlsConfig, 10)
+    if err == nil {
+        t.Errorf("Expected an error when the context is cancelled")
+    }
+    if elapsed := time.Since(start); elapsed > resultsRetryInterval {
+        t.Errorf("Expected the retries to stop, took %v", elapsed)
+    }
+}
+
+func TestGetResultsHistory(t *testing.T) {
+    _, srv, tlsConfig := startResultsServer(t, `{"success": true, "response": [{"historyCount": "3", "replayName": "MusicRandom"}, {"historyCount": "7", "replayName": "VideoRandom"}]}`)
+    history, err := srv.GetResultsHistory(context.Background(), "@user", 7, tlsConfig, 1)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(history) != 2 || history[0].HistoryCount != 3 || history[1].ReplayName != "VideoRandom" {
+        t.Errorf("Unexpected history: %+v", history)
+    }
+
+    // a user with no tests on the server
+    _, srv, tlsConfig = startResultsServer(t, `{"success": true, "response": []}`)
+    history, err = srv.GetResultsHistory(context.Background(), "@user", 7, tlsConfig, 1)
+    if err != nil || history == nil || len(history) != 0 {
+        t.Errorf("Expected an empty history, got %v, %v", history, err)
+    }
+}
@@ -4,26 +4,26 @@ package serverhandler
 import (
     "context"
     "crypto/tls"
-    "encoding/json"
     "fmt"
     "io"
     "net"
     "net/http"
     "strconv"
+    "strings"
+    "sync"
     "time"
 
     "github.com/gorilla/websocket"
 
     "wehe-cmdline-client/internal/analyzer"
+    "wehe-cmdline-client/internal/logging"
     "wehe-cmdline-client/internal/network"
     "wehe-cmdline-client/internal/testdata"
 )
 
 const (
-    UseMLabHostname = "wehe4.meddle.mobi" // hostname used to request MLab server
-    resultsURL = "https://%s:56566/Results"
-    publicIPURL = "http://%s:%d/WHATSMYIPMAN"
-    mlabServersURL = "https://locate.measurementlab.net/v2/nearest/wehe/replay" // used to find which MLab server to use
+    resultsURL = "https://%s/Results"
+    publicIPURL = "http://%s/WHATSMYIPMAN"
 
     ask4PermissionOkStatus = "0"
     ask4PermissionErrorStatus = "1"
@@ -37,30 +37,58 @@ const (
 
 type Server struct {
     HostName string  // hostname of the server
-    IP string // ip of the server
+    IP string // ip of the server that is used for the test
+    IPs []string // ips of the server in the preferred address family, tried in order when connecting
+    IPFamily string // family of IP, either network.IPv4 or network.IPv6
+    Ports Ports // ports that the server listens on
     SideChannel network.SideChannel // Side Channel connection
     ResultsURL string // URL to analyze and get results
-    PublicIPURL string // URL for client to get its public IP
     MLabWebsocket *websocket.Conn // websocket connection for MLab
     NumMLabTries int // number of tries before successful connection to MLab server
+    MLabSite string // the MLab site of the server, ex. lga03; empty if server is not MLab
+    MLabMetro string // the metro area of the MLab site, ex. lga; empty if server is not MLab
     ThroughputCalculator *analyzer.Analyzer // analyzer to calculate throughputs
+    ResponseCheck *network.ResponseCheck // the check of the server's responses during the last replay; nil if the replay was UDP
+    UDPFlows []*network.UDPFlow // the flows of the last replay, each with its own throughputs; nil if the replay was TCP
+    logger *logging.Logger // logger to write messages to
+
+    sideChannelMutex sync.Mutex // guards SideChannel and interrupted against Interrupt, which runs on another goroutine
+    interrupted bool // true once Interrupt is called; side channels that connect afterwards are closed right away
 }
 
 // Creates a new Server struct.
 // hostname: hostname of the server to connect to
+// ports: ports that the server listens on
+// ipFamily: the address family to connect with; one of network.IPFamilyAuto, network.IPFamilyV4,
+//           or network.IPFamilyV6
+// logger: logger to write messages to
 // Returns a new Server or any errors
-func New(hostname string) (*Server, error) {
+func New(hostname string, ports Ports, ipFamily string, logger *logging.Logger) (*Server, error) {
     ips, err := net.LookupHost(hostname) // do DNS lookup
     if err != nil {
         return nil, err
     }
-    return &Server{
+    ips, err = network.FilterAddresses(ips, ipFamily)
+    if err != nil {
+        return nil, fmt.Errorf("Unable to use %s: %v", hostname, err)
+    }
+    srv := &Server{
         HostName: hostname,
-        IP: ips[0],
-        ResultsURL: fmt.Sprintf(resultsURL, ips[0]),
-        PublicIPURL: fmt.Sprintf(publicIPURL, ips[0]),
+        IPs: ips,
+        Ports: ports,
         NumMLabTries: 0,
-    }, nil
+        logger: logger,
+    }
+    srv.setIP(ips[0])
+    return srv, nil
+}
+
+// Sets the IP of the server that is used for the test.
+// ip: one of the IPs of the server
+func (srv *Server) setIP(ip string) {
+    srv.IP = ip
+    srv.IPFamily, _ = network.AddressFamily(ip)
+    srv.ResultsURL = fmt.Sprintf(resultsURL, net.JoinHostPort(ip, strconv.Itoa(srv.Ports.Results)))
 }
 
 // Opens a websocket connection.
@@ -75,112 +103,41 @@ func (srv *Server) OpenWebsocket(websocketURL string) error {
     return nil
 }
 
-//TODO: move below to new mlab file if this file gets too long
-
-// Determines if MLab servers should be used for the tests.
-// hostname: the hostname of the server that the user would like to use
-// Returns a boolean, true if MLab servers should be used; false otherwise, or an error
-func UseMLab(hostname string) (bool, error) {
-    useMLab := true
-    if hostname != UseMLabHostname {
-        addrs, err := net.LookupHost(hostname)
-        if err != nil {
-            return false, err
-        }
-        ipType, err := checkIPAddressType(addrs[0])
-        if err != nil {
-            return false, err
-        }
-        if ipType == "IPv4" {
-            useMLab = false
-        }
-    }
-    return useMLab, nil
-}
-
-// Determines if an IP address is IPv4 or IPv6.
-// ipAddress: the IP address
-// Returns "IPv4" if the address is a v4 address, "IPv6" if the address is a v6 address, or an error
-func checkIPAddressType(ipAddress string) (string, error) {
-	addr := net.ParseIP(ipAddress)
-	if addr == nil {
-		return "", fmt.Errorf("%s is an invalid IP address.\n", ipAddress)
-	}
-
-	if ip4 := addr.To4(); ip4 != nil {
-		return "IPv4", nil
-	}
-
-	if ip6 := addr.To16(); ip6 != nil {
-		return "IPv6", nil
-	}
-
-	return "", fmt.Errorf("%s is an unknown IP type", ipAddress)
-}
-
-// 1 of 3 structs to unmarshal MLab websocket info
-type MLabServerResultsJson struct {
-    MLabServersJson []MLabServerJson `json:"results"`
-}
-
-// 1 of 3 structs to unmarshal MLab websocket info
-type MLabServerJson struct {
-    Machine string `json:"machine"`
-    MLabServerURLs MLabServerURLs `json:"urls"`
-}
-
-// 1 of 3 structs to unmarshal MLab websocket info
-type MLabServerURLs struct {
-    AccessToken string `json:"wss://:4443/v0/envelope/access"`
-}
-
-// The hostname and the websocket access token needed to connect to an MLab server
-type MLabServer struct {
-    Hostname string // hostname of the MLab server
-    AccessToken string // websocket URL and access token to allow client to connect to MLab server
-}
-
-// Gets a list of MLab servers that can be used to run tests.
-// Returns list of MLab servers or an error
-func GetMLabServers() ([]MLabServer, error) {
-    resp, err := HTTPGet(mlabServersURL)
-    if err != nil {
-        return nil, err
-    }
-
-    var mlabServerResultsJson MLabServerResultsJson
-    err = json.Unmarshal(resp, &mlabServerResultsJson)
-    if err != nil {
-        return nil, err
-    }
-
-    var mlabServers []MLabServer
-    for _, server := range mlabServerResultsJson.MLabServersJson {
-        mlabServer := MLabServer{
-            Hostname: "wehe-" + server.Machine,
-            AccessToken: server.MLabServerURLs.AccessToken,
-        }
-        mlabServers = append(mlabServers, mlabServer)
-    }
-    return mlabServers, nil
-}
-
-// Connects to the side channel of the server.
+// Connects to the side channel of the server. Each IP of the server is tried in order until one
+// connects; the rest of the test uses that IP.
 // id: the ID number to assign the side channel instance
 // tlsConfig: TLS configuration containing the server cert
 // Returns any errors
 func (srv *Server) ConnectToSideChannel(id int, tlsConfig *tls.Config) error {
-    sideChannel, err := network.NewSideChannel(id, srv.IP, tlsConfig)
-    if err != nil {
-        return err
+    var errs []string
+    for _, ip := range srv.IPs {
+        sideChannel, err := network.NewSideChannel(id, ip, srv.Ports.SideChannel, tlsConfig, srv.logger)
+        if err != nil {
+            srv.logger.Warn("Unable to connect to the side channel of %s at %s: %v", srv.HostName, ip, err)
+            errs = append(errs, err.Error())
+            continue
+        }
+        srv.sideChannelMutex.Lock()
+        if srv.interrupted {
+            srv.sideChannelMutex.Unlock()
+            sideChannel.CleanUp()
+            return fmt.Errorf("Test was interrupted while connecting to the side channel of %s.", srv.HostName)
+        }
+        srv.setIP(ip)
+        // a new test on the same server replaces the side channel of the previous test
+        oldSideChannel := srv.SideChannel
+        srv.SideChannel = sideChannel
+        srv.sideChannelMutex.Unlock()
+        oldSideChannel.CleanUp()
+        srv.logger.Debug("Connected to the side channel of %s at %s over %s", srv.HostName, ip, srv.IPFamily)
+        return nil
     }
-    srv.SideChannel = sideChannel
-    return nil
+    return fmt.Errorf("Unable to connect to the side channel of %s on any address:\n%s", srv.HostName, strings.Join(errs, "\n"))
 }
 
 // Tells the server that client wants to run a replay.
 // isTCP: true if this replay uses TCP; false if it uses UDP
-// replayPort: the server port number that the client will send packets to for this replay
+// replayPort: the server port number in the replay file; it is remapped by srv.Ports
 // userID: the unique identifier for this user
 // replayID: indicates whether this is the original or random replay
 // testID: the ID of the test for this specific user
@@ -188,7 +145,8 @@ func (srv *Server) ConnectToSideChannel(id int, tlsConfig *tls.Config) error {
 // clientVersion: client version of Wehe
 // Returns any errors
 func (srv *Server) SendID(isTCP bool, replayPort int, userID string, replayID int, replayName string, testID int, isLastReplay bool, clientVersion string) error {
-    publicIP, err := getClientPublicIP(srv.HostName, replayPort, isTCP)
+    // ask the IP of the side channel so that the public IP is in the same address family as the replay
+    publicIP, err := getClientPublicIP(srv.IP, srv.Ports.ReplayPort(replayPort), isTCP)
     if err != nil {
         return err
     }
@@ -221,19 +179,19 @@ func HTTPGet(url string) ([]byte, error) {
 }
 
 // Get the client's public IP.
-// hostname: hostname of the server
+// hostname: hostname or IP of the server
 // port: port number to make public IP request
 // isTCP: true if test is TCP; false if test is UDP
 // Returns client's public IP or an error
 func getClientPublicIP(hostname string, port int, isTCP bool) (string, error) {
     if isTCP {
-        resp, err := HTTPGet(fmt.Sprintf(publicIPURL, hostname, port))
+        resp, err := HTTPGet(fmt.Sprintf(publicIPURL, net.JoinHostPort(hostname, strconv.Itoa(port))))
         if err != nil {
             return "", err
         }
         return string(resp), nil
     } else {
-        udpServer, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", hostname, port))
+        udpServer, err := net.ResolveUDPAddr("udp", net.JoinHostPort(hostname, strconv.Itoa(port)))
         if err != nil {
             return "", err
         }
@@ -303,6 +261,13 @@ func (srv *Server) checkPermissions(status string, info string) (int, error) {
     return -1, fmt.Errorf(errStr + "\n")
 }
 
+// Sends information about the client's host and network to the server.
+// stats: JSON string containing the information about the client
+// Returns any errors
+func (srv *Server) SendMobileStats(stats string) error {
+    return srv.SideChannel.SendMobileStats(stats)
+}
+
 // Send and receive packets to and from the server.
 // replayInfo: information needed to run a replay
 // samplesPerReplay: number of samples that should be taken per replay
@@ -312,9 +277,11 @@ func (srv *Server) checkPermissions(status string, info string) (int, error) {
 // errChan: channel to return any errors
 func (srv *Server) SendAndReceivePackets(replayInfo testdata.ReplayInfo, samplesPerReplay int, testLength int, ctx context.Context, cancel context.CancelFunc, errChan chan<- error) {
     srv.initAnalyzer(replayInfo, samplesPerReplay, testLength)
+    srv.ResponseCheck = nil
+    srv.UDPFlows = nil
 
     if replayInfo.IsTCP {
-        tcpClient, err := network.NewTCPClient(srv.IP, replayInfo.CSPair.ServerPort, replayInfo.IsPortTest)
+        tcpClient, err := network.NewTCPClient(srv.IP, srv.Ports.ReplayPort(replayInfo.CSPair.ServerPort), replayInfo.IsPortTest, replayInfo.Packets, srv.logger)
         if err != nil {
             cancel()
             errChan <- err
@@ -340,34 +307,32 @@ func (srv *Server) SendAndReceivePackets(replayInfo testdata.ReplayInfo, samples
             errChan <- err
             return
         }
+        responseCheck := tcpClient.Responses.Result()
+        srv.ResponseCheck = &responseCheck
+        if responseCheck.Status != network.ResponsesOK {
+            srv.logger.Warn("Responses from %s were %s: %d/%d responses complete, %d modified, %d/%d bytes received", srv.HostName, responseCheck.Status, responseCheck.CompleteResponses, responseCheck.ExpectedResponses, responseCheck.ModifiedResponses, responseCheck.ReceivedBytes, responseCheck.ExpectedBytes)
+        }
     } else {
-        // make UDP Client
-        udpClient, err := network.NewUDPClient(srv.IP, replayInfo.CSPair.ServerPort)
+        // make a UDP client for each flow
+        udpReplay, err := network.NewUDPReplay(srv.IP, replayInfo.UDPFlows, srv.Ports.ReplayPort, srv.logger)
         if err != nil {
             cancel()
             errChan <- err
             return
         }
-        defer udpClient.CleanUp()
-
-        sendErrChan := make(chan error)
-        recvErrChan := make(chan error)
+        defer udpReplay.CleanUp()
 
-        // start sender and receiver to send and receive UDP packets to and from the server
-        go udpClient.SendPackets(replayInfo.Packets, !replayInfo.IsPortTest, ctx, cancel, sendErrChan)
-        go udpClient.RecvPackets(srv.ThroughputCalculator, ctx, cancel, recvErrChan)
-
-        // wait for sender and receiver to finish
-        err = <-sendErrChan
-        if err != nil {
-            errChan <- err
-            return
+        // send and receive the packets of all the flows, with a throughput calculator for each flow
+        replayTime := getReplayTime(replayInfo, testLength)
+        newFlowCalculator := func() *analyzer.Analyzer {
+            return analyzer.NewAnalyzer(replayTime, samplesPerReplay)
         }
-        err = <-recvErrChan
+        err = udpReplay.Run(srv.ThroughputCalculator, newFlowCalculator, !replayInfo.IsPortTest, ctx, cancel)
         if err != nil {
             errChan <- err
             return
         }
+        srv.UDPFlows = udpReplay.Flows
     }
     errChan <- nil
 }
@@ -377,7 +342,14 @@ func (srv *Server) SendAndReceivePackets(replayInfo testdata.ReplayInfo, samples
 // samplesPerReplay: number of samples that should be taken per replay
 // testLength: number of seconds to run the test
 func (srv *Server) initAnalyzer(replayInfo testdata.ReplayInfo, samplesPerReplay int, testLength int) {
-    // calculate the time that the replay will run for
+    srv.ThroughputCalculator = analyzer.NewAnalyzer(getReplayTime(replayInfo, testLength), samplesPerReplay)
+}
+
+// Calculates the time that a replay will run for.
+// replayInfo: information needed to run a replay
+// testLength: number of seconds to run the test
+// Returns the length of the replay
+func getReplayTime(replayInfo testdata.ReplayInfo, testLength int) time.Duration {
     replayTime := time.Duration((testLength / 2) * int(time.Second))
     if replayInfo.IsPortTest {
         replayTime = min(replayTime, network.PortReplayTimeout)
@@ -386,7 +358,7 @@ func (srv *Server) initAnalyzer(replayInfo testdata.ReplayInfo, samplesPerReplay
     } else {
         replayTime = min(replayTime, network.UDPReplayTimeout)
     }
-    srv.ThroughputCalculator = analyzer.NewAnalyzer(replayTime, samplesPerReplay)
+    return replayTime
 }
 
 // Sends the it took for a replay to run, the throughput samples for that replay, and the time
@@ -425,13 +397,26 @@ func (srv *Server) AnalyzeTest() (testdata.KS2Result, error) {
     return ks2Result, nil
 }
 
+// Closes the side channel so that any request waiting on the server returns right away. Safe to
+// call while another goroutine is using or connecting the side channel; a side channel that
+// connects after the server is interrupted is closed right away.
+func (srv *Server) Interrupt() {
+    srv.sideChannelMutex.Lock()
+    defer srv.sideChannelMutex.Unlock()
+    srv.interrupted = true
+    srv.SideChannel.CleanUp()
+}
+
 func (srv *Server) CleanUp() {
+    srv.sideChannelMutex.Lock()
     srv.SideChannel.CleanUp()
+    srv.sideChannelMutex.Unlock()
     var err error
     if srv.MLabWebsocket != nil {
         err = srv.MLabWebsocket.Close()
+        srv.MLabWebsocket = nil
     }
     if err != nil {
-        fmt.Printf("Error while cleaning up server: %s\n", err)
+        srv.logger.Warn("Error while cleaning up server %s: %s", srv.HostName, err)
     }
 }
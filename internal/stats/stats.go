@@ -0,0 +1,98 @@
+// Statistics used to compare throughput samples.
+package stats
+
+import (
+    "math"
+    "sort"
+)
+
+const (
+    ksSeriesTerms = 100 // number of terms used to approximate the Kolmogorov distribution
+)
+
+// Compares two sets of samples. This is a simplified version of the analysis done by the Wehe
+// server: the area test is the area between the two CDFs divided by the largest sample, and the
+// p-value is from a 2-sample Kolmogorov-Smirnov test.
+// a: the first set of samples
+// b: the second set of samples
+// Returns the area test and the p-value; the area is 0 and the p-value is 1 if either set is empty
+func KS2Test(a []float64, b []float64) (float64, float64) {
+    if len(a) == 0 || len(b) == 0 {
+        return 0, 1
+    }
+
+    a = sortedCopy(a)
+    b = sortedCopy(b)
+    values := sortedCopy(append(append([]float64{}, a...), b...))
+
+    // walk through every sample in order, tracking both CDFs
+    maxDistance := 0.0
+    area := 0.0
+    for i, value := range values {
+        distance := math.Abs(cdf(a, value) - cdf(b, value))
+        maxDistance = math.Max(maxDistance, distance)
+        if i + 1 < len(values) {
+            area += distance * (values[i + 1] - value)
+        }
+    }
+    maxValue := values[len(values) - 1]
+    if maxValue > 0 {
+        area = area / maxValue
+    }
+
+    n := float64(len(a))
+    m := float64(len(b))
+    en := math.Sqrt(n * m / (n + m))
+    return area, kolmogorovQ((en + 0.12 + 0.11 / en) * maxDistance)
+}
+
+// Calculates the average of samples.
+// samples: the samples
+// Returns the average, or 0 if there are no samples
+func Average(samples []float64) float64 {
+    if len(samples) == 0 {
+        return 0
+    }
+    sum := 0.0
+    for _, sample := range samples {
+        sum += sample
+    }
+    return sum / float64(len(samples))
+}
+
+// Calculates the survival function of the Kolmogorov distribution.
+// lambda: the scaled KS statistic
+// Returns the p-value
+func kolmogorovQ(lambda float64) float64 {
+    if lambda < 1e-3 {
+        return 1
+    }
+    sum := 0.0
+    sign := 1.0
+    for j := 1; j <= ksSeriesTerms; j++ {
+        term := sign * 2 * math.Exp(-2 * float64(j * j) * lambda * lambda)
+        sum += term
+        if math.Abs(term) < 1e-12 {
+            break
+        }
+        sign = -sign
+    }
+    return math.Min(math.Max(sum, 0), 1)
+}
+
+// Calculates the empirical CDF of sorted samples.
+// samples: the samples, sorted in increasing order
+// value: the value to evaluate the CDF at
+// Returns the fraction of samples less than or equal to value
+func cdf(samples []float64, value float64) float64 {
+    return float64(sort.SearchFloat64s(samples, math.Nextafter(value, math.Inf(1)))) / float64(len(samples))
+}
+
+// Sorts a copy of samples.
+// samples: the samples
+// Returns the sorted copy
+func sortedCopy(samples []float64) []float64 {
+    sorted := append([]float64{}, samples...)
+    sort.Float64s(sorted)
+    return sorted
+}
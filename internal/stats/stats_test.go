@@ -0,0 +1,42 @@
+package stats
+
+import (
+    "math"
+    "testing"
+)
+
+func TestKS2Test(t *testing.T) {
+    // Test identical samples
+    samples := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+    area, pValue := KS2Test(samples, samples)
+    if area != 0 || pValue != 1 {
+        t.Errorf("Expected 0 and 1, got %f and %f", area, pValue)
+    }
+
+    // Test samples that do not overlap
+    shifted := []float64{11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+    area, pValue = KS2Test(samples, shifted)
+    if math.Abs(area - 0.5) > 1e-9 {
+        t.Errorf("Expected 0.5, got %f", area)
+    }
+    if pValue > 0.001 {
+        t.Errorf("Expected p-value below 0.001, got %f", pValue)
+    }
+
+    // Test empty samples
+    area, pValue = KS2Test(nil, samples)
+    if area != 0 || pValue != 1 {
+        t.Errorf("Expected 0 and 1, got %f and %f", area, pValue)
+    }
+}
+
+func TestAverage(t *testing.T) {
+    result := Average([]float64{1, 2, 3, 6})
+    if result != 3 {
+        t.Errorf("Expected 3, got %f", result)
+    }
+    result = Average(nil)
+    if result != 0 {
+        t.Errorf("Expected 0, got %f", result)
+    }
+}
@@ -0,0 +1,110 @@
+package testdata
+
+import (
+    "encoding/json"
+    "strconv"
+    "strings"
+)
+
+// The analysis of a test that is stored on the server, as returned by the server's results
+// endpoint.
+type ServerAnalysis struct {
+    UserID string `json:"userID"` // the unique identifier for the user
+    HistoryCount int `json:"historyCount"` // the test ID of the test for the user
+    TestID int `json:"testID"` // the replay ID that the analysis is stored under
+    ReplayName string `json:"replayName"` // name of the replay
+    Date string `json:"date"` // when the test was analyzed
+    ExtraString string `json:"extraString"` // the extra string sent by the client
+    AreaTest float64 `json:"area_test"` // the area between the CDFs of the original and random throughputs
+    KS2RatioTest float64 `json:"ks2_ratio_test"` // the ratio of KS 2 tests that passed
+    XputAvgOriginal float64 `json:"xput_avg_original"` // average throughput of the original replay in Mbps
+    XputAvgTest float64 `json:"xput_avg_test"` // average throughput of the random replay in Mbps
+    KS2dVal float64 `json:"ks2dVal"` // the KS 2 test statistic
+    KS2pVal float64 `json:"ks2pVal"` // the KS 2 test p-value
+    Extra map[string]interface{} `json:"extra,omitempty"` // any other stats returned by the server
+}
+
+// Decodes the analysis returned by the server. The server may send numbers as strings, so both are
+// accepted. Any fields that are not part of ServerAnalysis are kept in Extra.
+// data: the JSON object returned by the server
+// Returns any errors
+func (sa *ServerAnalysis) UnmarshalJSON(data []byte) error {
+    var fields map[string]interface{}
+    err := json.Unmarshal(data, &fields)
+    if err != nil {
+        return err
+    }
+
+    *sa = ServerAnalysis{}
+    sa.UserID = popString(fields, "userID")
+    sa.HistoryCount = int(popFloat(fields, "historyCount"))
+    sa.TestID = int(popFloat(fields, "testID"))
+    sa.ReplayName = popString(fields, "replayName")
+    sa.Date = popString(fields, "date")
+    sa.ExtraString = popString(fields, "extraString")
+    sa.AreaTest = popFloat(fields, "area_test")
+    sa.KS2RatioTest = popFloat(fields, "ks2_ratio_test")
+    sa.XputAvgOriginal = popFloat(fields, "xput_avg_original")
+    sa.XputAvgTest = popFloat(fields, "xput_avg_test")
+    sa.KS2dVal = popFloat(fields, "ks2dVal")
+    sa.KS2pVal = popFloat(fields, "ks2pVal")
+    if len(fields) > 0 {
+        sa.Extra = fields
+    }
+    return nil
+}
+
+// Converts the server's analysis into the stats used to determine differentiation.
+// Returns the KS2Result
+func (sa ServerAnalysis) KS2Result() KS2Result {
+    return KS2Result{
+        Area0var: sa.AreaTest,
+        KS2pVal: sa.KS2pVal,
+        OriginalAvgThroughput: sa.XputAvgOriginal,
+        RandomAvgThroughput: sa.XputAvgTest,
+    }
+}
+
+// Removes a key from a JSON object and returns its value as a string.
+// fields: the JSON object
+// key: the key to remove
+// Returns the value, or an empty string if the key does not exist
+func popString(fields map[string]interface{}, key string) string {
+    val, ok := fields[key]
+    if !ok {
+        return ""
+    }
+    delete(fields, key)
+    switch v := val.(type) {
+    case string:
+        return v
+    case float64:
+        return strconv.FormatFloat(v, 'f', -1, 64)
+    default:
+        return ""
+    }
+}
+
+// Removes a key from a JSON object and returns its value as a number.
+// fields: the JSON object
+// key: the key to remove
+// Returns the value, or 0 if the key does not exist or is not a number
+func popFloat(fields map[string]interface{}, key string) float64 {
+    val, ok := fields[key]
+    if !ok {
+        return 0
+    }
+    delete(fields, key)
+    switch v := val.(type) {
+    case float64:
+        return v
+    case string:
+        f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+        if err != nil {
+            return 0
+        }
+        return f
+    default:
+        return 0
+    }
+}
@@ -0,0 +1,37 @@
+package testdata
+
+import (
+    "encoding/json"
+    "testing"
+)
+
+func TestServerAnalysisUnmarshalJSON(t *testing.T) {
+    var analysis ServerAnalysis
+    err := json.Unmarshal([]byte(`{"userID": "@user", "historyCount": "12", "testID": 1, "date": "2024-01-01 10:00:00",
+        "area_test": "0.45", "ks2pVal": 0.02, "xput_avg_original": " 3.5 ", "xput_avg_test": "not a number",
+        "ks2_ratio_test": null, "server_version": "4.1", "original_xputs": [1, 2]}`), &analysis)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if analysis.UserID != "@user" || analysis.HistoryCount != 12 || analysis.TestID != 1 || analysis.Date != "2024-01-01 10:00:00" {
+        t.Errorf("Unexpected analysis: %+v", analysis)
+    }
+    expected := KS2Result{Area0var: 0.45, KS2pVal: 0.02, OriginalAvgThroughput: 3.5, RandomAvgThroughput: 0}
+    if analysis.KS2Result() != expected {
+        t.Errorf("Expected %v, got %v", expected, analysis.KS2Result())
+    }
+    if len(analysis.Extra) != 2 || analysis.Extra["server_version"] != "4.1" {
+        t.Errorf("Expected only unknown fields in Extra, got %v", analysis.Extra)
+    }
+
+    // a user ID made only of digits is still a string
+    err = json.Unmarshal([]byte(`{"userID": 12345}`), &analysis)
+    if err != nil || analysis.UserID != "12345" || analysis.Extra != nil {
+        t.Errorf("Unexpected analysis: %+v, %v", analysis, err)
+    }
+
+    err = json.Unmarshal([]byte(`[1, 2]`), &analysis)
+    if err == nil {
+        t.Errorf("Expected an error for an analysis that is not an object")
+    }
+}
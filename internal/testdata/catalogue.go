@@ -0,0 +1,147 @@
+package testdata
+
+import (
+    "fmt"
+    "strings"
+)
+
+// The categories of tests in the tests configuration file.
+const (
+    CategoryVideo = "VIDEO"
+    CategoryMusic = "MUSIC"
+    CategoryConferencing = "CONFERENCING"
+    CategorySmallPort = "SMALL_PORT"
+    CategoryLargePort = "LARGE_PORT"
+)
+
+// The languages that tests can be limited to.
+const (
+    LanguageEnglish = "en"
+    LanguageFrench = "fr"
+)
+
+// The size variants of the port tests. Each port test is in the tests configuration file twice with
+// the same image, once as a small port test and once as a large port test.
+const (
+    SizeSmall = "small"
+    SizeLarge = "large"
+)
+
+// Selectors that can be given in place of test names to pick several tests at once.
+const (
+    categorySelectorPrefix = "category:" // category:<category> picks all tests in a category
+    allPortsSelector = "all-ports" // picks both the small and large port tests
+)
+
+// All the categories, in the order they are listed to the user.
+var Categories = []string{CategoryVideo, CategoryMusic, CategoryConferencing, CategorySmallPort, CategoryLargePort}
+
+// Checks if a test is a port test.
+// Returns true if the test is a small or large port test; false otherwise
+func (t Test) IsPortTest() bool {
+    return t.Category == CategorySmallPort || t.Category == CategoryLargePort
+}
+
+// Gets the size variant of a port test.
+// Returns SizeSmall or SizeLarge for port tests, or an empty string for all other tests
+func (t Test) SizeLabel() string {
+    switch t.Category {
+    case CategorySmallPort:
+        return SizeSmall
+    case CategoryLargePort:
+        return SizeLarge
+    default:
+        return ""
+    }
+}
+
+// Gets the name used to pick the test on the command line. This is the image of the test, with the
+// size variant appended for port tests (ex. port80-small) since both variants share an image.
+// Returns the unique name of the test
+func (t Test) Selector() string {
+    sizeLabel := t.SizeLabel()
+    if sizeLabel == "" {
+        return t.Image
+    }
+    return t.Image + "-" + sizeLabel
+}
+
+// Gets the name of the test that is shown to the user. Port tests include the size variant.
+// Returns the pretty name of the test
+func (t Test) DisplayName() string {
+    sizeLabel := t.SizeLabel()
+    if sizeLabel == "" {
+        return t.Name
+    }
+    return fmt.Sprintf("%s (%s)", t.Name, sizeLabel)
+}
+
+// Checks if a test is shown to users of a language.
+// language: LanguageEnglish or LanguageFrench
+// Returns true if the test is not limited to another language; false otherwise
+func (t Test) AvailableIn(language string) bool {
+    switch language {
+    case LanguageEnglish:
+        return !t.FrenchOnly
+    case LanguageFrench:
+        return !t.EnglishOnly
+    default:
+        return true
+    }
+}
+
+// Gets the languages that a test is limited to.
+// Returns the language the test is limited to, or an empty string if the test is shown to everyone
+func (t Test) Language() string {
+    if t.EnglishOnly {
+        return LanguageEnglish
+    }
+    if t.FrenchOnly {
+        return LanguageFrench
+    }
+    return ""
+}
+
+// Picks the tests in a category that are shown to users of a language.
+// allTests: all the tests in the tests configuration file
+// category: the category of tests to pick, case insensitive; empty to pick all categories
+// language: the language of the user, either LanguageEnglish or LanguageFrench; empty to pick all
+//           languages
+// Returns the tests that match or an error if the category or language is not valid
+func FilterTests(allTests []Test, category string, language string) ([]Test, error) {
+    category = strings.ToUpper(category)
+    if category != "" && !containsString(Categories, category) {
+        return nil, fmt.Errorf("%s is not a category. Choose from %s.", category, strings.Join(Categories, ", "))
+    }
+    language = strings.ToLower(language)
+    if language != "" && language != LanguageEnglish && language != LanguageFrench {
+        return nil, fmt.Errorf("%s is not a language. Choose from %s or %s.", language, LanguageEnglish, LanguageFrench)
+    }
+
+    var tests []Test
+    for _, test := range allTests {
+        if category != "" && test.Category != category {
+            continue
+        }
+        if !test.AvailableIn(language) {
+            continue
+        }
+        tests = append(tests, test)
+    }
+    return tests, nil
+}
+
+// Checks if a test is picked by a name given by the user. The name can be the selector of the test,
+// category:<category> to pick all tests in a category, or all-ports to pick all the port tests.
+// test: the test to check
+// selector: the name given by the user
+// Returns true if the test is picked by the selector; false otherwise
+func matchesSelector(test Test, selector string) bool {
+    if selector == allPortsSelector {
+        return test.IsPortTest()
+    }
+    if strings.HasPrefix(selector, categorySelectorPrefix) {
+        return strings.EqualFold(test.Category, strings.TrimPrefix(selector, categorySelectorPrefix))
+    }
+    return test.Selector() == selector
+}
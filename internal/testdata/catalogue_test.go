@@ -0,0 +1,134 @@
+package testdata
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+var catalogue = []Test{
+    {Name: "YouTube", Image: "youtube", Category: CategoryVideo},
+    {Name: "Hulu", Image: "hulu", Category: CategoryVideo, EnglishOnly: true},
+    {Name: "Deezer", Image: "deezer", Category: CategoryMusic, FrenchOnly: true},
+    {Name: "80 HTTP", Image: "port80", Category: CategorySmallPort},
+    {Name: "80 HTTP", Image: "port80", Category: CategoryLargePort},
+}
+
+func writeCatalogue(t *testing.T) string {
+    data, err := json.Marshal(catalogue)
+    if err != nil {
+        t.Fatal(err)
+    }
+    testsConfigFile := filepath.Join(t.TempDir(), "tests_list.json")
+    err = os.WriteFile(testsConfigFile, data, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return testsConfigFile
+}
+
+func getImages(tests []*Test) []string {
+    var images []string
+    for _, test := range tests {
+        images = append(images, test.Image + "/" + test.Category)
+    }
+    return images
+}
+
+func TestParseTestJSONSelectors(t *testing.T) {
+    testsConfigFile := writeCatalogue(t)
+
+    // Test category selector, and that a test picked twice only runs once
+    tests, err := ParseTestJSON(testsConfigFile, []string{"category:video", "youtube"}, nil)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    images := getImages(tests)
+    if len(images) != 2 || images[0] != "youtube/VIDEO" || images[1] != "hulu/VIDEO" {
+        t.Errorf("Expected [youtube/VIDEO hulu/VIDEO], got %v", images)
+    }
+
+    // Test all-ports selector
+    tests, err = ParseTestJSON(testsConfigFile, []string{"all-ports"}, nil)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    images = getImages(tests)
+    if len(images) != 2 || images[0] != "port80/SMALL_PORT" || images[1] != "port80/LARGE_PORT" {
+        t.Errorf("Expected [port80/SMALL_PORT port80/LARGE_PORT], got %v", images)
+    }
+
+    // Test size variant of a port test
+    tests, err = ParseTestJSON(testsConfigFile, []string{"port80-large"}, nil)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    images = getImages(tests)
+    if len(images) != 1 || images[0] != "port80/LARGE_PORT" {
+        t.Errorf("Expected [port80/LARGE_PORT], got %v", images)
+    }
+
+    // Test image shared by the small and large port tests
+    _, err = ParseTestJSON(testsConfigFile, []string{"port80"}, nil)
+    if err == nil {
+        t.Error("Expected error for ambiguous test name, but got none.")
+    } else {
+        expectedError := "port80 is ambiguous. Choose from port80-small, port80-large."
+        if err.Error() != expectedError {
+            t.Errorf("Expected error '%s', got '%v'", expectedError, err)
+        }
+    }
+
+    // Test selector that matches nothing
+    _, err = ParseTestJSON(testsConfigFile, []string{"youtube", "category:games"}, nil)
+    if err == nil {
+        t.Error("Expected error for invalid selector, but got none.")
+    }
+}
+
+func TestCheckValidTestNamesDuplicates(t *testing.T) {
+    duplicates := append([]Test{}, catalogue...)
+    duplicates = append(duplicates, Test{Name: "YouTube", Image: "youtube", Category: CategoryVideo})
+
+    err := checkValidTestNames([]string{"hulu"}, duplicates, nil)
+    if err == nil {
+        t.Error("Expected error for duplicate tests, but got none.")
+    } else {
+        expectedError := "More than one test in the tests configuration file is named youtube."
+        if err.Error() != expectedError {
+            t.Errorf("Expected error '%s', got '%v'", expectedError, err)
+        }
+    }
+}
+
+func TestFilterTests(t *testing.T) {
+    // Test language filter
+    tests, err := FilterTests(catalogue, "", "fr")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(tests) != 4 {
+        t.Errorf("Expected 4, got %d", len(tests))
+    }
+    for _, test := range tests {
+        if test.EnglishOnly {
+            t.Errorf("Expected no English only tests, got %s", test.Image)
+        }
+    }
+
+    // Test category and language filter
+    tests, err = FilterTests(catalogue, "video", "en")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(tests) != 2 {
+        t.Errorf("Expected 2, got %d", len(tests))
+    }
+
+    // Test invalid category
+    _, err = FilterTests(catalogue, "games", "")
+    if err == nil {
+        t.Error("Expected error for invalid category, but got none.")
+    }
+}
@@ -0,0 +1,348 @@
+// Loads and validates replay files.
+package testdata
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+const (
+    ReplayFileVersion = 1 // the newest version of the object format of replay files that this client can load
+
+    ProtocolTCP = "tcp"
+    ProtocolUDP = "udp"
+)
+
+// The contents of a replay file. Replay files are written either in the legacy layout, a JSON array
+// of the packets, the UDP client ports, the TCP CS pairs, and the replay name, or in the object
+// format, a JSON object of this struct with a version. Legacy files are loaded with version 0.
+type ReplayFile struct {
+    Version int `json:"version"` // version of the object format; 0 if the file has the legacy layout
+    ReplayName string `json:"replayName"` // name of the replay on the server
+    Protocol string `json:"protocol"` // either ProtocolTCP or ProtocolUDP
+    UDPClientPorts []string `json:"udpClientPorts,omitempty"` // the client ports of the flows of a UDP replay
+    TCPCSPairs []string `json:"tcpCSPairs,omitempty"` // the client & server of the connection of a TCP replay
+    Packets []ReplayFilePacket `json:"packets"` // the packets that the client sends
+}
+
+// The structure that the packets of replay files get unpacked into.
+type ReplayFilePacket struct {
+    CSPair string `json:"c_s_pair"` // the client & server of original packet capture, in the form {client_IP}.{client_port}-{server_IP}.{server_port}
+    Timestamp float64 `json:"timestamp"` // time since the start of the replay that this packet should be sent
+    Payload string `json:"payload"` // the bytes to send to the server
+    ResponseLength *int `json:"response_len,omitempty"` // the expected length of response to this packet, TCP only field
+    ResponseHash *string `json:"response_hash,omitempty"` // the expected hash of the response, TCP only field
+    End *bool `json:"end,omitempty"` // true if this is the last packet of its flow, UDP only field
+}
+
+// The legacy layout of replay files: a JSON array of four elements.
+type legacyReplayFile struct {
+    Packets []ReplayFilePacket // the packets that the client sends
+    UDPClientPorts []string // the client ports of the flows of a UDP replay
+    TCPCSPairs []string // the client & server of the connection of a TCP replay
+    ReplayName string // name of the replay on the server
+}
+
+// Decodes the four elements of a legacy replay file.
+// data: the contents of the replay file
+// Returns any errors
+func (f *legacyReplayFile) UnmarshalJSON(data []byte) error {
+    var elements []json.RawMessage
+    err := json.Unmarshal(data, &elements)
+    if err != nil {
+        return err
+    }
+    if len(elements) != 4 {
+        return fmt.Errorf("Legacy replay files should have 4 elements (packets, UDP client ports, TCP CS pairs, and replay name); found %d.", len(elements))
+    }
+
+    fields := []interface{}{&f.Packets, &f.UDPClientPorts, &f.TCPCSPairs, &f.ReplayName}
+    fieldNames := []string{"packets", "UDP client ports", "TCP CS pairs", "replay name"}
+    for i, field := range fields {
+        err = json.Unmarshal(elements[i], field)
+        if err != nil {
+            return fmt.Errorf("Unable to decode the %s (element %d): %v", fieldNames[i], i, err)
+        }
+    }
+    return nil
+}
+
+// Decodes a replay file in either the legacy layout or the object format. The contents are not
+// validated; see ReplayInfo.
+// data: the contents of the replay file
+// Returns the replay file, or any errors
+func DecodeReplayFile(data []byte) (ReplayFile, error) {
+    data = bytes.TrimSpace(data)
+    if len(data) == 0 {
+        return ReplayFile{}, fmt.Errorf("Replay file is empty.")
+    }
+
+    switch data[0] {
+    case '[':
+        var legacy legacyReplayFile
+        err := json.Unmarshal(data, &legacy)
+        if err != nil {
+            return ReplayFile{}, err
+        }
+        // legacy files do not say which protocol they use, but only TCP packets have response lengths
+        protocol := ProtocolUDP
+        if len(legacy.Packets) > 0 && legacy.Packets[0].ResponseLength != nil {
+            protocol = ProtocolTCP
+        }
+        return ReplayFile{
+            Version: 0,
+            ReplayName: legacy.ReplayName,
+            Protocol: protocol,
+            UDPClientPorts: legacy.UDPClientPorts,
+            TCPCSPairs: legacy.TCPCSPairs,
+            Packets: legacy.Packets,
+        }, nil
+    case '{':
+        var replayFile ReplayFile
+        err := json.Unmarshal(data, &replayFile)
+        if err != nil {
+            return ReplayFile{}, err
+        }
+        if replayFile.Version < 1 {
+            return ReplayFile{}, fmt.Errorf("Replay file has no version.")
+        }
+        if replayFile.Version > ReplayFileVersion {
+            return ReplayFile{}, fmt.Errorf("Replay file version %d is newer than this client supports (%d). Update the client.", replayFile.Version, ReplayFileVersion)
+        }
+        return replayFile, nil
+    default:
+        return ReplayFile{}, fmt.Errorf("Replay file should be a JSON array or object.")
+    }
+}
+
+// Encodes a replay file in the newest version of the object format.
+// Returns the contents of the replay file, or any errors
+func (f ReplayFile) Encode() ([]byte, error) {
+    f.Version = ReplayFileVersion
+    return json.Marshal(f)
+}
+
+// Loads a replay file from disk. The contents are not validated; see ReplayInfo.
+// replayFile: file path to the replay file
+// Returns the replay file, or any errors
+func LoadReplayFile(replayFile string) (ReplayFile, error) {
+    data, err := os.ReadFile(replayFile)
+    if err != nil {
+        return ReplayFile{}, err
+    }
+    f, err := DecodeReplayFile(data)
+    if err != nil {
+        return ReplayFile{}, fmt.Errorf("%s: %v", replayFile, err)
+    }
+    return f, nil
+}
+
+// Parses a replay file.
+// replayFile: file path to the replay file
+// Returns the information needed to run the replay, or an error saying which file and packet are
+//     invalid
+func ParseReplayJSON(replayFile string) (ReplayInfo, error) {
+    f, err := LoadReplayFile(replayFile)
+    if err != nil {
+        return ReplayInfo{}, err
+    }
+    replayInfo, err := f.ReplayInfo()
+    if err != nil {
+        return ReplayInfo{}, fmt.Errorf("%s: %v", replayFile, err)
+    }
+    return replayInfo, nil
+}
+
+// Validates the replay file and converts it into the information needed to run the replay. Each
+// packet must have a valid CS pair and hex payload, and timestamps must not go backwards.
+// Returns the information needed to run the replay, or an error saying which packet is invalid
+func (f ReplayFile) ReplayInfo() (ReplayInfo, error) {
+    if f.ReplayName == "" {
+        return ReplayInfo{}, fmt.Errorf("Replay file has no replay name.")
+    }
+    if f.Protocol != ProtocolTCP && f.Protocol != ProtocolUDP {
+        return ReplayInfo{}, fmt.Errorf("Protocol '%s' is invalid. Should be %s or %s.", f.Protocol, ProtocolTCP, ProtocolUDP)
+    }
+    if len(f.Packets) == 0 {
+        return ReplayInfo{}, fmt.Errorf("Replay file has no packets.")
+    }
+
+    isTCP := f.Protocol == ProtocolTCP
+    var packets []Packet
+    for i, replayFilePacket := range f.Packets {
+        packet, err := replayFilePacket.toPacket(isTCP)
+        if err != nil {
+            return ReplayInfo{}, fmt.Errorf("packet %d: %v", i, err)
+        }
+        if i > 0 && replayFilePacket.Timestamp < f.Packets[i - 1].Timestamp {
+            return ReplayInfo{}, fmt.Errorf("packet %d: Timestamp %f is before the timestamp of the previous packet (%f).", i, replayFilePacket.Timestamp, f.Packets[i - 1].Timestamp)
+        }
+        packets = append(packets, packet)
+    }
+
+    var csPair CSPair
+    var udpFlows []UDPFlow
+    var err error
+    if isTCP {
+        for i, tcpCSPair := range f.TCPCSPairs {
+            _, err = newCSPair(tcpCSPair)
+            if err != nil {
+                return ReplayInfo{}, fmt.Errorf("TCP CS pair %d: %v", i, err)
+            }
+        }
+        // we currently only have 1 cs pair; files without one use the CS pair of the first packet
+        firstCSPair := f.Packets[0].CSPair
+        if len(f.TCPCSPairs) > 0 {
+            firstCSPair = f.TCPCSPairs[0]
+        }
+        csPair, err = newCSPair(firstCSPair)
+        if err != nil {
+            return ReplayInfo{}, err
+        }
+    } else {
+        udpFlows, err = splitUDPFlows(packets, f.UDPClientPorts)
+        if err != nil {
+            return ReplayInfo{}, err
+        }
+        csPair = udpFlows[0].CSPair
+    }
+
+    return ReplayInfo{
+        Packets: packets,
+        CSPair: csPair,
+        ReplayName: f.ReplayName,
+        IsTCP: isTCP,
+        IsPortTest: strings.HasPrefix(f.ReplayName, "port"),
+        UDPFlows: udpFlows,
+    }, nil
+}
+
+// Validates a packet of a replay file and converts it into a packet to send to the server.
+// isTCP: true if the packet is from a TCP replay; false if it is from a UDP replay
+// Returns a TCPPacket or UDPPacket, or any errors
+func (p ReplayFilePacket) toPacket(isTCP bool) (Packet, error) {
+    _, err := newCSPair(p.CSPair)
+    if err != nil {
+        return nil, err
+    }
+    if p.Timestamp < 0 {
+        return nil, fmt.Errorf("Timestamp %f is negative.", p.Timestamp)
+    }
+
+    if isTCP {
+        if p.ResponseLength == nil {
+            return nil, fmt.Errorf("TCP packet has no response_len.")
+        }
+        if *p.ResponseLength < 0 {
+            return nil, fmt.Errorf("Response length %d is negative.", *p.ResponseLength)
+        }
+        //TODO: see if test files can replace null with "" in response_hash field; if so, this code is not needed
+        var hash string
+        if p.ResponseHash != nil {
+            hash = *p.ResponseHash
+        }
+        tcpPacket, err := newTCPPacket(p.CSPair, p.Timestamp, p.Payload, *p.ResponseLength, hash)
+        if err != nil {
+            return nil, fmt.Errorf("Payload is not valid hex: %v", err)
+        }
+        return &tcpPacket, nil
+    }
+
+    // packets without an end flag do not end their flow
+    end := p.End != nil && *p.End
+    udpPacket, err := newUDPPacket(p.CSPair, p.Timestamp, p.Payload, end)
+    if err != nil {
+        return nil, fmt.Errorf("Payload is not valid hex: %v", err)
+    }
+    return &udpPacket, nil
+}
+
+// Splits the packets of a UDP replay into flows by their CSPair. Flows are ordered by the client
+// ports listed in the replay file, followed by any flows whose client port is not listed, in the
+// order that their first packet appears.
+// packets: the UDP packets of the replay
+// clientPorts: the client ports listed in the replay file
+// Returns the flows, or any errors
+func splitUDPFlows(packets []Packet, clientPorts []string) ([]UDPFlow, error) {
+    var flows []UDPFlow
+    flowIndexes := make(map[string]int) // maps the CSPair string of a flow to its index in flows
+    for _, p := range packets {
+        packet := p.(*UDPPacket)
+        i, ok := flowIndexes[packet.CSPair]
+        if !ok {
+            csPair, err := newCSPair(packet.CSPair)
+            if err != nil {
+                return nil, err
+            }
+            i = len(flows)
+            flowIndexes[packet.CSPair] = i
+            flows = append(flows, UDPFlow{CSPair: csPair})
+        }
+        flows[i].Packets = append(flows[i].Packets, packet)
+    }
+
+    portOrder := make(map[int]int)
+    for i, clientPort := range clientPorts {
+        port, err := parsePort(clientPort)
+        if err != nil {
+            return nil, fmt.Errorf("UDP client port %d: %v", i, err)
+        }
+        if _, ok := portOrder[port]; !ok {
+            portOrder[port] = i
+        }
+    }
+    sort.SliceStable(flows, func(i, j int) bool {
+        orderI, listedI := portOrder[flows[i].CSPair.ClientPort]
+        orderJ, listedJ := portOrder[flows[j].CSPair.ClientPort]
+        if listedI && listedJ {
+            return orderI < orderJ
+        }
+        return listedI && !listedJ
+    })
+    return flows, nil
+}
+
+// The result of validating a replay file.
+type ReplayFileCheck struct {
+    File string // name of the replay file in the replays directory
+    ReplayName string // name of the replay; empty if the file could not be loaded
+    Version int // version of the object format; 0 if the file has the legacy layout
+    Err error // why the replay file is invalid; nil if it is valid
+}
+
+// Validates every replay file in a directory. Replay files are the .json files in the directory;
+// hidden files, such as the temporary files of an update, are skipped.
+// replaysDir: path to the directory containing the replay files
+// Returns the result of each replay file in name order, or an error if the directory cannot be read
+func ValidateReplays(replaysDir string) ([]ReplayFileCheck, error) {
+    entries, err := os.ReadDir(replaysDir)
+    if err != nil {
+        return nil, err
+    }
+
+    var checks []ReplayFileCheck
+    for _, entry := range entries {
+        if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != ".json" {
+            continue
+        }
+        check := ReplayFileCheck{File: entry.Name()}
+        var f ReplayFile
+        data, err := os.ReadFile(filepath.Join(replaysDir, entry.Name()))
+        if err == nil {
+            f, err = DecodeReplayFile(data)
+        }
+        if err == nil {
+            check.ReplayName = f.ReplayName
+            check.Version = f.Version
+            _, err = f.ReplayInfo()
+        }
+        check.Err = err
+        checks = append(checks, check)
+    }
+    return checks, nil
+}
@@ -0,0 +1,131 @@
+package testdata
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+const (
+    testTCPCSPair = "010.000.000.001.50000-010.000.000.002.00080"
+    testUDPCSPair = "192.168.000.018.50621-170.133.130.181.09000"
+)
+
+func writeReplayFile(t *testing.T, dir string, filename string, replay interface{}) string {
+    data, err := json.Marshal(replay)
+    if err != nil {
+        t.Fatal(err)
+    }
+    replayFile := filepath.Join(dir, filename)
+    err = os.WriteFile(replayFile, data, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return replayFile
+}
+
+func tcpPacket(timestamp float64, payload string) map[string]interface{} {
+    return map[string]interface{}{"c_s_pair": testTCPCSPair, "timestamp": timestamp, "payload": payload, "response_len": 10, "response_hash": nil}
+}
+
+func TestParseReplayJSONLegacy(t *testing.T) {
+    dir := t.TempDir()
+
+    // Test TCP replay
+    packets := []interface{}{tcpPacket(0, "abcd"), tcpPacket(0.5, "")}
+    replayFile := writeReplayFile(t, dir, "tcp.json", []interface{}{packets, []string{}, []string{testTCPCSPair}, "Video-01012024"})
+    replayInfo, err := ParseReplayJSON(replayFile)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if !replayInfo.IsTCP || replayInfo.ReplayName != "Video-01012024" || len(replayInfo.Packets) != 2 || replayInfo.CSPair.ServerPort != 80 {
+        t.Errorf("Unexpected replay info: %v", replayInfo)
+    }
+
+    // Test UDP replay without end flags
+    packets = []interface{}{map[string]interface{}{"c_s_pair": testUDPCSPair, "timestamp": 0, "payload": "00"}}
+    replayFile = writeReplayFile(t, dir, "udp.json", []interface{}{packets, []string{"50621"}, []string{}, "Webex-04282020"})
+    replayInfo, err = ParseReplayJSON(replayFile)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if replayInfo.IsTCP || len(replayInfo.UDPFlows) != 1 || replayInfo.CSPair.ClientIP != "192.168.000.018" || replayInfo.Packets[0].(*UDPPacket).End {
+        t.Errorf("Unexpected replay info: %v", replayInfo)
+    }
+}
+
+func TestParseReplayJSONObject(t *testing.T) {
+    dir := t.TempDir()
+    end := true
+    replay := ReplayFile{
+        ReplayName: "Webex-04282020",
+        Protocol: ProtocolUDP,
+        UDPClientPorts: []string{"50621"},
+        Packets: []ReplayFilePacket{{CSPair: testUDPCSPair, Timestamp: 0.1, Payload: "ff", End: &end}},
+    }
+    data, err := replay.Encode()
+    if err != nil {
+        t.Fatal(err)
+    }
+    replayFile := filepath.Join(dir, "udp.json")
+    err = os.WriteFile(replayFile, data, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    replayInfo, err := ParseReplayJSON(replayFile)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if replayInfo.IsTCP || replayInfo.ReplayName != "Webex-04282020" || !replayInfo.Packets[0].(*UDPPacket).End {
+        t.Errorf("Unexpected replay info: %v", replayInfo)
+    }
+
+    // Test newer version
+    replayFile = writeReplayFile(t, dir, "new.json", map[string]interface{}{"version": ReplayFileVersion + 1})
+    _, err = ParseReplayJSON(replayFile)
+    if err == nil || !strings.Contains(err.Error(), "newer") {
+        t.Errorf("Expected version error, got %v", err)
+    }
+}
+
+func TestParseReplayJSONInvalid(t *testing.T) {
+    dir := t.TempDir()
+    tests := []struct {
+        name string
+        replay interface{}
+        expected string
+    }{
+        {"empty packets", []interface{}{[]interface{}{}, []string{}, []string{}, "Video"}, "no packets"},
+        {"missing elements", []interface{}{[]interface{}{tcpPacket(0, "")}, []string{}}, "4 elements"},
+        {"bad hex", []interface{}{[]interface{}{tcpPacket(0, ""), tcpPacket(1, "zz")}, []string{}, []string{}, "Video"}, "packet 1: Payload is not valid hex"},
+        {"timestamps", []interface{}{[]interface{}{tcpPacket(1, ""), tcpPacket(0.5, "")}, []string{}, []string{}, "Video"}, "packet 1: Timestamp"},
+        {"bad CS pair", []interface{}{[]interface{}{map[string]interface{}{"c_s_pair": "10.0.0.1-10.0.0.2.80", "timestamp": 0, "payload": "", "response_len": 0}}, []string{}, []string{}, "Video"}, "packet 0: Client of CSPair"},
+        {"bad port", []interface{}{[]interface{}{tcpPacket(0, "")}, []string{}, []string{"10.0.0.1.70000-10.0.0.2.80"}, "Video"}, "TCP CS pair 0"},
+        {"no version", map[string]interface{}{"replayName": "Video"}, "no version"},
+    }
+    for _, test := range tests {
+        replayFile := writeReplayFile(t, dir, "replay.json", test.replay)
+        _, err := ParseReplayJSON(replayFile)
+        if err == nil || !strings.Contains(err.Error(), test.expected) || !strings.Contains(err.Error(), replayFile) {
+            t.Errorf("%s: expected error containing %q and the file name, got %v", test.name, test.expected, err)
+        }
+    }
+}
+
+func TestValidateReplays(t *testing.T) {
+    dir := t.TempDir()
+    writeReplayFile(t, dir, "a.json", []interface{}{[]interface{}{tcpPacket(0, "")}, []string{}, []string{}, "Video"})
+    writeReplayFile(t, dir, "b.json", []interface{}{[]interface{}{tcpPacket(0, "z")}, []string{}, []string{}, "Video"})
+    writeReplayFile(t, dir, ".c.json-update-1", []interface{}{})
+
+    checks, err := ValidateReplays(dir)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(checks) != 2 || checks[0].File != "a.json" || checks[0].Err != nil || checks[1].File != "b.json" || checks[1].Err == nil {
+        t.Errorf("Unexpected checks: %v", checks)
+    }
+}
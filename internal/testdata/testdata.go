@@ -5,24 +5,30 @@ import (
     "encoding/hex"
     "encoding/json"
     "fmt"
+    "net"
     "os"
     "strconv"
     "strings"
     "time"
+
+    "wehe-cmdline-client/internal/logging"
 )
 
 // All the information we need to run a test.
 type Test struct {
     Name string `json:"name"` // pretty name of the test (name that is displayed to the user in the app)
+    Size int `json:"size"` // estimated number of MB sent by both replays
     Time int `json:"time"` // number seconds needed to run both replays; port time not accurate - we want to run them as fast as possible
     Image string `json:"image"` // filename of test icon (used for entering test names on command line bc this name has no spaces)
     DataFile string `json:"datafile"` // filename of the original replay
     RandomDataFile string `json:"randomdatafile"` // filename of the random replay
+    Category string `json:"category"` // type of app the test replays; one of the Category constants
+    EnglishOnly bool `json:"englishOnly,omitempty"` // true if the app is only shown to users in English-speaking locales
+    FrenchOnly bool `json:"frenchOnly,omitempty"` // true if the app is only shown to users in French-speaking locales
+    Imported bool `json:"imported,omitempty"` // true if the test was made from a capture by the import command; kept when the tests are updated
 
     //IsTCP bool // true if test sends TCP packets; false if it sends UDP packets
-    OriginalThroughput float64 // average throughput of the original replay
-    RandomThroughput float64 // average throughput of random replay
-    TestID int // the ID for the replay for this specific user
+    TestID int `json:"-"` // the ID for the replay for this specific user
 }
 
 type ReplayInfo struct {
@@ -31,6 +37,14 @@ type ReplayInfo struct {
     ReplayName string
     IsTCP bool
     IsPortTest bool
+    UDPFlows []UDPFlow // the packets of a UDP replay split by flow; nil for TCP replays
+}
+
+// The packets of a UDP replay that were recorded between one client port and the server. Each flow
+// is replayed over its own socket.
+type UDPFlow struct {
+    CSPair CSPair // the client & server of the flow in the original packet capture
+    Packets []Packet // the packets of the flow, in the order that they are sent
 }
 
 // Either a TCPPacket or UDPPacket.
@@ -66,7 +80,7 @@ type UDPPacket struct {
     CSPair string // the client & server of original packet capture, in the form {client_IP}.{client_port}-{server_IP}.{server_port}
     Timestamp time.Duration // time since the start of the replay that this packet should be sent
     Payload []byte // the bytes to send to the server
-    End bool // ???
+    End bool // true if this is the last packet that the client sends on its flow
 }
 
 func newUDPPacket(csPair string, timestamp float64, payload string, end bool) (UDPPacket, error) {
@@ -82,16 +96,6 @@ func newUDPPacket(csPair string, timestamp float64, payload string, end bool) (U
     }, nil
 }
 
-// The structure that replay files get unpacked into.
-type ReplayFilePacket struct {
-    CSPair string `json:"c_s_pair"` // the client & server of original packet capture, in the form {client_IP}.{client_port}-{server_IP}.{server_port}
-    Timestamp float64 `json:"timestamp"` // time since the start of the replay that this packet should be sent
-    Payload string `json:"payload"` // the bytes to send to the server
-    ResponseLength *int `json:"response_len"` // the expected length of response to this packet, TCP only field
-    ResponseHash *string `json:"response_hash"` // the expected hash of the response, TCP only field
-    End *bool `json:"end"` // ???, UDP only field
-}
-
 // Represents a client-server pair. Every replay file recorded has a CSPair.
 type CSPair struct {
     ClientIP string // client IP of the replay file
@@ -109,18 +113,13 @@ func newCSPair(csPair string) (CSPair, error) {
         return CSPair{}, fmt.Errorf("CSPair '%s' is invalid. Should be in format <client_ip>.<client_port>-<server_ip>.<server_port>", csPair)
     }
 
-    lastDotIndex := strings.LastIndex(clientServer[0], ".")
-	clientIP := clientServer[0][:lastDotIndex]
-    clientPort, err := strconv.Atoi(clientServer[0][lastDotIndex + 1:])
-	if err != nil {
-        return CSPair{}, err
+    clientIP, clientPort, err := splitCSPairAddress(clientServer[0])
+    if err != nil {
+        return CSPair{}, fmt.Errorf("Client of CSPair '%s' is invalid: %v", csPair, err)
     }
-
-    lastDotIndex = strings.LastIndex(clientServer[1], ".")
-    serverIP := clientServer[1][:lastDotIndex]
-    serverPort, err := strconv.Atoi(clientServer[1][lastDotIndex + 1:])
+    serverIP, serverPort, err := splitCSPairAddress(clientServer[1])
     if err != nil {
-        return CSPair{}, err
+        return CSPair{}, fmt.Errorf("Server of CSPair '%s' is invalid: %v", csPair, err)
     }
 
     return CSPair{
@@ -131,132 +130,124 @@ func newCSPair(csPair string) (CSPair, error) {
     }, nil
 }
 
+// Splits one side of a CSPair into its IP and port.
+// address: an address in the format <ip>.<port>; IPv4 octets may be zero-padded, ex. 010.000.000.001.00080
+// Returns the IP and port, or any errors
+func splitCSPairAddress(address string) (string, int, error) {
+    lastDotIndex := strings.LastIndex(address, ".")
+    if lastDotIndex < 0 {
+        return "", 0, fmt.Errorf("'%s' should be in format <ip>.<port>", address)
+    }
+    ip := address[:lastDotIndex]
+    port, err := parsePort(address[lastDotIndex + 1:])
+    if err != nil {
+        return "", 0, err
+    }
+    if !isCSPairIP(ip) {
+        return "", 0, fmt.Errorf("'%s' is not an IP address", ip)
+    }
+    return ip, port, nil
+}
+
+// Checks if a string is an IP address as written in CSPairs. IPv4 octets may be zero-padded.
+// ip: the string to check
+// Returns true if ip is an IPv4 or IPv6 address; false otherwise
+func isCSPairIP(ip string) bool {
+    if net.ParseIP(ip) != nil {
+        return true
+    }
+    octets := strings.Split(ip, ".")
+    if len(octets) != 4 {
+        return false
+    }
+    for _, octet := range octets {
+        if len(octet) == 0 || len(octet) > 3 {
+            return false
+        }
+        value, err := strconv.Atoi(octet)
+        if err != nil || value < 0 || value > 255 || strings.HasPrefix(octet, "+") {
+            return false
+        }
+    }
+    return true
+}
+
+// Parses a port number.
+// port: the port number as a string
+// Returns the port number, or an error if it is not between 0 and 65535
+func parsePort(port string) (int, error) {
+    portNumber, err := strconv.Atoi(port)
+    if err != nil || portNumber < 0 || portNumber > 65535 || strings.HasPrefix(port, "+") {
+        return 0, fmt.Errorf("'%s' is not a port number", port)
+    }
+    return portNumber, nil
+}
+
 // Loads the tests from disk.
 // testsConfigFile: the configuration file name containing information about all the tests
 // testNames: the names of the tests that the user would like to run. Test names should match
-//            Test.Image
+//            Test.Selector() or be a selector that picks several tests (see matchesSelector)
+// logger: logger to write messages to
 // Returns a list of tests or an error
-func ParseTestJSON(testsConfigFile string, testNames []string) ([]*Test, error) {
-    data, err := os.ReadFile(testsConfigFile)
+func ParseTestJSON(testsConfigFile string, testNames []string, logger *logging.Logger) ([]*Test, error) {
+    allTests, err := LoadTests(testsConfigFile)
     if err != nil {
         return nil, err
     }
 
-    var allTests []Test
-    err = json.Unmarshal(data, &allTests)
+    // make sure there aren't any invalid test names that user entered
+    err = checkValidTestNames(testNames, allTests, logger)
     if err != nil {
         return nil, err
     }
 
+    // tests are run in the order they appear in the tests configuration file, and a test picked by
+    // more than one name is only run once
     var userRequestedTests []*Test
-    var validTestNames []string
     for _, test := range allTests {
-        if (containsString(testNames, test.Image)) {
-            tmpTest := test
-            userRequestedTests = append(userRequestedTests, &tmpTest)
-            validTestNames = append(validTestNames, test.Image)
+        for _, testName := range testNames {
+            if matchesSelector(test, testName) {
+                tmpTest := test
+                userRequestedTests = append(userRequestedTests, &tmpTest)
+                break
+            }
         }
     }
 
-    // make sure there aren't any invalid test names that user entered
-    err = checkValidTestNames(testNames, validTestNames)
-    if err != nil {
-        return nil, err
-    }
+    return userRequestedTests, nil
+}
 
-    return userRequestedTests, err
+// Loads all the tests in the tests configuration file.
+// testsConfigFile: the configuration file name containing information about all the tests
+// Returns a list of all the tests or an error
+func LoadTests(testsConfigFile string) ([]Test, error) {
+    allTests, _, err := LoadTestEntries(testsConfigFile)
+    return allTests, err
 }
 
-// TODO: refactor so that this returns a struct that contains []packet, isTCP, and replay name
-// Parses a test file.
-// replayFile: file path to the test file
-// Returns a list of packets to send to the server that make up the test and true if packets are
-//     TCP, false if packets are UDP, or an error
-func ParseReplayJSON(replayFile string) (ReplayInfo, error) {
-    data, err := os.ReadFile(replayFile)
+// Loads all the tests in the tests configuration file along with the JSON entry of each test. A
+// tests list that is written back from the entries keeps any fields that are not part of Test.
+// testsConfigFile: the configuration file name containing information about all the tests
+// Returns a list of all the tests, the entry of each test in the same order, or an error
+func LoadTestEntries(testsConfigFile string) ([]Test, []json.RawMessage, error) {
+    data, err := os.ReadFile(testsConfigFile)
     if err != nil {
-        return ReplayInfo{}, err
+        return nil, nil, err
     }
 
-    var jsonData []json.RawMessage
-    err = json.Unmarshal(data, &jsonData)
+    var entries []json.RawMessage
+    err = json.Unmarshal(data, &entries)
     if err != nil {
-        return ReplayInfo{}, err
-    }
-
-    //TODO: can we get rid of udp client ports, tcp csps, and replay name in tests files and just keep the Q - would make json parsing a lot simplier; can get rid of block below
-    // fan and i think we can get rid of udp client ports and csps - double check that, and see if replay name needed
-    var replayFilePackets []ReplayFilePacket
-    if len(jsonData) > 0 {
-		err := json.Unmarshal(jsonData[0], &replayFilePackets)
-		if err != nil {
-			return ReplayInfo{}, err
-		}
-	}
-
-    var packets []Packet
-    var isTCP bool
-    if replayFilePackets[0].ResponseLength != nil {
-        // make the TCP packets to be sent to the server
-        isTCP = true
-        for _, replayFilePacket := range replayFilePackets {
-            //TODO: see if test files can replace null with "" in response_hash field; if so, this code is not needed
-            var hash string
-            if replayFilePacket.ResponseHash == nil {
-                hash = ""
-            } else {
-                hash = *replayFilePacket.ResponseHash
-            }
-
-            tcpPacket, err := newTCPPacket(replayFilePacket.CSPair, replayFilePacket.Timestamp, replayFilePacket.Payload, *replayFilePacket.ResponseLength, hash)
-            if err != nil {
-                return ReplayInfo{}, err
-            }
-
-            packets = append(packets, &tcpPacket)
-        }
-    } else {
-        // make the UDP packets to be sent to the server
-        isTCP = false
-        for _, replayFilePacket := range replayFilePackets {
-            udpPacket, err := newUDPPacket(replayFilePacket.CSPair, replayFilePacket.Timestamp, replayFilePacket.Payload, *replayFilePacket.End)
-            if err != nil {
-                return ReplayInfo{}, err
-            }
-
-            packets = append(packets, &udpPacket)
-        }
+        return nil, nil, err
     }
-
-    //TODO: also very sketch, fix test file format, as mentioned above
-    var csPair CSPair
-    if isTCP {
-        var csPairs []string
-        err := json.Unmarshal(jsonData[2], &csPairs)
-        if err != nil {
-            return ReplayInfo{}, err
-        }
-        csPair, err = newCSPair(csPairs[0]) // we currently only have 1 cs pair
-        if err != nil {
-            return ReplayInfo{}, err
-        }
-    } else {
-        csPair, err = newCSPair(packets[0].(*UDPPacket).CSPair)
+    allTests := make([]Test, len(entries))
+    for i, entry := range entries {
+        err = json.Unmarshal(entry, &allTests[i])
         if err != nil {
-            return ReplayInfo{}, err
+            return nil, nil, err
         }
     }
-    replayName := strings.Split(string(jsonData[3]), "\"")[1]
-
-    isPortTest := strings.HasPrefix(replayName, "port")
-
-    return ReplayInfo{
-        Packets: packets,
-        CSPair: csPair,
-        ReplayName: replayName,
-        IsTCP: isTCP,
-        IsPortTest: isPortTest,
-    }, nil
+    return allTests, entries, nil
 }
 
 // Checks if slice contains a string.
@@ -274,20 +265,47 @@ func containsString(slice []string, target string) bool {
 
 // Determines if there are any test names provided by the user that are not valid.
 // testNames: the list of test names that was given by the user
-// validTestNames: a valid list of test names
-// Returns an error if a user-provided test name is not in the list of valid test names
-func checkValidTestNames(testNames []string, validTestNames []string) error {
-    // add all valid tests to a map
+// allTests: all the tests in the tests configuration file
+// logger: logger to write messages to
+// Returns an error if two tests in the tests configuration file have the same selector, if a
+//     user-provided test name is ambiguous, or if a user-provided test name does not pick any tests
+func checkValidTestNames(testNames []string, allTests []Test, logger *logging.Logger) error {
+    // add all valid tests to a map; every test must be able to be picked on its own
     validTestNamesMap := make(map[string]bool)
-    for _, validTestName := range validTestNames {
-        validTestNamesMap[validTestName] = true
-        fmt.Println(validTestName)
+    for _, test := range allTests {
+        if validTestNamesMap[test.Selector()] {
+            return fmt.Errorf("More than one test in the tests configuration file is named %s.", test.Selector())
+        }
+        validTestNamesMap[test.Selector()] = true
+        logger.Debug("Valid test name: %s", test.Selector())
     }
 
-    // see if each user provided test is in the map
+    // see if each user provided test picks at least one test
     var invalidTestNames []string
     for _, testName := range testNames {
-        if !validTestNamesMap[testName] {
+        if validTestNamesMap[testName] {
+            continue
+        }
+
+        // the image is shared by more than one test, such as the small and large port tests
+        var sameImageSelectors []string
+        for _, test := range allTests {
+            if test.Image == testName {
+                sameImageSelectors = append(sameImageSelectors, test.Selector())
+            }
+        }
+        if len(sameImageSelectors) > 1 {
+            return fmt.Errorf("%s is ambiguous. Choose from %s.", testName, strings.Join(sameImageSelectors, ", "))
+        }
+
+        picked := false
+        for _, test := range allTests {
+            if matchesSelector(test, testName) {
+                picked = true
+                break
+            }
+        }
+        if !picked {
             invalidTestNames = append(invalidTestNames, testName)
         }
     }
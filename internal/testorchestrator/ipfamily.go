@@ -0,0 +1,32 @@
+package testorchestrator
+
+// A comparison of the results of a test that ran on the same server over IPv4 and over IPv6.
+type IPFamilyComparison struct {
+    IPv4TestID int // the ID of the test that ran over IPv4
+    IPv4Result string // the verdict over IPv4
+    IPv6Result string // the verdict over IPv6
+    OriginalThroughputDelta float64 // average throughput of the original replay over IPv6 minus the one over IPv4
+    RandomThroughputDelta float64 // average throughput of the random replay over IPv6 minus the one over IPv4
+}
+
+// Compares the results of a test that ran over IPv4 with the results of the same test over IPv6.
+// The comparison is stored in the IPv6 results.
+// ipv4Results: the results of the test over IPv4 for each server
+// ipv4TestID: the ID of the test that ran over IPv4
+// ipv6Results: the results of the test over IPv6 for each server, in the same order as ipv4Results
+func CompareIPFamilies(ipv4Results []TestResult, ipv4TestID int, ipv6Results []TestResult) {
+    for i := range ipv6Results {
+        if i >= len(ipv4Results) {
+            break
+        }
+        ipv4Result := ipv4Results[i]
+        ipv6Result := &ipv6Results[i]
+        ipv6Result.IPFamilyComparison = &IPFamilyComparison{
+            IPv4TestID: ipv4TestID,
+            IPv4Result: ipv4Result.Result,
+            IPv6Result: ipv6Result.Result,
+            OriginalThroughputDelta: ipv6Result.KS2Result.OriginalAvgThroughput - ipv4Result.KS2Result.OriginalAvgThroughput,
+            RandomThroughputDelta: ipv6Result.KS2Result.RandomAvgThroughput - ipv4Result.KS2Result.RandomAvgThroughput,
+        }
+    }
+}
@@ -4,16 +4,50 @@ package testorchestrator
 import (
     "context"
     "crypto/tls"
+    "errors"
     "fmt"
     "math"
     "path"
     "time"
 
+    "wehe-cmdline-client/internal/clientinfo"
     "wehe-cmdline-client/internal/config"
+    "wehe-cmdline-client/internal/logging"
+    "wehe-cmdline-client/internal/network"
     "wehe-cmdline-client/internal/serverhandler"
     "wehe-cmdline-client/internal/testdata"
 )
 
+// Returned by Run when the test is stopped before it finishes, such as when the user presses Ctrl-C.
+var ErrInterrupted = errors.New("Test interrupted.")
+
+// Returned by Run when a step of the test fails on one of the servers, so that only that server is
+// blamed for the failure.
+type ServerError struct {
+    Server *serverhandler.Server // the server that the step failed on
+    Err error // why the step failed
+}
+
+func (e *ServerError) Error() string {
+    return e.Err.Error()
+}
+
+func (e *ServerError) Unwrap() error {
+    return e.Err
+}
+
+const (
+    noDifferentiationStatus = "No Differentiation"
+    inconclusiveStatus = "Results Inconclusive"
+    differentiationStatus = "Differentiation Detected"
+    blockedStatus = "Blocked" // result of a test where the server's responses stopped arriving before the replay finished
+    contentModifiedStatus = "Content Modified" // result of a test where the server's responses did not match the replay file
+    interruptedStatus = "Interrupted" // result of a test that was stopped before it finished
+
+    resultsTries = 3 // number of times to ask the results endpoint for the analysis
+    resultsRecoveryTries = 10 // number of times to ask the results endpoint when the side channel did not return the analysis
+)
+
 type ReplayType int
 
 const (
@@ -21,26 +55,86 @@ const (
     Random
 )
 
+// Gets the name of the replay type.
+// Returns "original", "random", or "unknown"
+func (rt ReplayType) String() string {
+    switch rt {
+    case Original:
+        return "original"
+    case Random:
+        return "random"
+    default:
+        return "unknown"
+    }
+}
+
 type TestOrchestrator struct {
     test *testdata.Test // the test associated with the replay
     replayTypes []ReplayType // list of the types of replays to run in the test
     replayID int // the current replay that is being ran, increments by 1 for each replay ran in the test
+    firstReplayID int // the replay that the current test on the servers started with; the confirmation replays start a new test
     testDir string // path to the directory containing the replay files
     servers []*serverhandler.Server // list of servers to run this replay on
     isLastReplay bool // true if this is the last replay to run; false otherwise
+    isConfirmation bool // true if the confirmation replays are running; false otherwise
     samplesPerReplay int // number of samples taken per replay
+    confirmationReplays bool // true if the replays are run again to confirm differentiation
+    sendMobileStats bool // true if information about the client's host and network is sent to the servers
+    extraString string // the extra_string value in the config file, sent with the mobile stats
+    fetchResults bool // true if the analysis is also retrieved from the servers' results endpoints
+    userID string // the unique identifier for the user running the test
+    clientVersion string // client version of Wehe
+    tlsConfig *tls.Config // TLS configuration containing the server cert
+    testID int // the ID of the test that the servers are running; the confirmation replays run under a new test ID
+    nextTestID func() (int, error) // gets a new test ID for the confirmation replays
     useDefaultThresholds bool // true if default area threshold and KS 2 p-value threshold are used
     areaTestThreshold float64
     ks2PValThreshold float64
     testResults []TestResult // the results of the test for each server, including whether differentiation was present and analysis results
+    replayResults [][]ReplayResult // the throughputs of each replay that has run, indexed by server
+    logger *logging.Logger // logger to write progress to
 }
 
 type TestResult struct {
     ServerHostname string // hostname that the test took place on
-    Result string // Either "No differentiation", "Inconclusive results", or "Differentiation Detected"
+    ServerIP string // IP of the server that the test connected to
+    IPFamily string // family of ServerIP, either network.IPv4 or network.IPv6
+    MLabSite string // the MLab site of the server; empty if server is not MLab
+    MLabMetro string // the metro area of the MLab site; empty if server is not MLab
+    Result string // Either "No Differentiation", "Results Inconclusive", or "Differentiation Detected"; combines the initial and confirmation results if confirmation replays ran
     KS2Result testdata.KS2Result // the stats of the result
     AreaThreshold float64 // the area threshold that was used to determine differentiation
     KS2PValueThreshold float64 // the KS 2 p-value threshold that was used to determine differentiation
+    Replays []ReplayResult // the throughputs collected by the client for each replay that ran on the server
+    InitialResult string // the result of the first original and random replays
+    ConfirmationResult string // the result of the confirmation replays; empty if confirmation replays did not run
+    ConfirmationKS2Result *testdata.KS2Result // the stats of the confirmation replays; nil if confirmation replays did not run
+    ConfirmationAreaThreshold float64 // the area threshold that was used for the confirmation replays
+    ConfirmationTestID int // the ID of the test that the confirmation replays ran under; 0 if confirmation replays did not run
+    ServerAnalysis *testdata.ServerAnalysis // the analysis from the server's results endpoint; nil if results were not retrieved
+    ServerHistory []testdata.ServerAnalysis // the analyses of the user's tests up to this one from the server's results endpoint; nil if results were not retrieved
+    IPFamilyComparison *IPFamilyComparison // the comparison with the same test over IPv4; nil unless this test ran over IPv6 in dual-stack mode
+}
+
+// The throughputs collected by the client during a replay.
+type ReplayResult struct {
+    ReplayType ReplayType // whether this was the original or random replay
+    ElapsedTime time.Duration // the amount of time the replay ran for
+    Throughputs []float64 // the Mbps of each sample
+    SampleTimes []float64 // the number of seconds since the replay started that each sample was taken
+    AverageThroughput float64 // the average throughput of the replay
+    IsConfirmation bool // true if the replay was run to confirm differentiation
+    ResponseCheck *network.ResponseCheck // the check of the server's responses; nil if the replay was UDP
+    Flows []FlowResult // the throughputs of each flow of a UDP replay; nil if the replay was TCP
+}
+
+// The throughputs collected by the client on one flow of a UDP replay.
+type FlowResult struct {
+    ClientPort int // the client port of the flow in the replay file
+    ServerPort int // the server port of the flow in the replay file
+    Throughputs []float64 // the Mbps of each sample
+    SampleTimes []float64 // the number of seconds since the replay started that each sample was taken
+    AverageThroughput float64 // the average throughput of the flow
 }
 
 // Creates a new TestOrchestrator struct.
@@ -48,86 +142,229 @@ type TestResult struct {
 // replayTypes: the list of types of replays to run during the test
 // testDir: path to the directory that contains all the test files
 // servers: the list of servers that the replay should be run on
+// nextTestID: gets a new test ID for the confirmation replays, which run as a separate test like in
+//             the apps
+// logger: logger to write progress to
 // Returns a new Replay struct
-func NewTestOrchestrator(test *testdata.Test, replayTypes []ReplayType, cfg config.Config, servers []*serverhandler.Server) *TestOrchestrator {
+func NewTestOrchestrator(test *testdata.Test, replayTypes []ReplayType, cfg config.Config, servers []*serverhandler.Server, nextTestID func() (int, error), logger *logging.Logger) *TestOrchestrator {
     return &TestOrchestrator{
         test: test,
-        replayTypes: replayTypes,
+        testID: test.TestID,
+        nextTestID: nextTestID,
+        replayTypes: append([]ReplayType{}, replayTypes...),
         replayID: 0,
         testDir: cfg.ReplaysDir,
         servers: servers,
         isLastReplay: false,
+        isConfirmation: false,
+        confirmationReplays: cfg.ConfirmationReplays,
+        sendMobileStats: cfg.SendMobileStats,
+        extraString: cfg.ExtraString,
+        fetchResults: cfg.Result,
         useDefaultThresholds: cfg.UseDefaultThresholds,
         areaTestThreshold: float64(cfg.AreaThreshold) / 100.0,
         ks2PValThreshold: float64(cfg.KS2PValueThreshold) / 100.0,
         testResults: []TestResult{},
+        replayResults: make([][]ReplayResult, len(servers)),
+        logger: logger,
     }
 }
 
 // Runs a replay.
+// ctx: context that stops the test when cancelled
 // userID: the unique identifier for a user
 // clientVersion: client version of Wehe
 // tlsConfig: TLS configuration containing the server cert
+// Returns the results of the test for each server, or any errors; if the test is interrupted, the
+//     partial results collected so far are returned along with ErrInterrupted, and if a step
+//     failed on a server, the error is a *ServerError naming that server
+func (to *TestOrchestrator) Run(ctx context.Context, userID string, clientVersion string, tlsConfig *tls.Config) ([]TestResult, error) {
+    to.userID = userID
+    to.clientVersion = clientVersion
+    to.tlsConfig = tlsConfig
+    defer to.cleanUp()
+    // closing the side channels unblocks any requests waiting on the servers
+    stopInterrupt := context.AfterFunc(ctx, to.interrupt)
+    defer stopInterrupt()
+
+    err := to.run(ctx)
+    if ctx.Err() != nil {
+        return to.getPartialResults(), ErrInterrupted
+    }
+    if err != nil {
+        return nil, err
+    }
+    return to.testResults, nil
+}
+
+// Runs the steps of a replay.
+// ctx: context that stops the test when cancelled
 // Returns any errors
-func (to *TestOrchestrator) Run(userID string, clientVersion string, tlsConfig *tls.Config) ([]TestResult, error) {
+func (to *TestOrchestrator) run(ctx context.Context) error {
+    err := to.runFirstReplay(ctx, len(to.replayTypes) == 1)
+    if err != nil {
+        return err
+    }
+
+    for i := 1; i < len(to.replayTypes); i++ {
+        err = to.runNextReplay(ctx, i == len(to.replayTypes) - 1)
+        if err != nil {
+            return err
+        }
+    }
+
+    err = to.analyzeTest(ctx)
+    if err != nil {
+        return err
+    }
+
+    if to.confirmationReplays && to.isDifferentiationDetected() {
+        err = to.runConfirmationReplays(ctx)
+        if err != nil {
+            return err
+        }
+    }
+
+    if to.fetchResults && ctx.Err() == nil {
+        to.getServerAnalyses(ctx)
+    }
+    return nil
+}
+
+// Starts a test on the servers under the current test ID and runs its first replay, which is the
+// current replay.
+// ctx: context that stops the test when cancelled
+// isLastReplay: true if this is the last replay to run in the test; false otherwise
+// Returns any errors
+func (to *TestOrchestrator) runFirstReplay(ctx context.Context, isLastReplay bool) error {
+    to.firstReplayID = to.replayID
+    to.isLastReplay = isLastReplay
     replayInfo, err := to.getCurrentReplayInfo()
     if err != nil {
-        return nil, err
+        return err
     }
 
-    defer to.cleanUp()
-    err = to.connectToSideChannel(tlsConfig)
+    err = to.connectToSideChannel(to.tlsConfig)
     if err != nil {
-        return nil, err
+        return err
     }
 
-    err = to.sendID(replayInfo, userID, clientVersion)
+    err = to.sendID(ctx, replayInfo, to.userID, to.clientVersion)
     if err != nil {
-        return nil, err
+        return err
     }
 
     err = to.ask4Permission()
     if err != nil {
-        return nil, err
+        return err
     }
 
-    err = to.sendAndReceivePackets(replayInfo)
-    if err != nil {
-        return nil, err
+    if to.sendMobileStats {
+        err = to.sendClientInfo()
+        if err != nil {
+            return err
+        }
     }
 
-    err = to.sendThroughputs()
+    err = to.sendAndReceivePackets(ctx, replayInfo)
     if err != nil {
-        return nil, err
+        return err
     }
 
+    return to.sendThroughputs()
+}
+
+// Declares and runs the next replay in the test.
+// ctx: context that stops the test when cancelled
+// isLastReplay: true if this is the last replay to run in the test; false otherwise
+// Returns any errors
+func (to *TestOrchestrator) runNextReplay(ctx context.Context, isLastReplay bool) error {
     to.replayID += 1
-    to.isLastReplay = true
-    replayInfo, err = to.getCurrentReplayInfo()
+    to.isLastReplay = isLastReplay
+    replayInfo, err := to.getCurrentReplayInfo()
     if err != nil {
-        return nil, err
+        return err
     }
     err = to.declareReplay(replayInfo)
     if err != nil {
-        return nil, err
+        return err
     }
 
-    err = to.sendAndReceivePackets(replayInfo)
+    err = to.sendAndReceivePackets(ctx, replayInfo)
     if err != nil {
-        return nil, err
+        return err
     }
 
-    err = to.sendThroughputs()
+    return to.sendThroughputs()
+}
+
+// Runs the original and random replays again to make sure that the differentiation detected by
+// the first replays was not caused by noise on the network. The servers were told that the first
+// test is over, so like the mobile apps, the confirmation replays run as a new test with a new
+// test ID.
+// ctx: context that stops the test when cancelled
+// Returns any errors
+func (to *TestOrchestrator) runConfirmationReplays(ctx context.Context) error {
+    testID, err := to.nextTestID()
     if err != nil {
-        return nil, err
+        return err
     }
+    to.testID = testID
+    for i := range to.testResults {
+        to.testResults[i].ConfirmationTestID = testID
+    }
+    to.logger.UI("Differentiation detected for %s. Running confirmation replays (test ID %d)...", to.test.DisplayName(), testID)
+    numReplays := len(to.replayTypes)
+    to.replayTypes = append(to.replayTypes, to.replayTypes...)
+    to.isConfirmation = true
 
-    err = to.analyzeTest()
+    to.replayID += 1
+    err = to.runFirstReplay(ctx, numReplays == 1)
     if err != nil {
-        return nil, err
+        return err
+    }
+    for i := 1; i < numReplays; i++ {
+        err = to.runNextReplay(ctx, i == numReplays - 1)
+        if err != nil {
+            return err
+        }
     }
 
-    return to.testResults, nil
+    return to.analyzeConfirmation(ctx)
+}
+
+// Checks whether the server's responses during the replays matched the replay files. A replay whose
+// responses were blocked or modified, such as by a transparent proxy or an injected block page,
+// does not measure the throughput of the app's traffic, so the result of the test is the response
+// check instead of the throughput comparison.
+// replayResults: the replays that ran on a server
+// Returns contentModifiedStatus or blockedStatus if any replay's responses did not match; an empty
+//     string otherwise
+func checkResponses(replayResults []ReplayResult) string {
+    status := ""
+    for _, replayResult := range replayResults {
+        if replayResult.ResponseCheck == nil {
+            continue
+        }
+        switch replayResult.ResponseCheck.Status {
+        case network.ResponsesModified:
+            return contentModifiedStatus
+        case network.ResponsesBlocked:
+            status = blockedStatus
+        }
+    }
+    return status
+}
+
+// Checks if differentiation was detected on any of the servers.
+// Returns true if differentiation was detected; false otherwise
+func (to *TestOrchestrator) isDifferentiationDetected() bool {
+    for _, testResult := range to.testResults {
+        if testResult.Result == differentiationStatus {
+            return true
+        }
+    }
+    return false
 }
 
 // Connect the client to the servers to run test.
@@ -137,7 +374,7 @@ func (to *TestOrchestrator) connectToSideChannel(tlsConfig *tls.Config) error {
     for id, srv := range to.servers {
         err := srv.ConnectToSideChannel(id, tlsConfig)
         if err != nil {
-            return err
+            return &ServerError{Server: srv, Err: err}
         }
     }
     return nil
@@ -145,26 +382,31 @@ func (to *TestOrchestrator) connectToSideChannel(tlsConfig *tls.Config) error {
 
 // Let the servers know that client wants to run a test. Send information about the test and the
 // first replay.
+// ctx: context that stops the test when cancelled
 // replayInfo: Information about the replay to run
 // userID: the identifier for the device running the replay
 // clientVersion: the version of the Wehe client
 // Returns any errors
-func (to *TestOrchestrator) sendID(replayInfo testdata.ReplayInfo, userID string, clientVersion string) error {
+func (to *TestOrchestrator) sendID(ctx context.Context, replayInfo testdata.ReplayInfo, userID string, clientVersion string) error {
     replayType, err := to.getReplayID()
     if err != nil {
         return err
     }
     // let the server know what replay to run
     for _, srv := range to.servers {
-        err = srv.SendID(replayInfo.IsTCP, replayInfo.CSPair.ServerPort, userID, int(replayType), replayInfo.ReplayName, to.test.TestID, to.isLastReplay, clientVersion)
+        err = srv.SendID(replayInfo.IsTCP, replayInfo.CSPair.ServerPort, userID, int(replayType), replayInfo.ReplayName, to.testID, to.isLastReplay, clientVersion)
         if err != nil {
-            return err
+            return &ServerError{Server: srv, Err: err}
         }
     }
 
     //TODO: client needs this since it sends ask4perm too fast. fix this by having server send back response for SendID that checks if the SendID input is any good
-    time.Sleep(time.Second)
-    return nil
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-time.After(time.Second):
+        return nil
+    }
 }
 
 // Asks the servers if the client can run the replay.
@@ -174,19 +416,39 @@ func (to *TestOrchestrator) ask4Permission() error {
     for _, srv := range to.servers {
         samplesPerReplay, err := srv.Ask4Permission()
         if err != nil {
-            return err
+            return &ServerError{Server: srv, Err: err}
         }
         to.samplesPerReplay = samplesPerReplay
     }
     return nil
 }
 
+// Sends information about the client's host and network to the servers.
+// Returns any errors
+func (to *TestOrchestrator) sendClientInfo() error {
+    stats, err := clientinfo.Collect(to.extraString).JSON()
+    if err != nil {
+        return err
+    }
+    to.logger.Debug("Mobile stats: %s", stats)
+    for _, srv := range to.servers {
+        err = srv.SendMobileStats(stats)
+        if err != nil {
+            return &ServerError{Server: srv, Err: err}
+        }
+    }
+    return nil
+}
+
 // Conduct the test by sending and receiving packets.
+// parentCtx: context that stops the test when cancelled
 // replayInfo: information about the replay
 // Returns any errors
-func (to *TestOrchestrator) sendAndReceivePackets(replayInfo testdata.ReplayInfo) error {
+func (to *TestOrchestrator) sendAndReceivePackets(parentCtx context.Context, replayInfo testdata.ReplayInfo) error {
+    to.logger.UI("Running %s replay of %s...", to.replayTypes[to.replayID], to.test.DisplayName())
+
     // send and receive packets
-    ctx, cancel := context.WithCancel(context.Background())
+    ctx, cancel := context.WithCancel(parentCtx)
     defer cancel()
     var errChans []chan error
     for _, srv := range to.servers {
@@ -195,13 +457,19 @@ func (to *TestOrchestrator) sendAndReceivePackets(replayInfo testdata.ReplayInfo
         errChans = append(errChans, errChan)
     }
 
-    for _, errChan := range errChans {
+    // wait for every server so that a failure is reported for the server it happened on; the other
+    // servers stop when one fails, so their cancellations are not blamed
+    var serverErr error
+    for i, errChan := range errChans {
         err := <-errChan
-        if err != nil {
-            return err
+        if err == nil {
+            continue
+        }
+        if serverErr == nil || (errors.Is(serverErr, context.Canceled) && !errors.Is(err, context.Canceled)) {
+            serverErr = &ServerError{Server: to.servers[i], Err: err}
         }
     }
-    return nil
+    return serverErr
 }
 
 // Send the replay duration and throughput samples collected by the client during the replay to the
@@ -213,22 +481,34 @@ func (to *TestOrchestrator) sendThroughputs() error {
         return err
     }
     // send replay duration and samples to server
-    for _, srv := range to.servers {
+    for i, srv := range to.servers {
         averageThroughput, err := srv.SendThroughputs()
         if err != nil {
-            return err
+            return &ServerError{Server: srv, Err: err}
         }
-
-        fmt.Println("DEBUG avg thruput:", averageThroughput)
-        // TODO: currently only test of last server is stored; figure out what to do with tomography
-        switch replayType {
-        case Original:
-            to.test.OriginalThroughput = averageThroughput
-        case Random:
-            to.test.RandomThroughput = averageThroughput
-        default:
-            return fmt.Errorf("Cannot set throughput; invalid test type: %v", replayType)
+        replayResult := ReplayResult{
+            ReplayType: replayType,
+            ElapsedTime: srv.ThroughputCalculator.ReplayElapsedTime,
+            Throughputs: srv.ThroughputCalculator.Throughputs,
+            SampleTimes: srv.ThroughputCalculator.SampleTimes,
+            AverageThroughput: averageThroughput,
+            IsConfirmation: to.isConfirmation,
+            ResponseCheck: srv.ResponseCheck,
         }
+        for _, flow := range srv.UDPFlows {
+            flowResult := FlowResult{
+                ClientPort: flow.CSPair.ClientPort,
+                ServerPort: flow.CSPair.ServerPort,
+                Throughputs: flow.ThroughputCalculator.Throughputs,
+                SampleTimes: flow.ThroughputCalculator.SampleTimes,
+                AverageThroughput: flow.ThroughputCalculator.GetAverageThroughput(),
+            }
+            replayResult.Flows = append(replayResult.Flows, flowResult)
+            to.logger.Debug("Average throughput of flow %d of %s replay on %s: %f Mbps", flowResult.ClientPort, replayType, srv.HostName, flowResult.AverageThroughput)
+        }
+        to.replayResults[i] = append(to.replayResults[i], replayResult)
+
+        to.logger.Debug("Average throughput of %s replay on %s: %f Mbps", replayType, srv.HostName, averageThroughput)
     }
     return nil
 }
@@ -244,7 +524,7 @@ func (to *TestOrchestrator) declareReplay(replayInfo testdata.ReplayInfo) error
     for _, srv := range to.servers {
         samplesPerReplay, err := srv.DeclareReplay(int(replayType), replayInfo.ReplayName, to.isLastReplay)
         if err != nil {
-            return err
+            return &ServerError{Server: srv, Err: err}
         }
         to.samplesPerReplay = samplesPerReplay
     }
@@ -252,23 +532,137 @@ func (to *TestOrchestrator) declareReplay(replayInfo testdata.ReplayInfo) error
 }
 
 // Makes a request to analyze test.
+// ctx: context that stops the requests when cancelled
 // Returns any errors
-func (to *TestOrchestrator) analyzeTest() error {
-    for _, srv := range to.servers {
-        ks2Result, err := srv.AnalyzeTest()
+func (to *TestOrchestrator) analyzeTest(ctx context.Context) error {
+    to.logger.UI("Analyzing results of %s...", to.test.DisplayName())
+    for i, srv := range to.servers {
+        ks2Result, err := to.getAnalysis(ctx, srv)
         if err != nil {
-            return err
+            return &ServerError{Server: srv, Err: err}
         }
 
-        to.determineDifferentiation(srv.HostName, ks2Result)
+        status, areaThreshold := to.determineDifferentiation(ks2Result)
+        if responseStatus := checkResponses(to.replayResults[i]); responseStatus != "" {
+            status = responseStatus
+        }
+        to.testResults = append(to.testResults, TestResult{
+            ServerHostname: srv.HostName,
+            ServerIP: srv.IP,
+            IPFamily: srv.IPFamily,
+            MLabSite: srv.MLabSite,
+            MLabMetro: srv.MLabMetro,
+            Result: status,
+            KS2Result: ks2Result,
+            AreaThreshold: areaThreshold,
+            KS2PValueThreshold: to.ks2PValThreshold,
+            Replays: to.replayResults[i],
+            InitialResult: status,
+        })
     }
     return nil
 }
 
+// Makes a request to analyze the confirmation replays, and combines the confirmation result with
+// the initial result. Differentiation is only reported if both the initial and confirmation
+// replays detect it.
+// ctx: context that stops the requests when cancelled
+// Returns any errors
+func (to *TestOrchestrator) analyzeConfirmation(ctx context.Context) error {
+    to.logger.UI("Analyzing confirmation results of %s...", to.test.DisplayName())
+    for i, srv := range to.servers {
+        ks2Result, err := to.getAnalysis(ctx, srv)
+        if err != nil {
+            return &ServerError{Server: srv, Err: err}
+        }
+
+        status, areaThreshold := to.determineDifferentiation(ks2Result)
+        testResult := &to.testResults[i]
+        testResult.ConfirmationResult = status
+        testResult.ConfirmationKS2Result = &ks2Result
+        testResult.ConfirmationAreaThreshold = areaThreshold
+        testResult.Replays = to.replayResults[i]
+        if testResult.InitialResult == differentiationStatus && status != differentiationStatus {
+            testResult.Result = inconclusiveStatus
+        }
+        if responseStatus := checkResponses(to.replayResults[i]); responseStatus != "" {
+            testResult.ConfirmationResult = responseStatus
+            testResult.Result = responseStatus
+        }
+    }
+    return nil
+}
+
+// Asks a server to analyze the test. If the side channel fails before the analysis is returned and
+// results are enabled, the analysis is retrieved from the server's results endpoint instead. The
+// side channel is closed when the test is interrupted, so the analysis is not recovered then.
+// ctx: context that stops the requests when cancelled
+// srv: the server to get the analysis from
+// Returns the analysis, or any errors
+func (to *TestOrchestrator) getAnalysis(ctx context.Context, srv *serverhandler.Server) (testdata.KS2Result, error) {
+    ks2Result, err := srv.AnalyzeTest()
+    if err == nil || !to.fetchResults || ctx.Err() != nil {
+        return ks2Result, err
+    }
+
+    to.logger.Warn("Unable to get analysis from %s over the side channel: %v. Trying the results endpoint...", srv.HostName, err)
+    replayID, resultsErr := to.getResultsReplayID()
+    if resultsErr != nil {
+        return testdata.KS2Result{}, fmt.Errorf("%v; %v", err, resultsErr)
+    }
+    analysis, resultsErr := srv.GetResults(ctx, to.userID, to.testID, replayID, to.tlsConfig, resultsRecoveryTries)
+    if resultsErr != nil {
+        return testdata.KS2Result{}, fmt.Errorf("%v; %v", err, resultsErr)
+    }
+    return analysis.KS2Result(), nil
+}
+
+// Gets the analysis stored on each server from the server's results endpoint, so that the
+// verdict can be checked against the server's record, along with the user's history on the
+// server. Failures are logged but not returned since the test itself has finished.
+// ctx: context that stops the requests when cancelled
+func (to *TestOrchestrator) getServerAnalyses(ctx context.Context) {
+    replayID, err := to.getResultsReplayID()
+    if err != nil {
+        to.logger.Warn("%v", err)
+        return
+    }
+    for i, srv := range to.servers {
+        if ctx.Err() != nil {
+            return
+        }
+        analysis, err := srv.GetResults(ctx, to.userID, to.testID, replayID, to.tlsConfig, resultsTries)
+        if err != nil {
+            to.logger.Warn("%v", err)
+            continue
+        }
+        to.testResults[i].ServerAnalysis = &analysis
+
+        history, err := srv.GetResultsHistory(ctx, to.userID, to.testID, to.tlsConfig, resultsTries)
+        if err != nil {
+            to.logger.Warn("%v", err)
+            continue
+        }
+        to.testResults[i].ServerHistory = history
+    }
+}
+
+// Gets the ID of the replay that the servers store the analysis of the current test under, which
+// is the random replay of the test.
+// Returns the replay ID, or an error if no random replay ran in the current test
+func (to *TestOrchestrator) getResultsReplayID() (int, error) {
+    for i := to.firstReplayID; i <= to.replayID && i < len(to.replayTypes); i++ {
+        if to.replayTypes[i] == Random {
+            return int(Random), nil
+        }
+    }
+    return 0, fmt.Errorf("No random replay ran in test %d, so the servers have no analysis of it.", to.testID)
+}
+
 // Determines whether differentiation was present in the test.
-// hostname: hostname the test ran on
 // ks2Result: the results of the 2-sample KS test
-func (to *TestOrchestrator) determineDifferentiation(hostname string, ks2Result testdata.KS2Result) {
+// Returns the status of the test and the area threshold that was used to determine it
+func (to *TestOrchestrator) determineDifferentiation(ks2Result testdata.KS2Result) (string, float64) {
     //area test threshold default is 50%; ks2 p value test threshold default is 1%
     //if default switch is on and one of the throughputs is over 10 Mbps, change the
     //area threshold to 30%, which increases chance of Wehe finding differentiation.
@@ -277,29 +671,59 @@ func (to *TestOrchestrator) determineDifferentiation(hostname string, ks2Result
     //triggered, which may confuse users
     //TODO: might have to relook at thresholds and do some formal research on optimal
     // thresholds. Currently thresholds chosen ad-hoc
+    areaThreshold := to.areaTestThreshold
     if to.useDefaultThresholds && (ks2Result.OriginalAvgThroughput > 10 || ks2Result.RandomAvgThroughput > 10) {
-        to.areaTestThreshold = 0.3
+        areaThreshold = 0.3
     }
 
-    aboveArea := math.Abs(ks2Result.Area0var) >= to.areaTestThreshold
+    aboveArea := math.Abs(ks2Result.Area0var) >= areaThreshold
     belowP := ks2Result.KS2pVal < to.ks2PValThreshold
 
-    status := "No Differentiation"
+    status := noDifferentiationStatus
     if aboveArea {
         if belowP {
-            status = "Differentiation Detected"
+            status = differentiationStatus
         } else {
-            status = "Results Inconclusive"
+            status = inconclusiveStatus
+        }
+    }
+    return status, areaThreshold
+}
+
+// Gets the results collected so far for a test that did not finish.
+// Returns a result for each server containing the throughputs of the replays that finished
+func (to *TestOrchestrator) getPartialResults() []TestResult {
+    var partialResults []TestResult
+    for i, srv := range to.servers {
+        if i < len(to.testResults) {
+            // the initial replays were analyzed, but the confirmation replays did not finish
+            partialResult := to.testResults[i]
+            partialResult.Result = interruptedStatus
+            partialResult.Replays = to.replayResults[i]
+            partialResults = append(partialResults, partialResult)
+            continue
         }
+        partialResults = append(partialResults, TestResult{
+            ServerHostname: srv.HostName,
+            ServerIP: srv.IP,
+            IPFamily: srv.IPFamily,
+            MLabSite: srv.MLabSite,
+            MLabMetro: srv.MLabMetro,
+            Result: interruptedStatus,
+            AreaThreshold: to.areaTestThreshold,
+            KS2PValueThreshold: to.ks2PValThreshold,
+            Replays: to.replayResults[i],
+        })
     }
-    testResult := TestResult{
-        ServerHostname: hostname,
-        Result: status,
-        KS2Result: ks2Result,
-        AreaThreshold: to.areaTestThreshold,
-        KS2PValueThreshold: to.ks2PValThreshold,
+    return partialResults
+}
+
+// Stops any requests to the servers that are in progress. Called when the test is interrupted.
+func (to *TestOrchestrator) interrupt() {
+    to.logger.UI("Stopping %s...", to.test.DisplayName())
+    for _, srv := range to.servers {
+        srv.Interrupt()
     }
-    to.testResults = append(to.testResults, testResult)
 }
 
 func (to *TestOrchestrator) cleanUp() {
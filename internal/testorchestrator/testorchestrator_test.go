@@ -0,0 +1,714 @@
+package testorchestrator
+
+import (
+    "context"
+    "crypto/sha1"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "wehe-cmdline-client/internal/config"
+    "wehe-cmdline-client/internal/fakeserver"
+    "wehe-cmdline-client/internal/network"
+    "wehe-cmdline-client/internal/serverhandler"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    testCSPair = "010.000.000.001.50000-010.000.000.002.00080"
+    testUDPCSPair = "010.000.000.001.50001-010.000.000.002.09000"
+    testUDPCSPair2 = "010.000.000.001.50002-010.000.000.002.09000"
+    testUserID = "@abcdefghi"
+)
+
+// Writes a TCP replay file that sends a packet every 100 ms. Each response is 50000 bytes.
+// responseHash: the hash of each response; empty for no hash
+func writeTCPReplay(t *testing.T, dir string, filename string, replayName string, responseHash string) testdata.ReplayInfo {
+    return writeTCPReplayResponses(t, dir, filename, replayName, responseHash, []int{50000, 50000, 50000, 50000, 50000, 50000})
+}
+
+// Writes a TCP replay file that sends a packet every 100 ms, with a response of each length.
+// responseHash: the hash of each response; empty for no hash
+func writeTCPReplayResponses(t *testing.T, dir string, filename string, replayName string, responseHash string, responseLengths []int) testdata.ReplayInfo {
+    var hash interface{}
+    if responseHash != "" {
+        hash = responseHash
+    }
+    var packets []map[string]interface{}
+    for i, responseLength := range responseLengths {
+        packets = append(packets, map[string]interface{}{
+            "c_s_pair": testCSPair,
+            "timestamp": float64(i) * 0.1,
+            "payload": hex.EncodeToString([]byte(fmt.Sprintf("GET /video/%d HTTP/1.1\r\n\r\n", i))),
+            "response_len": responseLength,
+            "response_hash": hash,
+        })
+    }
+    return writeReplay(t, dir, filename, []interface{}{packets, []string{}, []string{testCSPair}, replayName})
+}
+
+// Writes a UDP replay file with two flows that each send a packet every 50 ms. The second flow
+// ends before its last packet.
+func writeUDPReplay(t *testing.T, dir string, filename string, replayName string) testdata.ReplayInfo {
+    var packets []map[string]interface{}
+    for i := 0; i < 8; i++ {
+        for _, csPair := range []string{testUDPCSPair, testUDPCSPair2} {
+            end := (csPair == testUDPCSPair && i == 7) || (csPair == testUDPCSPair2 && i == 5)
+            packets = append(packets, map[string]interface{}{
+                "c_s_pair": csPair,
+                "timestamp": float64(i) * 0.05,
+                "payload": hex.EncodeToString(make([]byte, 1000)),
+                "end": end,
+            })
+        }
+    }
+    return writeReplay(t, dir, filename, []interface{}{packets, []string{"50002", "50001"}, []string{}, replayName})
+}
+
+// Writes a replay file and parses it.
+// replay: the contents of the replay file
+func writeReplay(t *testing.T, dir string, filename string, replay []interface{}) testdata.ReplayInfo {
+    data, err := json.Marshal(replay)
+    if err != nil {
+        t.Fatal(err)
+    }
+    replayFile := filepath.Join(dir, filename)
+    err = os.WriteFile(replayFile, data, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    replayInfo, err := testdata.ParseReplayJSON(replayFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return replayInfo
+}
+
+// How the fake servers and the test orchestrator that runs on them are set up.
+type serverOptions struct {
+    ks2Results []*testdata.KS2Result // the analysis returned by each fake server, nil to compute it; one fake server is started for each, or one that computes the analysis if empty
+    confirmationReplays bool // true if differentiation is confirmed by running the replays again
+    fetchResults bool // true if the analyses are fetched from the results endpoint
+    replaysDir string // directory of the original and random replays; TCP replays with responseHash are written to a temporary directory if empty
+    original testdata.ReplayInfo // the original replay in replaysDir
+    random testdata.ReplayInfo // the random replay in replaysDir
+    responseHash string // the hash of each response of the TCP replays written when replaysDir is empty; empty for no hash
+    fake fakeserver.Config // the configurations of the fake servers; the replays, samples, and analysis are set for each server
+}
+
+// Starts fake servers that run a test, and creates a test orchestrator that runs the test on all
+// of them.
+// options: how the servers and the orchestrator are set up
+func startServers(t *testing.T, options serverOptions) ([]*fakeserver.Server, *TestOrchestrator, *tls.Config) {
+    replaysDir, original, random := options.replaysDir, options.original, options.random
+    if replaysDir == "" {
+        replaysDir = t.TempDir()
+        original = writeTCPReplay(t, replaysDir, "Video.json", "Video-01012024", options.responseHash)
+        random = writeTCPReplay(t, replaysDir, "VideoRandom.json", "VideoRandom-01012024", options.responseHash)
+    }
+    ks2Results := options.ks2Results
+    if len(ks2Results) == 0 {
+        ks2Results = []*testdata.KS2Result{nil}
+    }
+    fakeConfig := options.fake
+
+    var fakes []*fakeserver.Server
+    var servers []*serverhandler.Server
+    caCertPool := x509.NewCertPool()
+    listenIP := fakeConfig.ListenIP
+    if listenIP == "" {
+        listenIP = fakeserver.DefaultListenIP
+    }
+    for _, ks2Result := range ks2Results {
+        fakeConfig.Replays = []testdata.ReplayInfo{original, random}
+        fakeConfig.SamplesPerReplay = 10
+        fakeConfig.KS2Result = ks2Result
+        fake, err := fakeserver.Start(fakeConfig)
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(fake.Close)
+
+        srv, err := serverhandler.New(listenIP, fake.Ports(), network.IPFamilyAuto, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        caCertPool.AppendCertsFromPEM(fake.CACertPEM())
+        fakes = append(fakes, fake)
+        servers = append(servers, srv)
+    }
+
+    test := &testdata.Test{
+        Name: "Video",
+        Time: 2,
+        Image: "video",
+        DataFile: "Video.json",
+        RandomDataFile: "VideoRandom.json",
+        Category: testdata.CategoryVideo,
+        TestID: 7,
+    }
+    cfg := config.Config{
+        ReplaysDir: replaysDir,
+        ConfirmationReplays: options.confirmationReplays,
+        Result: options.fetchResults,
+        UseDefaultThresholds: true,
+        AreaThreshold: 50,
+        KS2PValueThreshold: 1,
+    }
+    nextTestID := func() (int, error) {
+        test.TestID += 1
+        return test.TestID, nil
+    }
+    to := NewTestOrchestrator(test, []ReplayType{Original, Random}, cfg, servers, nextTestID, nil)
+    return fakes, to, &tls.Config{RootCAs: caCertPool}
+}
+
+func TestRunNoDifferentiation(t *testing.T) {
+    ks2Result := &testdata.KS2Result{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4}
+    fakes, to, tlsConfig := startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result}, confirmationReplays: true})
+    fake := fakes[0]
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(testResults) != 1 {
+        t.Fatalf("Expected 1, got %d", len(testResults))
+    }
+    if testResults[0].Result != noDifferentiationStatus {
+        t.Errorf("Expected %s, got %s", noDifferentiationStatus, testResults[0].Result)
+    }
+    if testResults[0].KS2Result != *ks2Result {
+        t.Errorf("Expected %v, got %v", *ks2Result, testResults[0].KS2Result)
+    }
+    if len(testResults[0].Replays) != 2 {
+        t.Errorf("Expected 2, got %d", len(testResults[0].Replays))
+    }
+
+    // Test server received the ID and the throughputs of both replays
+    clientIDs := fake.ClientIDs()
+    if len(clientIDs) != 1 || !strings.HasPrefix(clientIDs[0], testUserID + ";0;Video-01012024;0;7;False;127.0.0.1;4.0") {
+        t.Errorf("Unexpected client IDs: %v", clientIDs)
+    }
+    reports := fake.ReplayReports()
+    if len(reports) != 2 || reports[0].ReplayName != "Video-01012024" || reports[1].ReplayName != "VideoRandom-01012024" {
+        t.Fatalf("Unexpected replay reports: %v", reports)
+    }
+    for _, report := range reports {
+        if len(report.Throughputs) == 0 || len(report.Throughputs) != len(report.SampleTimes) {
+            t.Errorf("Expected throughput samples, got %v", report)
+        }
+    }
+}
+
+func TestRunConfirmationReplays(t *testing.T) {
+    ks2Result := &testdata.KS2Result{Area0var: 0.8, KS2pVal: 0.001, OriginalAvgThroughput: 1, RandomAvgThroughput: 8}
+    fakes, to, tlsConfig := startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result}, confirmationReplays: true})
+    fake := fakes[0]
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].Result != differentiationStatus {
+        t.Errorf("Expected %s, got %s", differentiationStatus, testResults[0].Result)
+    }
+    if testResults[0].ConfirmationResult != differentiationStatus {
+        t.Errorf("Expected %s, got %s", differentiationStatus, testResults[0].ConfirmationResult)
+    }
+    if len(testResults[0].Replays) != 4 {
+        t.Errorf("Expected 4, got %d", len(testResults[0].Replays))
+    }
+    if len(fake.ReplayReports()) != 4 {
+        t.Errorf("Expected 4, got %d", len(fake.ReplayReports()))
+    }
+
+    // the first test ends with its random replay, and the confirmation replays run as a new test
+    if testResults[0].ConfirmationTestID != 8 {
+        t.Errorf("Expected confirmation test ID 8, got %d", testResults[0].ConfirmationTestID)
+    }
+    clientIDs := fake.ClientIDs()
+    if len(clientIDs) != 2 || !strings.HasPrefix(clientIDs[0], testUserID + ";0;Video-01012024;0;7;False;") || !strings.HasPrefix(clientIDs[1], testUserID + ";0;Video-01012024;0;8;False;") {
+        t.Errorf("Unexpected client IDs: %v", clientIDs)
+    }
+    declaredReplays := fake.DeclaredReplays()
+    if strings.Join(declaredReplays, ",") != "1;VideoRandom-01012024;True,1;VideoRandom-01012024;True" {
+        t.Errorf("Unexpected declared replays: %v", declaredReplays)
+    }
+}
+
+func TestRunInterrupted(t *testing.T) {
+    fakes, to, tlsConfig := startServers(t, serverOptions{})
+    fake := fakes[0]
+
+    // stop the test partway through the original replay
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go func() {
+        for len(fake.ClientIDs()) == 0 {
+            time.Sleep(10 * time.Millisecond)
+        }
+        time.Sleep(200 * time.Millisecond)
+        cancel()
+    }()
+
+    testResults, err := to.Run(ctx, testUserID, "4.0", tlsConfig)
+    if !errors.Is(err, ErrInterrupted) {
+        t.Fatalf("Expected %v, got %v", ErrInterrupted, err)
+    }
+    if len(testResults) != 1 || testResults[0].Result != interruptedStatus || testResults[0].ServerHostname != fakeserver.DefaultListenIP {
+        t.Errorf("Expected a partial result, got %v", testResults)
+    }
+    if len(fake.ReplayReports()) != 0 {
+        t.Errorf("Expected the original replay to be stopped, got %v", fake.ReplayReports())
+    }
+
+    // Test the side channel and replay connections were closed
+    deadline := time.Now().Add(2 * time.Second)
+    for fake.NumOpenConns() > 0 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    if fake.NumOpenConns() != 0 {
+        t.Errorf("Expected all connections to be closed, got %d open", fake.NumOpenConns())
+    }
+
+    // Test a server that is interrupted does not connect again
+    err = to.servers[0].ConnectToSideChannel(0, tlsConfig)
+    if err == nil {
+        t.Errorf("Expected error connecting after the interruption")
+    }
+    time.Sleep(100 * time.Millisecond)
+    if fake.NumOpenConns() != 0 {
+        t.Errorf("Expected the new side channel to be closed, got %d open", fake.NumOpenConns())
+    }
+}
+
+func TestRunComputedAnalysis(t *testing.T) {
+    _, to, tlsConfig := startServers(t, serverOptions{})
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    ks2Result := testResults[0].KS2Result
+    if ks2Result.OriginalAvgThroughput <= 0 || ks2Result.RandomAvgThroughput <= 0 {
+        t.Errorf("Expected positive throughputs, got %v", ks2Result)
+    }
+    if ks2Result.KS2pVal < 0 || ks2Result.KS2pVal > 1 {
+        t.Errorf("Expected p-value between 0 and 1, got %f", ks2Result.KS2pVal)
+    }
+}
+
+func TestRunMultipleServers(t *testing.T) {
+    ks2Results := []*testdata.KS2Result{
+        {Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4},
+        {Area0var: 0.8, KS2pVal: 0.001, OriginalAvgThroughput: 1, RandomAvgThroughput: 8},
+    }
+    fakes, to, tlsConfig := startServers(t, serverOptions{ks2Results: ks2Results})
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(testResults) != 2 {
+        t.Fatalf("Expected 2, got %d", len(testResults))
+    }
+    if testResults[0].Result != noDifferentiationStatus {
+        t.Errorf("Expected %s, got %s", noDifferentiationStatus, testResults[0].Result)
+    }
+    if testResults[1].Result != differentiationStatus {
+        t.Errorf("Expected %s, got %s", differentiationStatus, testResults[1].Result)
+    }
+
+    // Test each server received both replays
+    for i, fake := range fakes {
+        if len(fake.ReplayReports()) != 2 {
+            t.Errorf("Expected 2 replay reports on server %d, got %d", i, len(fake.ReplayReports()))
+        }
+    }
+
+    // Test combined analysis
+    tomography := AnalyzeTomography(testResults)
+    if tomography == nil {
+        t.Fatal("Expected tomography result, got nil")
+    }
+    if tomography.Verdict != somePathsVerdict {
+        t.Errorf("Expected %s, got %s", somePathsVerdict, tomography.Verdict)
+    }
+    if len(tomography.DifferentiatedPaths) != 1 || tomography.Localization == "" {
+        t.Errorf("Expected 1 differentiated path and a localization hint, got %v", tomography)
+    }
+    if len(tomography.Comparisons) != 1 {
+        t.Errorf("Expected 1, got %d", len(tomography.Comparisons))
+    }
+}
+
+func TestRunServerFailure(t *testing.T) {
+    ks2Result := &testdata.KS2Result{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4}
+    fakes, to, tlsConfig := startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result, ks2Result}})
+
+    // the second server goes away once the test has started
+    go func() {
+        for len(fakes[1].ClientIDs()) == 0 {
+            time.Sleep(10 * time.Millisecond)
+        }
+        fakes[1].Close()
+    }()
+
+    _, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    var serverErr *ServerError
+    if !errors.As(err, &serverErr) {
+        t.Fatalf("Expected a ServerError, got %v", err)
+    }
+    if serverErr.Server != to.servers[1] {
+        t.Errorf("Expected the second server to be blamed, got %s", serverErr.Server.IP)
+    }
+}
+
+func TestAnalyzeTomography(t *testing.T) {
+    replays := []ReplayResult{
+        {ReplayType: Original, Throughputs: []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+        {ReplayType: Random, Throughputs: []float64{8, 8, 8, 8, 8, 8, 8, 8, 8, 8}},
+    }
+    fastReplays := []ReplayResult{
+        {ReplayType: Original, Throughputs: []float64{8, 8, 8, 8, 8, 8, 8, 8, 8, 8}},
+        {ReplayType: Random, Throughputs: []float64{8, 8, 8, 8, 8, 8, 8, 8, 8, 8}},
+    }
+
+    // Test single server
+    if AnalyzeTomography([]TestResult{{ServerHostname: "a", Result: differentiationStatus}}) != nil {
+        t.Error("Expected nil for one server")
+    }
+
+    // Test differentiation on all paths
+    result := AnalyzeTomography([]TestResult{
+        {ServerHostname: "a", Result: differentiationStatus, KS2PValueThreshold: 0.01, Replays: replays},
+        {ServerHostname: "b", Result: differentiationStatus, KS2PValueThreshold: 0.01, Replays: replays},
+    })
+    if result.Verdict != allPathsVerdict {
+        t.Errorf("Expected %s, got %s", allPathsVerdict, result.Verdict)
+    }
+    if result.Comparisons[0].OriginalThroughputRatio != 1 || result.Comparisons[0].OriginalTreatedDifferently {
+        t.Errorf("Expected similar paths, got %v", result.Comparisons[0])
+    }
+
+    // Test original replay treated differently on one path
+    result = AnalyzeTomography([]TestResult{
+        {ServerHostname: "a", Result: differentiationStatus, KS2PValueThreshold: 0.01, Replays: replays},
+        {ServerHostname: "b", Result: noDifferentiationStatus, KS2PValueThreshold: 0.01, Replays: fastReplays},
+    })
+    if result.Verdict != somePathsVerdict {
+        t.Errorf("Expected %s, got %s", somePathsVerdict, result.Verdict)
+    }
+    if result.Comparisons[0].OriginalThroughputRatio != 0.125 || !result.Comparisons[0].OriginalTreatedDifferently {
+        t.Errorf("Expected original replay treated differently, got %v", result.Comparisons[0])
+    }
+
+    // Test no differentiation
+    result = AnalyzeTomography([]TestResult{
+        {ServerHostname: "a", Result: noDifferentiationStatus},
+        {ServerHostname: "b", Result: noDifferentiationStatus},
+    })
+    if result.Verdict != noPathsVerdict || result.Localization != "" {
+        t.Errorf("Expected %s without a localization hint, got %v", noPathsVerdict, result)
+    }
+
+    // Test blocked and modified paths count as differentiated paths
+    result = AnalyzeTomography([]TestResult{
+        {ServerHostname: "a", Result: blockedStatus},
+        {ServerHostname: "b", Result: noDifferentiationStatus},
+        {ServerHostname: "c", Result: contentModifiedStatus},
+    })
+    if result.Verdict != somePathsVerdict || !strings.Contains(result.Localization, "a, c") {
+        t.Errorf("Expected %s on a and c, got %v", somePathsVerdict, result)
+    }
+    if len(result.BlockedPaths) != 1 || result.BlockedPaths[0] != "a" || len(result.ContentModifiedPaths) != 1 || result.ContentModifiedPaths[0] != "c" || len(result.DifferentiatedPaths) != 0 {
+        t.Errorf("Expected a blocked and c modified, got %v", result)
+    }
+    result = AnalyzeTomography([]TestResult{
+        {ServerHostname: "a", Result: blockedStatus},
+        {ServerHostname: "b", Result: differentiationStatus},
+    })
+    if result.Verdict != allPathsVerdict {
+        t.Errorf("Expected %s, got %s", allPathsVerdict, result.Verdict)
+    }
+}
+
+func TestRunIPv6(t *testing.T) {
+    listener, err := net.Listen("tcp", "[::1]:0")
+    if err != nil {
+        t.Skipf("IPv6 loopback is not available: %v", err)
+    }
+    listener.Close()
+
+    ks2Result := &testdata.KS2Result{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4}
+    fakes, to, tlsConfig := startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result}, fake: fakeserver.Config{ListenIP: "::1"}})
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].ServerIP != "::1" || testResults[0].IPFamily != network.IPv6 {
+        t.Errorf("Expected ::1 over %s, got %s over %s", network.IPv6, testResults[0].ServerIP, testResults[0].IPFamily)
+    }
+    clientIDs := fakes[0].ClientIDs()
+    if len(clientIDs) != 1 || !strings.Contains(clientIDs[0], ";::1;") {
+        t.Errorf("Expected public IP ::1 in client IDs, got %v", clientIDs)
+    }
+}
+
+func TestRunAddressFallback(t *testing.T) {
+    ks2Result := &testdata.KS2Result{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4}
+    _, to, tlsConfig := startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result}})
+
+    // nothing listens on 127.0.0.2, so the side channel should fall back to 127.0.0.1
+    srv := to.servers[0]
+    srv.IPs = append([]string{"127.0.0.2"}, srv.IPs...)
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].ServerIP != fakeserver.DefaultListenIP || testResults[0].IPFamily != network.IPv4 {
+        t.Errorf("Expected %s over %s, got %s over %s", fakeserver.DefaultListenIP, network.IPv4, testResults[0].ServerIP, testResults[0].IPFamily)
+    }
+}
+
+func TestCompareIPFamilies(t *testing.T) {
+    ipv4Results := []TestResult{
+        {ServerHostname: "a", Result: noDifferentiationStatus, KS2Result: testdata.KS2Result{OriginalAvgThroughput: 8, RandomAvgThroughput: 8}},
+    }
+    ipv6Results := []TestResult{
+        {ServerHostname: "a", Result: differentiationStatus, KS2Result: testdata.KS2Result{OriginalAvgThroughput: 2, RandomAvgThroughput: 7.5}},
+    }
+
+    CompareIPFamilies(ipv4Results, 3, ipv6Results)
+    comparison := ipv6Results[0].IPFamilyComparison
+    if comparison == nil {
+        t.Fatal("Expected comparison, got nil")
+    }
+    expected := IPFamilyComparison{
+        IPv4TestID: 3,
+        IPv4Result: noDifferentiationStatus,
+        IPv6Result: differentiationStatus,
+        OriginalThroughputDelta: -6,
+        RandomThroughputDelta: -0.5,
+    }
+    if *comparison != expected {
+        t.Errorf("Expected %v, got %v", expected, *comparison)
+    }
+    if ipv4Results[0].IPFamilyComparison != nil {
+        t.Errorf("Expected no comparison in IPv4 results, got %v", ipv4Results[0].IPFamilyComparison)
+    }
+}
+
+func TestRunResponseCheck(t *testing.T) {
+    ks2Results := []*testdata.KS2Result{{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4}}
+    zeroHash := sha1.Sum(make([]byte, 50000)) // the fake server responds with zeros
+
+    // Test responses that match the replay file
+    _, to, tlsConfig := startServers(t, serverOptions{ks2Results: ks2Results, responseHash: hex.EncodeToString(zeroHash[:])})
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].Result != noDifferentiationStatus {
+        t.Errorf("Expected %s, got %s", noDifferentiationStatus, testResults[0].Result)
+    }
+    responseCheck := testResults[0].Replays[0].ResponseCheck
+    if responseCheck == nil || responseCheck.Status != network.ResponsesOK || responseCheck.CompleteResponses != 6 {
+        t.Errorf("Expected 6 complete responses, got %v", responseCheck)
+    }
+
+    // Test responses that were rewritten
+    _, to, tlsConfig = startServers(t, serverOptions{ks2Results: ks2Results, responseHash: strings.Repeat("ab", 20)})
+    testResults, err = to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].Result != contentModifiedStatus {
+        t.Errorf("Expected %s, got %s", contentModifiedStatus, testResults[0].Result)
+    }
+
+    // Test responses that were cut off
+    _, to, tlsConfig = startServers(t, serverOptions{ks2Results: ks2Results, fake: fakeserver.Config{TCPResponseLimit: 120000}})
+    testResults, err = to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].Result != blockedStatus {
+        t.Errorf("Expected %s, got %s", blockedStatus, testResults[0].Result)
+    }
+    responseCheck = testResults[0].Replays[0].ResponseCheck
+    if responseCheck == nil || responseCheck.ReceivedBytes != 120000 || responseCheck.CompleteResponses != 2 {
+        t.Errorf("Expected 120000 bytes in 2 complete responses, got %v", responseCheck)
+    }
+}
+
+func TestRunThrottledResponses(t *testing.T) {
+    // like a video replay, the first response takes longer than TCPResponseTimeout to arrive under
+    // the throttle, and the next packet waits for it
+    replaysDir := t.TempDir()
+    original := writeTCPReplayResponses(t, replaysDir, "Video.json", "Video-01012024", "", []int{1100000, 1000})
+    random := writeTCPReplayResponses(t, replaysDir, "VideoRandom.json", "VideoRandom-01012024", "", []int{10000, 1000})
+    ks2Results := []*testdata.KS2Result{{Area0var: 0.8, KS2pVal: 0.001, OriginalAvgThroughput: 0.8, RandomAvgThroughput: 8}}
+    _, to, tlsConfig := startServers(t, serverOptions{ks2Results: ks2Results, replaysDir: replaysDir, original: original, random: random, fake: fakeserver.Config{TCPThrottle: 100000}})
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].Result != differentiationStatus {
+        t.Errorf("Expected %s, got %s", differentiationStatus, testResults[0].Result)
+    }
+    replayResult := testResults[0].Replays[0]
+    if replayResult.ElapsedTime < network.TCPResponseTimeout {
+        t.Errorf("Expected the replay to wait for the throttled response, ran for %s", replayResult.ElapsedTime)
+    }
+    responseCheck := replayResult.ResponseCheck
+    if responseCheck == nil || responseCheck.Status != network.ResponsesOK || responseCheck.CompleteResponses != 2 {
+        t.Errorf("Expected both responses to arrive, got %v", responseCheck)
+    }
+}
+
+func TestRunUDPFlows(t *testing.T) {
+    replaysDir := t.TempDir()
+    original := writeUDPReplay(t, replaysDir, "Video.json", "Video-01012024")
+    random := writeUDPReplay(t, replaysDir, "VideoRandom.json", "VideoRandom-01012024")
+    ks2Results := []*testdata.KS2Result{{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4}}
+    _, to, tlsConfig := startServers(t, serverOptions{ks2Results: ks2Results, replaysDir: replaysDir, original: original, random: random})
+
+    // Test flows are ordered by the client ports in the replay file
+    if len(original.UDPFlows) != 2 || original.UDPFlows[0].CSPair.ClientPort != 50002 || original.UDPFlows[1].CSPair.ClientPort != 50001 {
+        t.Fatalf("Unexpected flows: %v", original.UDPFlows)
+    }
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].Result != noDifferentiationStatus {
+        t.Errorf("Expected %s, got %s", noDifferentiationStatus, testResults[0].Result)
+    }
+    for _, replayResult := range testResults[0].Replays {
+        if replayResult.ResponseCheck != nil {
+            t.Errorf("Expected no response check for UDP replay, got %v", replayResult.ResponseCheck)
+        }
+        if len(replayResult.Flows) != 2 {
+            t.Fatalf("Expected 2 flows, got %d", len(replayResult.Flows))
+        }
+        if replayResult.Flows[0].ClientPort != 50002 || replayResult.Flows[1].ClientPort != 50001 {
+            t.Errorf("Unexpected flow ports: %v", replayResult.Flows)
+        }
+        if replayResult.AverageThroughput <= 0 {
+            t.Errorf("Expected positive throughput, got %f", replayResult.AverageThroughput)
+        }
+        for _, flowResult := range replayResult.Flows {
+            if flowResult.AverageThroughput <= 0 || flowResult.AverageThroughput > replayResult.AverageThroughput {
+                t.Errorf("Expected flow throughput between 0 and %f, got %f", replayResult.AverageThroughput, flowResult.AverageThroughput)
+            }
+        }
+    }
+}
+
+func TestRunServerResults(t *testing.T) {
+    ks2Result := &testdata.KS2Result{Area0var: 0.8, KS2pVal: 0.001, OriginalAvgThroughput: 1, RandomAvgThroughput: 8}
+    _, to, tlsConfig := startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result}, confirmationReplays: true, fetchResults: true})
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    // Test the analysis is the one of the confirmation replays, which ran as test 8
+    analysis := testResults[0].ServerAnalysis
+    if analysis == nil {
+        t.Fatalf("Expected a server analysis")
+    }
+    if analysis.UserID != testUserID || analysis.HistoryCount != 8 || analysis.TestID != int(Random) || analysis.ReplayName != "VideoRandom-01012024" {
+        t.Errorf("Unexpected server analysis: %+v", analysis)
+    }
+    if analysis.KS2Result() != *ks2Result {
+        t.Errorf("Expected %v, got %v", *ks2Result, analysis.KS2Result())
+    }
+    if _, ok := analysis.Extra["xput_max_original"]; !ok {
+        t.Errorf("Expected unknown stats to be kept, got %v", analysis.Extra)
+    }
+
+    // Test the history has both the first test and the confirmation test
+    history := testResults[0].ServerHistory
+    if len(history) != 2 || history[0].HistoryCount != 7 || history[1].HistoryCount != 8 {
+        t.Errorf("Unexpected server history: %+v", history)
+    }
+}
+
+func TestRunResultsRecovery(t *testing.T) {
+    ks2Result := &testdata.KS2Result{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4}
+    // the side channel drops before the analysis comes back, and the analysis is not ready the
+    // first time it is asked for
+    fakes, to, tlsConfig := startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result}, fetchResults: true, fake: fakeserver.Config{DropAnalysis: true, ResultsNotReady: 1}})
+    fake := fakes[0]
+
+    testResults, err := to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if testResults[0].Result != noDifferentiationStatus || testResults[0].KS2Result != *ks2Result {
+        t.Errorf("Expected the recovered analysis, got %s, %v", testResults[0].Result, testResults[0].KS2Result)
+    }
+    // 2 requests to recover the analysis, then the analysis and the history for the output
+    if fake.NumResultsRequests() != 4 {
+        t.Errorf("Expected 4 results requests, got %d", fake.NumResultsRequests())
+    }
+
+    // without the results endpoint, the test fails
+    _, to, tlsConfig = startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result}, fake: fakeserver.Config{DropAnalysis: true}})
+    _, err = to.Run(context.Background(), testUserID, "4.0", tlsConfig)
+    if err == nil {
+        t.Errorf("Expected an error when the analysis is dropped")
+    }
+}
+
+func TestRunInterruptedDuringAnalysis(t *testing.T) {
+    ks2Result := &testdata.KS2Result{Area0var: 0.1, KS2pVal: 0.5, OriginalAvgThroughput: 4, RandomAvgThroughput: 4}
+    // the side channel drops before the analysis comes back, and the results endpoint would keep
+    // the test waiting for all the recovery tries
+    fakes, to, tlsConfig := startServers(t, serverOptions{ks2Results: []*testdata.KS2Result{ks2Result}, fetchResults: true, fake: fakeserver.Config{DropAnalysis: true, ResultsNotReady: 100}})
+    fake := fakes[0]
+
+    // stop the test once both replays have been sent for analysis
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go func() {
+        for len(fake.ReplayReports()) < 2 {
+            time.Sleep(10 * time.Millisecond)
+        }
+        time.Sleep(100 * time.Millisecond)
+        cancel()
+    }()
+
+    start := time.Now()
+    testResults, err := to.Run(ctx, testUserID, "4.0", tlsConfig)
+    if !errors.Is(err, ErrInterrupted) {
+        t.Fatalf("Expected %v, got %v", ErrInterrupted, err)
+    }
+    if len(testResults) != 1 || testResults[0].Result != interruptedStatus {
+        t.Errorf("Expected a partial result, got %v", testResults)
+    }
+    // the replays take under 2 seconds, and the interruption should not wait for the results endpoint
+    if elapsed := time.Since(start); elapsed > 4 * time.Second {
+        t.Errorf("Expected the test to stop promptly, took %v", elapsed)
+    }
+    if fake.NumResultsRequests() >= resultsRecoveryTries {
+        t.Errorf("Expected the results recovery to stop, got %d requests", fake.NumResultsRequests())
+    }
+}
@@ -0,0 +1,149 @@
+package testorchestrator
+
+import (
+    "fmt"
+    "strings"
+
+    "wehe-cmdline-client/internal/stats"
+)
+
+const (
+    allPathsVerdict = "Differentiation Detected On All Paths"
+    somePathsVerdict = "Differentiation Detected On Some Paths"
+    noPathsVerdict = "No Differentiation On Any Path"
+    inconclusivePathsVerdict = "Results Inconclusive"
+)
+
+// The combined analysis of a test that ran concurrently on more than one server (tomography).
+// Each server is reached over a different network path, so comparing the results of the paths
+// hints at where the differentiation takes place.
+type TomographyResult struct {
+    Verdict string // whether differentiation was detected on all, some, or none of the paths
+    NumPaths int // number of servers the test ran on
+    DifferentiatedPaths []string // hostnames of the servers where differentiation was detected
+    BlockedPaths []string // hostnames of the servers whose responses stopped arriving before the replay finished
+    ContentModifiedPaths []string // hostnames of the servers whose responses did not match the replay file
+    InconclusivePaths []string // hostnames of the servers where the results were inconclusive
+    Localization string // where the differentiation likely takes place; empty if there is no differentiation
+    Comparisons []PathComparison // comparisons of each pair of paths
+}
+
+// A comparison of the throughputs collected by the client on two paths.
+type PathComparison struct {
+    ServerA string // hostname of the first server
+    ServerB string // hostname of the second server
+    OriginalThroughputRatio float64 // average throughput of the original replay on ServerA divided by the one on ServerB
+    OriginalKS2PValue float64 // KS 2 p-value comparing the original replay throughputs of the two paths
+    RandomThroughputRatio float64 // average throughput of the random replay on ServerA divided by the one on ServerB
+    RandomKS2PValue float64 // KS 2 p-value comparing the random replay throughputs of the two paths
+    OriginalTreatedDifferently bool // true if the original replay throughputs differ between the two paths while the random replay throughputs do not
+}
+
+// Combines the results of a test on multiple servers.
+// testResults: the results of the test for each server
+// Returns the combined analysis, or nil if the test ran on fewer than two servers or did not finish
+func AnalyzeTomography(testResults []TestResult) *TomographyResult {
+    if len(testResults) < 2 {
+        return nil
+    }
+    for _, testResult := range testResults {
+        if testResult.Result == interruptedStatus {
+            return nil
+        }
+    }
+
+    tomography := &TomographyResult{
+        NumPaths: len(testResults),
+        DifferentiatedPaths: []string{},
+        BlockedPaths: []string{},
+        ContentModifiedPaths: []string{},
+        InconclusivePaths: []string{},
+        Comparisons: []PathComparison{},
+    }
+    // blocking and modifying the content of the traffic are the strongest forms of differentiation,
+    // so those paths count as differentiated paths in the verdict
+    var affectedPaths []string
+    for _, testResult := range testResults {
+        switch testResult.Result {
+        case differentiationStatus:
+            tomography.DifferentiatedPaths = append(tomography.DifferentiatedPaths, testResult.ServerHostname)
+        case blockedStatus:
+            tomography.BlockedPaths = append(tomography.BlockedPaths, testResult.ServerHostname)
+        case contentModifiedStatus:
+            tomography.ContentModifiedPaths = append(tomography.ContentModifiedPaths, testResult.ServerHostname)
+        case inconclusiveStatus:
+            tomography.InconclusivePaths = append(tomography.InconclusivePaths, testResult.ServerHostname)
+            continue
+        default:
+            continue
+        }
+        affectedPaths = append(affectedPaths, testResult.ServerHostname)
+    }
+
+    switch {
+    case len(affectedPaths) == tomography.NumPaths:
+        tomography.Verdict = allPathsVerdict
+        tomography.Localization = "Differentiation affects every path, so it likely takes place near the client, such as in the client's ISP."
+    case len(affectedPaths) > 0:
+        tomography.Verdict = somePathsVerdict
+        tomography.Localization = fmt.Sprintf("Differentiation affects only the paths to %s, so it likely takes place on those paths rather than near the client.", strings.Join(affectedPaths, ", "))
+    case len(tomography.InconclusivePaths) > 0:
+        tomography.Verdict = inconclusivePathsVerdict
+    default:
+        tomography.Verdict = noPathsVerdict
+    }
+
+    for i := 0; i < len(testResults); i++ {
+        for j := i + 1; j < len(testResults); j++ {
+            tomography.Comparisons = append(tomography.Comparisons, comparePaths(testResults[i], testResults[j]))
+        }
+    }
+    return tomography
+}
+
+// Compares the throughputs of the initial original and random replays on two paths.
+// a: the result of the test on the first server
+// b: the result of the test on the second server
+// Returns the comparison
+func comparePaths(a TestResult, b TestResult) PathComparison {
+    originalA := initialThroughputs(a.Replays, Original)
+    originalB := initialThroughputs(b.Replays, Original)
+    randomA := initialThroughputs(a.Replays, Random)
+    randomB := initialThroughputs(b.Replays, Random)
+
+    _, originalPValue := stats.KS2Test(originalA, originalB)
+    _, randomPValue := stats.KS2Test(randomA, randomB)
+    return PathComparison{
+        ServerA: a.ServerHostname,
+        ServerB: b.ServerHostname,
+        OriginalThroughputRatio: ratio(stats.Average(originalA), stats.Average(originalB)),
+        OriginalKS2PValue: originalPValue,
+        RandomThroughputRatio: ratio(stats.Average(randomA), stats.Average(randomB)),
+        RandomKS2PValue: randomPValue,
+        OriginalTreatedDifferently: originalPValue < a.KS2PValueThreshold && randomPValue >= a.KS2PValueThreshold,
+    }
+}
+
+// Gets the throughput samples of a replay that was not run to confirm differentiation.
+// replays: the replays that ran on a server
+// replayType: the type of replay to get the samples of
+// Returns the throughput samples
+func initialThroughputs(replays []ReplayResult, replayType ReplayType) []float64 {
+    for _, replay := range replays {
+        if replay.ReplayType == replayType && !replay.IsConfirmation {
+            return replay.Throughputs
+        }
+    }
+    return nil
+}
+
+// Divides two throughputs.
+// a: the numerator
+// b: the denominator
+// Returns a divided by b, or 0 if b is 0
+func ratio(a float64, b float64) float64 {
+    if b == 0 {
+        return 0
+    }
+    return a / b
+}
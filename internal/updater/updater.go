@@ -0,0 +1,375 @@
+// Syncs the tests list and the replay files with the ones hosted on an update server.
+package updater
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "wehe-cmdline-client/internal/serverhandler"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    testsListFilename = "tests_list.json" // name of the tests list on the update server
+    replaysURLDir = "replays" // directory on the update server that contains the replay files
+    backupSuffix = ".old" // added to the replays directory while it is being replaced
+)
+
+// renames files and directories; replaced in tests to make the swap fail
+var rename = os.Rename
+
+// The differences between the old and new tests lists.
+type Report struct {
+    Added []string // tests that are in the new tests list but not the old one
+    Removed []string // tests that are in the old tests list but not the new one
+    Changed []string // tests that are in both lists but whose info or replay files changed
+    Kept []string // imported tests that were carried over from the old tests list
+    Warnings []string // why imported tests of the old tests list were dropped
+    NumReplays int // number of replay files that were downloaded
+}
+
+// Downloads the tests list and all the replay files it references, makes sure each replay can be
+// parsed, and then replaces the tests list and replays directory on disk with the new ones. Tests
+// that were imported from captures are carried over along with their replays, unless the new
+// tests list has a test or replay file with the same name. A warning is reported for each imported
+// test that is dropped.
+// updateURL: the base URL of the update server; tests_list.json and replays/ should be under it
+// testsConfigFile: path to the tests list on disk to replace
+// replaysDir: path to the replays directory on disk to replace
+// Returns a report of the tests that were added, removed, or changed, or any errors
+func Run(updateURL string, testsConfigFile string, replaysDir string) (Report, error) {
+    baseURL, err := url.Parse(updateURL)
+    if err != nil {
+        return Report{}, fmt.Errorf("Invalid update URL %s: %v", updateURL, err)
+    }
+
+    // the old tests list is only used to report the differences, so it is fine if it is missing, but
+    // a tests list that cannot be read would make the report wrong
+    oldTests, oldEntries, err := testdata.LoadTestEntries(testsConfigFile)
+    if err != nil && !os.IsNotExist(err) {
+        return Report{}, fmt.Errorf("Unable to read the current tests list %s: %v. Fix or remove it, then update again.", testsConfigFile, err)
+    }
+
+    testsListData, err := serverhandler.HTTPGet(resolveURL(baseURL, testsListFilename))
+    if err != nil {
+        return Report{}, fmt.Errorf("Unable to download tests list: %v", err)
+    }
+    // the entries are written to disk as they were downloaded so that fields this client does not
+    // know about are not lost
+    var newEntries []json.RawMessage
+    err = json.Unmarshal(testsListData, &newEntries)
+    if err != nil {
+        return Report{}, fmt.Errorf("Downloaded tests list is invalid: %v", err)
+    }
+    newTests := make([]testdata.Test, len(newEntries))
+    for i, entry := range newEntries {
+        err = json.Unmarshal(entry, &newTests[i])
+        if err != nil {
+            return Report{}, fmt.Errorf("Downloaded tests list is invalid: %v", err)
+        }
+    }
+    if len(newTests) == 0 {
+        return Report{}, fmt.Errorf("Downloaded tests list does not contain any tests.")
+    }
+
+    // download the new replays into a temporary directory next to the current replays directory
+    // so that the directories can be swapped with a rename
+    replaysDir = filepath.Clean(replaysDir)
+    tmpReplaysDir, err := os.MkdirTemp(filepath.Dir(replaysDir), "." + filepath.Base(replaysDir) + "-update-")
+    if err != nil {
+        return Report{}, err
+    }
+    defer os.RemoveAll(tmpReplaysDir)
+
+    replayHashes := make(map[string][sha256.Size]byte)
+    for _, replayFile := range getReplayFiles(newTests) {
+        if replayFile != filepath.Base(replayFile) {
+            return Report{}, fmt.Errorf("Replay file name %s in downloaded tests list is invalid.", replayFile)
+        }
+        data, err := serverhandler.HTTPGet(resolveURL(baseURL, path.Join(replaysURLDir, replayFile)))
+        if err != nil {
+            return Report{}, fmt.Errorf("Unable to download replay %s: %v", replayFile, err)
+        }
+        tmpReplayFile := filepath.Join(tmpReplaysDir, replayFile)
+        err = os.WriteFile(tmpReplayFile, data, 0644)
+        if err != nil {
+            return Report{}, err
+        }
+        _, err = testdata.ParseReplayJSON(tmpReplayFile)
+        if err != nil {
+            return Report{}, fmt.Errorf("Downloaded replay %s is invalid: %v", replayFile, err)
+        }
+        replayHashes[replayFile] = sha256.Sum256(data)
+    }
+
+    numReplays := len(replayHashes)
+    keptIndexes, warnings, err := keepImportedTests(oldTests, newTests, replaysDir, tmpReplaysDir, replayHashes)
+    if err != nil {
+        return Report{}, err
+    }
+    var keptTests []testdata.Test
+    for _, i := range keptIndexes {
+        keptTests = append(keptTests, oldTests[i])
+        newEntries = append(newEntries, oldEntries[i])
+    }
+    newTests = append(newTests, keptTests...)
+
+    report := compareTests(oldTests, newTests, replaysDir, replayHashes)
+    report.NumReplays = numReplays
+    report.Warnings = warnings
+    for _, test := range keptTests {
+        report.Kept = append(report.Kept, test.Selector())
+    }
+
+    // everything downloaded is valid, so the old files can now be replaced
+    testsListData, err = json.MarshalIndent(newEntries, "", "  ")
+    if err != nil {
+        return Report{}, err
+    }
+    tmpTestsListFile, err := writeTempFile(testsConfigFile, append(testsListData, '\n'))
+    if err != nil {
+        return Report{}, err
+    }
+    defer os.Remove(tmpTestsListFile)
+
+    // the old replays are kept until the tests list is replaced, so that the replays and the tests
+    // list are either both updated or both left as they were
+    restoreReplays, removeOldReplays, err := swapDir(replaysDir, tmpReplaysDir)
+    if err != nil {
+        return Report{}, err
+    }
+    err = rename(tmpTestsListFile, testsConfigFile)
+    if err != nil {
+        restoreErr := restoreReplays()
+        if restoreErr != nil {
+            return Report{}, fmt.Errorf("Unable to replace the tests list: %v. The old replays could not be restored from %s: %v", err, replaysDir + backupSuffix, restoreErr)
+        }
+        return Report{}, fmt.Errorf("Unable to replace the tests list, so nothing was updated: %v", err)
+    }
+    err = removeOldReplays()
+    if err != nil {
+        return Report{}, fmt.Errorf("Tests were updated, but the old replays in %s could not be removed: %v", replaysDir + backupSuffix, err)
+    }
+    return report, nil
+}
+
+// Copies the imported tests of the old tests list and their replays so that an update does not
+// remove them. An imported test is dropped if the new tests list has a test with the same selector
+// or a replay file with the same name, or if its replays are missing.
+// oldTests: the tests list that is currently on disk
+// newTests: the tests list that was downloaded
+// replaysDir: the replays directory currently on disk
+// newReplaysDir: the directory that the downloaded replays were written to
+// newReplayHashes: the SHA-256 hashes of the downloaded replay files; the hashes of the copied
+//     replays are added to it
+// Returns the indexes in oldTests of the imported tests that were kept, a warning for each imported
+//     test that was dropped, or any errors
+func keepImportedTests(oldTests []testdata.Test, newTests []testdata.Test, replaysDir string, newReplaysDir string, newReplayHashes map[string][sha256.Size]byte) ([]int, []string, error) {
+    newKeys := getTestKeys(newTests)
+    var keptIndexes []int
+    var warnings []string
+    for i, test := range oldTests {
+        if !test.Imported {
+            continue
+        }
+        if _, ok := newKeys[test.Selector()]; ok {
+            warnings = append(warnings, fmt.Sprintf("Imported test %s was removed because the downloaded tests list has a test with the same name. Import it again with a different name to keep it.", test.Selector()))
+            continue
+        }
+        replays := make(map[string][]byte)
+        warning := ""
+        for _, replayFile := range []string{test.DataFile, test.RandomDataFile} {
+            if _, ok := newReplayHashes[replayFile]; ok {
+                warning = fmt.Sprintf("Imported test %s was removed because the downloaded tests list has a replay file named %s. Import it again with a different name to keep it.", test.Selector(), replayFile)
+                break
+            }
+            if replayFile != filepath.Base(replayFile) {
+                warning = fmt.Sprintf("Imported test %s was removed because its replay file name %s is invalid.", test.Selector(), replayFile)
+                break
+            }
+            data, err := os.ReadFile(filepath.Join(replaysDir, replayFile))
+            if err != nil {
+                warning = fmt.Sprintf("Imported test %s was removed because its replay file could not be read: %v", test.Selector(), err)
+                break
+            }
+            replays[replayFile] = data
+        }
+        if warning != "" {
+            warnings = append(warnings, warning)
+            continue
+        }
+        for replayFile, data := range replays {
+            err := os.WriteFile(filepath.Join(newReplaysDir, replayFile), data, 0644)
+            if err != nil {
+                return nil, nil, err
+            }
+            newReplayHashes[replayFile] = sha256.Sum256(data)
+        }
+        keptIndexes = append(keptIndexes, i)
+    }
+    return keptIndexes, warnings, nil
+}
+
+// Resolves a path relative to the base URL of the update server.
+// baseURL: the base URL of the update server
+// ref: the path relative to the base URL
+// Returns the full URL
+func resolveURL(baseURL *url.URL, ref string) string {
+    base := *baseURL
+    if !strings.HasSuffix(base.Path, "/") {
+        base.Path += "/"
+    }
+    return base.ResolveReference(&url.URL{Path: ref}).String()
+}
+
+// Gets the names of the replay files that are needed by a list of tests.
+// tests: the list of tests
+// Returns the sorted list of unique replay file names
+func getReplayFiles(tests []testdata.Test) []string {
+    replayFilesMap := make(map[string]bool)
+    for _, test := range tests {
+        replayFilesMap[test.DataFile] = true
+        replayFilesMap[test.RandomDataFile] = true
+    }
+    var replayFiles []string
+    for replayFile := range replayFilesMap {
+        replayFiles = append(replayFiles, replayFile)
+    }
+    sort.Strings(replayFiles)
+    return replayFiles
+}
+
+// Gets a key for each test in a tests list that can be used to match the same test across tests
+// lists. Tests are matched by image name; if tests share an image name, the data file is used to
+// tell them apart.
+// tests: the list of tests
+// Returns a map of keys to the tests
+func getTestKeys(tests []testdata.Test) map[string]testdata.Test {
+    keys := make(map[string]testdata.Test)
+    for _, test := range tests {
+        keys[test.Selector()] = test
+    }
+    return keys
+}
+
+// Determines which tests were added, removed, or changed.
+// oldTests: the tests list that is currently on disk
+// newTests: the tests list that was downloaded
+// replaysDir: the replays directory currently on disk
+// newReplayHashes: the SHA-256 hashes of the downloaded replay files
+// Returns a report of the differences
+func compareTests(oldTests []testdata.Test, newTests []testdata.Test, replaysDir string, newReplayHashes map[string][sha256.Size]byte) Report {
+    oldKeys := getTestKeys(oldTests)
+    newKeys := getTestKeys(newTests)
+
+    report := Report{}
+    for key, newTest := range newKeys {
+        oldTest, ok := oldKeys[key]
+        if !ok {
+            report.Added = append(report.Added, key)
+        } else if oldTest.Name != newTest.Name || oldTest.Time != newTest.Time ||
+            oldTest.DataFile != newTest.DataFile || oldTest.RandomDataFile != newTest.RandomDataFile ||
+            isReplayChanged(replaysDir, newTest.DataFile, newReplayHashes) ||
+            isReplayChanged(replaysDir, newTest.RandomDataFile, newReplayHashes) {
+            report.Changed = append(report.Changed, key)
+        }
+    }
+    for key := range oldKeys {
+        if _, ok := newKeys[key]; !ok {
+            report.Removed = append(report.Removed, key)
+        }
+    }
+    sort.Strings(report.Added)
+    sort.Strings(report.Removed)
+    sort.Strings(report.Changed)
+    return report
+}
+
+// Checks if a replay file on disk is different from the downloaded one.
+// replaysDir: the replays directory currently on disk
+// replayFile: name of the replay file
+// newReplayHashes: the SHA-256 hashes of the downloaded replay files
+// Returns true if the replay file is missing on disk or its contents differ; false otherwise
+func isReplayChanged(replaysDir string, replayFile string, newReplayHashes map[string][sha256.Size]byte) bool {
+    oldData, err := os.ReadFile(filepath.Join(replaysDir, replayFile))
+    if err != nil {
+        return true
+    }
+    oldHash := sha256.Sum256(oldData)
+    newHash := newReplayHashes[replayFile]
+    return !bytes.Equal(oldHash[:], newHash[:])
+}
+
+// Writes data to a temporary file in the same directory as the file it will replace.
+// filename: path of the file that the temporary file will replace
+// data: the data to write
+// Returns the path to the temporary file or any errors
+func writeTempFile(filename string, data []byte) (string, error) {
+    tmpFile, err := os.CreateTemp(filepath.Dir(filename), "." + filepath.Base(filename) + "-update-")
+    if err != nil {
+        return "", err
+    }
+    _, err = tmpFile.Write(data)
+    if err == nil {
+        err = tmpFile.Sync()
+    }
+    closeErr := tmpFile.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmpFile.Name())
+        return "", err
+    }
+    return tmpFile.Name(), nil
+}
+
+// Moves a new directory into the place of a directory. The old directory is moved aside to a
+// backup first, so that it can be restored until the backup is removed.
+// dir: the directory to replace
+// newDir: the directory to move into place
+// Returns a function that puts the old directory back, a function that removes the backup of the old
+//     directory, or any errors; the old directory is put back if an error is returned
+func swapDir(dir string, newDir string) (func() error, func() error, error) {
+    backupDir := dir + backupSuffix
+    err := os.RemoveAll(backupDir)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    hasOldDir := true
+    err = rename(dir, backupDir)
+    if os.IsNotExist(err) {
+        hasOldDir = false
+    } else if err != nil {
+        return nil, nil, err
+    }
+
+    restore := func() error {
+        err := os.RemoveAll(dir)
+        if err != nil || !hasOldDir {
+            return err
+        }
+        return rename(backupDir, dir)
+    }
+    removeBackup := func() error {
+        return os.RemoveAll(backupDir)
+    }
+
+    err = rename(newDir, dir)
+    if err != nil {
+        if hasOldDir {
+            rename(backupDir, dir)
+        }
+        return nil, nil, err
+    }
+    return restore, removeBackup, nil
+}
@@ -0,0 +1,290 @@
+package updater
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const testCSPair = "010.000.000.001.50000-010.000.000.002.00080"
+
+// Makes a legacy replay file with one TCP packet.
+func replayJSON(replayName string, payload string) string {
+    return fmt.Sprintf(`[[{"c_s_pair": "%s", "timestamp": 0, "payload": "%s", "response_len": 0, "response_hash": null}], [], ["%s"], "%s"]`, testCSPair, payload, testCSPair, replayName)
+}
+
+// Starts an update server that serves a tests list and replay files. The tests are sent as JSON, so
+// they can be a []testdata.Test or the raw entries of a tests list.
+func startUpdateServer(t *testing.T, tests interface{}, replays map[string]string) *httptest.Server {
+    testsList, err := json.Marshal(tests)
+    if err != nil {
+        t.Fatal(err)
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/wehe/tests_list.json", func(w http.ResponseWriter, r *http.Request) {
+        w.Write(testsList)
+    })
+    mux.HandleFunc("/wehe/replays/", func(w http.ResponseWriter, r *http.Request) {
+        replay, ok := replays[strings.TrimPrefix(r.URL.Path, "/wehe/replays/")]
+        if !ok {
+            http.NotFound(w, r)
+            return
+        }
+        w.Write([]byte(replay))
+    })
+    server := httptest.NewServer(mux)
+    t.Cleanup(server.Close)
+    return server
+}
+
+// Writes the tests list and replays directory that are on disk before an update.
+func writeOldFiles(t *testing.T, dir string, tests []testdata.Test) (string, string) {
+    testsConfigFile := filepath.Join(dir, "tests_list.json")
+    replaysDir := filepath.Join(dir, "replays")
+    data, err := json.Marshal(tests)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(testsConfigFile, data, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.MkdirAll(replaysDir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, replayFile := range []string{"video.json", "videoRandom.json", "music.json", "musicRandom.json"} {
+        err = os.WriteFile(filepath.Join(replaysDir, replayFile), []byte(replayJSON("Old", "00")), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    return testsConfigFile, replaysDir
+}
+
+// Checks that the files on disk were left as they were before a failed update.
+func checkUnchanged(t *testing.T, dir string, testsConfigFile string, replaysDir string, oldTests []testdata.Test) {
+    tests, err := testdata.LoadTests(testsConfigFile)
+    if err != nil || len(tests) != len(oldTests) {
+        t.Errorf("Expected the old tests list, got %v, %v", tests, err)
+    }
+    data, err := os.ReadFile(filepath.Join(replaysDir, "music.json"))
+    if err != nil || string(data) != replayJSON("Old", "00") {
+        t.Errorf("Expected the old replays, got %s, %v", data, err)
+    }
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 2 {
+        t.Errorf("Expected temporary files to be removed, got %v", entries)
+    }
+}
+
+var (
+    videoTest = testdata.Test{Name: "Video", Time: 10, Image: "video", DataFile: "video.json", RandomDataFile: "videoRandom.json", Category: testdata.CategoryVideo}
+    musicTest = testdata.Test{Name: "Music", Time: 10, Image: "music", DataFile: "music.json", RandomDataFile: "musicRandom.json", Category: testdata.CategoryMusic}
+    callTest = testdata.Test{Name: "Call", Time: 10, Image: "call", DataFile: "call.json", RandomDataFile: "callRandom.json", Category: testdata.CategoryConferencing}
+)
+
+func TestRun(t *testing.T) {
+    dir := t.TempDir()
+    testsConfigFile, replaysDir := writeOldFiles(t, dir, []testdata.Test{videoTest, musicTest})
+    server := startUpdateServer(t, []testdata.Test{videoTest, callTest}, map[string]string{
+        "video.json": replayJSON("Old", "00"),
+        "videoRandom.json": replayJSON("New", "ff"),
+        "call.json": replayJSON("Call", "01"),
+        "callRandom.json": replayJSON("CallRandom", "fe"),
+    })
+
+    report, err := Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if strings.Join(report.Added, ",") != "call" || strings.Join(report.Removed, ",") != "music" || strings.Join(report.Changed, ",") != "video" || report.NumReplays != 4 {
+        t.Errorf("Unexpected report: %+v", report)
+    }
+
+    tests, err := testdata.LoadTests(testsConfigFile)
+    if err != nil || len(tests) != 2 || tests[1].Image != "call" {
+        t.Errorf("Unexpected tests list: %v, %v", tests, err)
+    }
+    entries, err := os.ReadDir(replaysDir)
+    if err != nil || len(entries) != 4 {
+        t.Errorf("Unexpected replays: %v, %v", entries, err)
+    }
+    if _, err := os.Stat(replaysDir + backupSuffix); !os.IsNotExist(err) {
+        t.Errorf("Expected the old replays to be removed, got %v", err)
+    }
+}
+
+func TestRunImportedTests(t *testing.T) {
+    dir := t.TempDir()
+    importedTest := testdata.Test{Name: "App", Time: 10, Image: "app", DataFile: "app.json", RandomDataFile: "appRandom.json", Category: testdata.CategoryVideo, Imported: true}
+    importedCallTest := testdata.Test{Name: "My Call", Time: 10, Image: "call", DataFile: "myCall.json", RandomDataFile: "myCallRandom.json", Category: testdata.CategoryConferencing, Imported: true}
+    missingTest := testdata.Test{Name: "Missing", Time: 10, Image: "missing", DataFile: "missing.json", RandomDataFile: "missingRandom.json", Category: testdata.CategoryVideo, Imported: true}
+    reusedTest := testdata.Test{Name: "Reused", Time: 10, Image: "reused", DataFile: "reused.json", RandomDataFile: "call.json", Category: testdata.CategoryVideo, Imported: true}
+    testsConfigFile, replaysDir := writeOldFiles(t, dir, []testdata.Test{videoTest, importedTest, importedCallTest, missingTest, reusedTest})
+    for _, replayFile := range []string{"app.json", "appRandom.json", "myCall.json", "myCallRandom.json", "missing.json", "reused.json", "call.json"} {
+        err := os.WriteFile(filepath.Join(replaysDir, replayFile), []byte(replayJSON("Imported", "0a")), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    server := startUpdateServer(t, []testdata.Test{videoTest, callTest}, map[string]string{
+        "video.json": replayJSON("Old", "00"),
+        "videoRandom.json": replayJSON("Old", "00"),
+        "call.json": replayJSON("Call", "01"),
+        "callRandom.json": replayJSON("CallRandom", "fe"),
+    })
+
+    // the imported tests that have the same selector or a replay file name as a published test and
+    // the one whose replays are missing are dropped with a warning
+    report, err := Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if strings.Join(report.Kept, ",") != "app" || strings.Join(report.Changed, ",") != "call" || strings.Join(report.Removed, ",") != "missing,reused" || len(report.Added) != 0 || report.NumReplays != 4 {
+        t.Errorf("Unexpected report: %+v", report)
+    }
+    if len(report.Warnings) != 3 || !strings.Contains(report.Warnings[0], "call") || !strings.Contains(report.Warnings[1], "missing") || !strings.Contains(report.Warnings[2], "call.json") {
+        t.Errorf("Expected a warning for each dropped test, got %q", report.Warnings)
+    }
+    tests, err := testdata.LoadTests(testsConfigFile)
+    if err != nil || len(tests) != 3 || tests[2] != importedTest {
+        t.Errorf("Unexpected tests list: %+v, %v", tests, err)
+    }
+    data, err := os.ReadFile(filepath.Join(replaysDir, "appRandom.json"))
+    if err != nil || string(data) != replayJSON("Imported", "0a") {
+        t.Errorf("Expected the imported replays to be kept, got %s, %v", data, err)
+    }
+    if _, err := os.Stat(filepath.Join(replaysDir, "myCall.json")); !os.IsNotExist(err) {
+        t.Errorf("Expected the replays of the dropped test to be removed, got %v", err)
+    }
+
+    // updating again changes nothing
+    report, err = Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err != nil || strings.Join(report.Kept, ",") != "app" || len(report.Added) != 0 || len(report.Removed) != 0 || len(report.Changed) != 0 || len(report.Warnings) != 0 {
+        t.Errorf("Unexpected update: %+v, %v", report, err)
+    }
+}
+
+func TestRunUnknownFields(t *testing.T) {
+    dir := t.TempDir()
+    testsConfigFile, replaysDir := writeOldFiles(t, dir, nil)
+    err := os.WriteFile(testsConfigFile, []byte(`[{"name": "App", "image": "app", "datafile": "app.json", "randomdatafile": "appRandom.json", "category": "VIDEO", "imported": true, "color": "red"}]`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, replayFile := range []string{"app.json", "appRandom.json"} {
+        err = os.WriteFile(filepath.Join(replaysDir, replayFile), []byte(replayJSON("Imported", "0a")), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    server := startUpdateServer(t, []json.RawMessage{
+        json.RawMessage(`{"name": "Video", "image": "video", "datafile": "video.json", "randomdatafile": "videoRandom.json", "category": "VIDEO", "popularity": 3}`),
+    }, map[string]string{
+        "video.json": replayJSON("Video", "00"),
+        "videoRandom.json": replayJSON("VideoRandom", "ff"),
+    })
+
+    // fields that Test does not have are kept in both the downloaded and the imported tests
+    report, err := Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err != nil || strings.Join(report.Kept, ",") != "app" {
+        t.Fatalf("Unexpected update: %+v, %v", report, err)
+    }
+    data, err := os.ReadFile(testsConfigFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var entries []map[string]interface{}
+    err = json.Unmarshal(data, &entries)
+    if err != nil {
+        t.Fatalf("Unexpected tests list %s: %v", data, err)
+    }
+    if len(entries) != 2 || entries[0]["popularity"] != 3.0 || entries[1]["color"] != "red" {
+        t.Errorf("Expected unknown fields to be kept, got %s", data)
+    }
+}
+
+func TestRunInvalidReplay(t *testing.T) {
+    dir := t.TempDir()
+    oldTests := []testdata.Test{videoTest, musicTest}
+    testsConfigFile, replaysDir := writeOldFiles(t, dir, oldTests)
+    server := startUpdateServer(t, []testdata.Test{videoTest}, map[string]string{
+        "video.json": replayJSON("Video", "00"),
+        "videoRandom.json": replayJSON("VideoRandom", "zz"),
+    })
+
+    _, err := Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err == nil || !strings.Contains(err.Error(), "videoRandom.json is invalid") {
+        t.Errorf("Expected invalid replay error, got %v", err)
+    }
+    checkUnchanged(t, dir, testsConfigFile, replaysDir, oldTests)
+
+    // a missing replay also leaves everything as it was
+    server = startUpdateServer(t, []testdata.Test{videoTest}, map[string]string{"video.json": replayJSON("Video", "00")})
+    _, err = Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err == nil || !strings.Contains(err.Error(), "videoRandom.json") {
+        t.Errorf("Expected missing replay error, got %v", err)
+    }
+    checkUnchanged(t, dir, testsConfigFile, replaysDir, oldTests)
+}
+
+func TestRunFailedSwap(t *testing.T) {
+    dir := t.TempDir()
+    oldTests := []testdata.Test{videoTest, musicTest}
+    testsConfigFile, replaysDir := writeOldFiles(t, dir, oldTests)
+    server := startUpdateServer(t, []testdata.Test{callTest}, map[string]string{
+        "call.json": replayJSON("Call", "01"),
+        "callRandom.json": replayJSON("CallRandom", "fe"),
+    })
+
+    // the replays are swapped, but the tests list cannot be replaced
+    rename = func(oldPath string, newPath string) error {
+        if newPath == testsConfigFile {
+            return fmt.Errorf("disk full")
+        }
+        return os.Rename(oldPath, newPath)
+    }
+    defer func() { rename = os.Rename }()
+
+    _, err := Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err == nil || !strings.Contains(err.Error(), "nothing was updated") {
+        t.Errorf("Expected tests list error, got %v", err)
+    }
+    checkUnchanged(t, dir, testsConfigFile, replaysDir, oldTests)
+}
+
+func TestRunInvalidOldTestsList(t *testing.T) {
+    dir := t.TempDir()
+    testsConfigFile, replaysDir := writeOldFiles(t, dir, nil)
+    err := os.WriteFile(testsConfigFile, []byte("[{"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    server := startUpdateServer(t, []testdata.Test{callTest}, map[string]string{
+        "call.json": replayJSON("Call", "01"),
+        "callRandom.json": replayJSON("CallRandom", "fe"),
+    })
+
+    _, err = Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err == nil || !strings.Contains(err.Error(), "current tests list") {
+        t.Errorf("Expected error about the current tests list, got %v", err)
+    }
+
+    // a missing tests list is fine
+    os.Remove(testsConfigFile)
+    report, err := Run(server.URL + "/wehe", testsConfigFile, replaysDir)
+    if err != nil || strings.Join(report.Added, ",") != "call" {
+        t.Errorf("Unexpected update: %+v, %v", report, err)
+    }
+}
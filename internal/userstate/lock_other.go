@@ -0,0 +1,22 @@
+//go:build !unix
+
+package userstate
+
+import (
+    "os"
+)
+
+// File locking is only supported on Unix; on other platforms, concurrent clients must not share
+// the same user config file.
+// file: the file to lock
+// Returns any errors
+func lockFileExclusive(file *os.File) error {
+    return nil
+}
+
+// Releases the lock on the file.
+// file: the file to unlock
+// Returns any errors
+func unlockFile(file *os.File) error {
+    return nil
+}
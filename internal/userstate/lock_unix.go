@@ -0,0 +1,22 @@
+//go:build unix
+
+package userstate
+
+import (
+    "os"
+    "syscall"
+)
+
+// Blocks until an exclusive lock on the file is held.
+// file: the file to lock
+// Returns any errors
+func lockFileExclusive(file *os.File) error {
+    return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// Releases the lock on the file.
+// file: the file to unlock
+// Returns any errors
+func unlockFile(file *os.File) error {
+    return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,200 @@
+// Stores the user ID and the ID of the last test run by the user.
+package userstate
+
+import (
+    "bufio"
+    "fmt"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+const (
+    charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+    userIDLength = 10
+    cmdlineUserIDFirstChar = "@"
+    lockFileSuffix = ".lock" // the lock file sits next to the user config file
+)
+
+// The identity of the user running the tests.
+type UserState struct {
+    UserID string // the unique identifier for the user
+    TestID int // the ID of the last test that the user has run; 0 if no tests have been run
+}
+
+// Reads and writes the user state to a file. The file has the user ID on the first line and the
+// test ID on the second line. Updates are done while holding a lock on a separate lock file, so
+// multiple clients can share the same file.
+type Store struct {
+    userConfigFile string // path of the file containing user ID and test ID
+}
+
+// Creates a new Store.
+// userConfigFile: path of the file containing user ID and test ID
+// Returns a new Store
+func New(userConfigFile string) *Store {
+    return &Store{
+        userConfigFile: userConfigFile,
+    }
+}
+
+// Gets the user state. Creates the file with a new user ID if it does not exist or is invalid.
+// Returns the user state or any errors
+func (s *Store) Load() (UserState, error) {
+    return s.update(func(state UserState) UserState {
+        return state
+    })
+}
+
+// Increments the test ID and saves it. Should be called before each test so that concurrent
+// clients using the same file never use the same test ID.
+// Returns the user state containing the new test ID, or any errors
+func (s *Store) NextTestID() (UserState, error) {
+    return s.update(func(state UserState) UserState {
+        state.TestID += 1
+        return state
+    })
+}
+
+// Replaces the user ID with a new one and sets the test ID back to 0.
+// Returns the new user state or any errors
+func (s *Store) Reset() (UserState, error) {
+    return s.update(func(state UserState) UserState {
+        return UserState{
+            UserID: generateUserID(userIDLength),
+            TestID: 0,
+        }
+    })
+}
+
+// Reads the user state, changes it, and saves it, all while holding the lock.
+// change: function that takes the current user state and returns the user state to save
+// Returns the saved user state or any errors
+func (s *Store) update(change func(UserState) UserState) (UserState, error) {
+    err := os.MkdirAll(filepath.Dir(s.userConfigFile), 0755)
+    if err != nil {
+        return UserState{}, err
+    }
+    lockFile, err := os.OpenFile(s.userConfigFile + lockFileSuffix, os.O_CREATE|os.O_RDWR, 0644)
+    if err != nil {
+        return UserState{}, err
+    }
+    defer lockFile.Close()
+    err = lockFileExclusive(lockFile)
+    if err != nil {
+        return UserState{}, fmt.Errorf("Unable to lock %s: %v", lockFile.Name(), err)
+    }
+    defer unlockFile(lockFile)
+
+    state, ok := readUserConfig(s.userConfigFile)
+    newState := change(state)
+    if ok && newState == state {
+        return state, nil
+    }
+    err = writeUserConfig(s.userConfigFile, newState)
+    if err != nil {
+        return UserState{}, err
+    }
+    return newState, nil
+}
+
+// Retrieves the user ID and test ID from a file. Creates new ones if file cannot be opened or
+// config is invalid.
+// userConfigFile: path of the file containing user ID and test ID
+// Returns the user state and true if it was read from the file; false if a new one was created
+func readUserConfig(userConfigFile string) (UserState, bool) {
+    newState := UserState{
+        UserID: generateUserID(userIDLength),
+        TestID: 0,
+    }
+    file, err := os.Open(userConfigFile)
+    if err != nil {
+        return newState, false
+    }
+    defer file.Close()
+    fileScanner := bufio.NewScanner(file)
+    fileScanner.Split(bufio.ScanLines)
+
+    // first line of file is user ID
+    fileScanner.Scan()
+    userID := strings.TrimSpace(fileScanner.Text())
+    // second line of file is test ID
+    fileScanner.Scan()
+    testID, err := strconv.Atoi(strings.TrimSpace(fileScanner.Text()))
+    if err != nil || !isUserIDValid(userID) || !isTestIDValid(testID) {
+        return newState, false
+    }
+
+    return UserState{
+        UserID: userID,
+        TestID: testID,
+    }, true
+}
+
+// Saves the user ID and test ID to a file. The file is written to a temporary file first and then
+// renamed so that the file is never left half written.
+// userConfigFile: path of the file containing user ID and test ID
+// state: the user state to save
+// Returns any errors
+func writeUserConfig(userConfigFile string, state UserState) error {
+    tmpFile, err := os.CreateTemp(filepath.Dir(userConfigFile), "." + filepath.Base(userConfigFile) + "-")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmpFile.Name())
+
+    _, err = fmt.Fprintf(tmpFile, "%s\n%d\n", state.UserID, state.TestID)
+    if err == nil {
+        err = tmpFile.Sync()
+    }
+    closeErr := tmpFile.Close()
+    if err != nil {
+        return err
+    }
+    if closeErr != nil {
+        return closeErr
+    }
+    return os.Rename(tmpFile.Name(), userConfigFile)
+}
+
+// Checks if a user ID is valid. A user ID is valid if it contains ten alphanumeric characters.
+// However, unlike the iOS and Android apps, the first character is a '@' for the command line
+// client.
+// userID: the user ID to validate
+// Returns true if user ID is valid; false otherwise
+func isUserIDValid(userID string) bool {
+    if len(userID) == userIDLength && string(userID[0]) == cmdlineUserIDFirstChar {
+        for i := 1; i < userIDLength; i++ {
+            if !unicode.IsLetter(rune(userID[i])) && !unicode.IsNumber(rune(userID[i])) {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+// Checks if a test ID is valid. A test ID is valid if it is a non-negative integer.
+// testID: the test ID to validate
+// Returns true if test ID is valid; false otherwise
+func isTestIDValid(testID int) bool {
+    return testID > -1
+}
+
+// Generates a user ID of random alphanumeric characters, with the first character as a '@'.
+// length: total length of the user ID, including the '@'.
+// Returns the user ID
+func generateUserID(length int) string {
+    random := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+    result := make([]byte, length - 1)
+    for i := range result {
+        result[i] = charset[random.Intn(len(charset))]
+    }
+
+    return cmdlineUserIDFirstChar + string(result)
+}
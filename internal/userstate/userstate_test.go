@@ -0,0 +1,112 @@
+package userstate
+
+import (
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+)
+
+func TestLoad(t *testing.T) {
+    userConfigFile := filepath.Join(t.TempDir(), "user_info.txt")
+    store := New(userConfigFile)
+
+    // Test file is created on first run
+    state, err := store.Load()
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if !isUserIDValid(state.UserID) {
+        t.Errorf("Expected valid user ID, got '%s'", state.UserID)
+    }
+    if state.TestID != 0 {
+        t.Errorf("Expected 0, got %d", state.TestID)
+    }
+
+    // Test same user ID is read back
+    state2, err := store.Load()
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if state2 != state {
+        t.Errorf("Expected %v, got %v", state, state2)
+    }
+
+    // Test invalid file is replaced
+    err = os.WriteFile(userConfigFile, []byte("bad\n-5\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    state3, err := store.Load()
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if !isUserIDValid(state3.UserID) || state3.TestID != 0 {
+        t.Errorf("Expected new user state, got %v", state3)
+    }
+}
+
+func TestNextTestID(t *testing.T) {
+    userConfigFile := filepath.Join(t.TempDir(), "user_info.txt")
+    err := os.WriteFile(userConfigFile, []byte("@abcdefghi\n41\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Test concurrent clients never get the same test ID
+    numClients := 20
+    testIDs := make(chan int, numClients)
+    var wg sync.WaitGroup
+    for i := 0; i < numClients; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            state, err := New(userConfigFile).NextTestID()
+            if err != nil {
+                t.Errorf("Unexpected error: %v", err)
+                return
+            }
+            if state.UserID != "@abcdefghi" {
+                t.Errorf("Expected '@abcdefghi', got '%s'", state.UserID)
+            }
+            testIDs <- state.TestID
+        }()
+    }
+    wg.Wait()
+    close(testIDs)
+
+    seen := make(map[int]bool)
+    for testID := range testIDs {
+        if seen[testID] {
+            t.Errorf("Test ID %d was given out more than once", testID)
+        }
+        seen[testID] = true
+    }
+
+    state, err := New(userConfigFile).Load()
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if state.TestID != 41 + numClients {
+        t.Errorf("Expected %d, got %d", 41 + numClients, state.TestID)
+    }
+}
+
+func TestReset(t *testing.T) {
+    userConfigFile := filepath.Join(t.TempDir(), "user_info.txt")
+    err := os.WriteFile(userConfigFile, []byte("@abcdefghi\n7\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    state, err := New(userConfigFile).Reset()
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if state.UserID == "@abcdefghi" || !isUserIDValid(state.UserID) {
+        t.Errorf("Expected new valid user ID, got '%s'", state.UserID)
+    }
+    if state.TestID != 0 {
+        t.Errorf("Expected 0, got %d", state.TestID)
+    }
+}
@@ -2,66 +2,283 @@
 package main
 
 import (
+    "context"
+    "errors"
     "flag"
     "fmt"
     "os"
+    "os/signal"
+    "strings"
+    "syscall"
 
     "wehe-cmdline-client/internal/app"
     "wehe-cmdline-client/internal/config"
+    "wehe-cmdline-client/internal/importer"
+    "wehe-cmdline-client/internal/logging"
+    "wehe-cmdline-client/internal/testdata"
 )
 
 const (
     Version = "4.0"
+    defaultConfigFile = "res/config/config.ini"
+    interruptedExitCode = 130 // exit status when tests are stopped by SIGINT or SIGTERM
 )
 
 
-//TODO: handle interrupt cleanup and check whether client is too old
+//TODO: check whether client is too old
 func main() {
     // parse command line arguments
     replaySubcommand := flag.NewFlagSet("replay", flag.ExitOnError)
-    testNames := replaySubcommand.String("n", "", "name of the tests to run, comma-delimitated (required argument; see below for list of tests)")
-    configFile := replaySubcommand.String("c", "res/config/config.ini", "")
+    testNames := replaySubcommand.String("n", "", "name of the tests to run, comma-delimitated; category:<category> runs a whole category and all-ports runs all port tests (required argument; see below for list of tests)")
+    configFile := replaySubcommand.String("c", defaultConfigFile, "path to the .ini config file")
+    outputFormat := replaySubcommand.String("o", "text", "format of the test results written to stdout: text, json, or ndjson")
+    sideChannelPort := replaySubcommand.Int("side-channel-port", 0, "port of the side channel on the servers; overrides side_channel_port in the config file")
+    resultPort := replaySubcommand.Int("result-port", 0, "port of the results endpoint on the servers; overrides result_port in the config file")
+    replayPorts := replaySubcommand.String("replay-ports", "", "replay port remapping table in the format <replay port>:<server port>,... (ex. 80:8080,443:8443); overrides replay_ports in the config file")
+    ipFamily := replaySubcommand.String("ip-family", "", "address family to connect to the servers with: auto, v4, or v6; overrides ip_family in the config file")
+    dualStack := replaySubcommand.Bool("dual-stack", false, "run each test twice, once over IPv4 and once over IPv6, and compare the results; overrides dual_stack in the config file")
+    mlabCountry := replaySubcommand.String("mlab-country", "", "ISO 3166-1 country code to locate MLab servers in (ex. US); overrides mlab_country in the config file")
+    mlabRegion := replaySubcommand.String("mlab-region", "", "ISO 3166-2 region code to locate MLab servers in (ex. US-NY); overrides mlab_region in the config file")
+    mlabLatitude := replaySubcommand.String("mlab-lat", "", "latitude to locate MLab servers near; must be given with -mlab-lon; overrides mlab_lat in the config file")
+    mlabLongitude := replaySubcommand.String("mlab-lon", "", "longitude to locate MLab servers near; must be given with -mlab-lat; overrides mlab_lon in the config file")
+    mlabSite := replaySubcommand.String("mlab-site", "", "MLab site to run the tests on (ex. lga03); overrides mlab_site in the config file")
+    mlabAPIKey := replaySubcommand.String("mlab-key", "", "API key for priority access to the MLab locate API; overrides mlab_api_key in the config file")
 
-    //updateSubcommand := flag.NewFlagSet("update", flag.ExitOnError)
-    // TODO: finish update subcommand
+    updateSubcommand := flag.NewFlagSet("update", flag.ExitOnError)
+    updateConfigFile := updateSubcommand.String("c", defaultConfigFile, "path to the .ini config file")
+    updateURL := updateSubcommand.String("u", "", "URL of the update server; overrides update_url in the config file")
+
+    userSubcommand := flag.NewFlagSet("user", flag.ExitOnError)
+    userConfigFile := userSubcommand.String("c", defaultConfigFile, "path to the .ini config file")
+    resetUser := userSubcommand.Bool("reset", false, "generate a new user ID and set the test ID back to 0")
+
+    listSubcommand := flag.NewFlagSet("list", flag.ExitOnError)
+    listConfigFile := listSubcommand.String("c", defaultConfigFile, "path to the .ini config file")
+    listCategory := listSubcommand.String("category", "", "only list tests in this category: " + strings.ToLower(strings.Join(testdata.Categories, ", ")))
+    listLanguage := listSubcommand.String("lang", "", "only list tests shown to users of this language: " + testdata.LanguageEnglish + " or " + testdata.LanguageFrench)
+
+    validateSubcommand := flag.NewFlagSet("validate", flag.ExitOnError)
+    validateConfigFile := validateSubcommand.String("c", defaultConfigFile, "path to the .ini config file")
+    validateReplaysDir := validateSubcommand.String("d", "", "replays directory to check; overrides replays_dir in the config file")
+
+    importSubcommand := flag.NewFlagSet("import", flag.ExitOnError)
+    importConfigFile := importSubcommand.String("c", defaultConfigFile, "path to the .ini config file")
+    importCaptureFile := importSubcommand.String("i", "", "pcap or pcapng file to import the replays from (required argument)")
+    importName := importSubcommand.String("name", "", "alphanumeric name of the app, used to name the replays (required argument)")
+    importServerPort := importSubcommand.Int("server-port", 0, "port of the server whose traffic is imported; the TCP flow with the most data or all the UDP flows to this port are used")
+    importFlow := importSubcommand.String("flow", "", "the flow to import in the format <client ip>:<client port>-<server ip>:<server port>; overrides -server-port")
+    importProtocol := importSubcommand.String("protocol", "", "protocol of the traffic to import: tcp or udp; by default, whichever protocol the flows use")
+    importOverwrite := importSubcommand.Bool("f", false, "overwrite replay files that already exist, and a test in the tests list that was not imported")
+    importAddTest := importSubcommand.Bool("add-test", false, "add a test that runs the imported replays to the tests list")
+    importDisplayName := importSubcommand.String("display-name", "", "name of the added test shown to the user; defaults to -name")
+    importCategory := importSubcommand.String("category", testdata.CategoryVideo, "category of the added test: " + strings.ToLower(strings.Join(testdata.Categories, ", ")))
+
+    subcommands := []*flag.FlagSet{replaySubcommand, listSubcommand, updateSubcommand, userSubcommand, validateSubcommand, importSubcommand}
+    for _, subcommand := range subcommands {
+        subcommand.Usage = func() {
+            printUsage(os.Stderr, subcommands)
+        }
+    }
 
     for _, arg := range os.Args {
         if arg == "-h" || arg == "--help" {
-            //print usage
+            printUsage(os.Stdout, subcommands)
             os.Exit(0)
         }
         if arg == "-v" || arg == "--version" {
-            //print version
+            printVersion(os.Stdout)
             os.Exit(0)
         }
     }
 
     if len(os.Args) < 2 {
-        fmt.Println("\"replay\" or \"update\" command expected")
+        fmt.Fprintln(os.Stderr, "\"replay\", \"list\", \"update\", \"user\", \"validate\", or \"import\" command expected")
+        printUsage(os.Stderr, subcommands)
         os.Exit(1)
     }
 
     switch os.Args[1] {
     case "replay":
         replaySubcommand.Parse(os.Args[2:])
+    case "list":
+        listSubcommand.Parse(os.Args[2:])
+        runList(*listConfigFile, *listCategory, *listLanguage)
+        os.Exit(0)
     case "update":
+        updateSubcommand.Parse(os.Args[2:])
+        runUpdate(*updateConfigFile, *updateURL)
+        os.Exit(0)
+    case "user":
+        userSubcommand.Parse(os.Args[2:])
+        runUser(*userConfigFile, *resetUser)
+        os.Exit(0)
+    case "validate":
+        validateSubcommand.Parse(os.Args[2:])
+        runValidate(*validateConfigFile, *validateReplaysDir)
+        os.Exit(0)
+    case "import":
+        importSubcommand.Parse(os.Args[2:])
+        options := importer.Options{
+            CaptureFile: *importCaptureFile,
+            Protocol: strings.ToLower(*importProtocol),
+            ServerPort: *importServerPort,
+            Flow: *importFlow,
+            Name: *importName,
+            Overwrite: *importOverwrite,
+        }
+        runImport(*importConfigFile, options, *importAddTest, *importDisplayName, *importCategory)
+        os.Exit(0)
     default:
-        fmt.Println("\"replay\" or \"update\" command expected")
+        fmt.Fprintln(os.Stderr, "\"replay\", \"list\", \"update\", \"user\", \"validate\", or \"import\" command expected")
+        printUsage(os.Stderr, subcommands)
         os.Exit(1)
     }
 
     // read in wehe configs
     config, err := config.New(testNames, configFile)
     if err != nil {
-        fmt.Printf("Unable to process configuration file %s: %s\n", *configFile, err)
+        fmt.Fprintf(os.Stderr, "Unable to process configuration file %s: %s\n", *configFile, err)
+        os.Exit(1)
+    }
+    config.OutputFormat = *outputFormat
+    err = config.OverridePorts(*sideChannelPort, *resultPort, *replayPorts)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+    if *dualStack {
+        config.DualStack = true
+    }
+    err = config.OverrideIPFamily(*ipFamily)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+    err = config.OverrideMLabLocate(*mlabCountry, *mlabRegion, *mlabLatitude, *mlabLongitude, *mlabSite, *mlabAPIKey)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
         os.Exit(1)
     }
 
+    // stop the tests and clean up the connections to the servers on SIGINT or SIGTERM
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    go func() {
+        // a second signal kills the client right away
+        <-ctx.Done()
+        stop()
+    }()
+
     // run the app
-    err = app.Run(config, Version)
+    logger := logging.New(logging.Level(config.LogLevel), os.Stderr)
+    err = app.Run(ctx, config, Version, logger)
+    if errors.Is(err, app.ErrInterrupted) {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(interruptedExitCode)
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
+
+// Runs the list subcommand. Exits if there are any errors.
+// configFile: path to the .ini config file
+// category: only list tests in this category; empty to list all categories
+// language: only list tests shown to users of this language; empty to list all languages
+func runList(configFile string, category string, language string) {
+    cfg, err := config.Load(configFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Unable to process configuration file %s: %s\n", configFile, err)
+        os.Exit(1)
+    }
+
+    err = app.List(cfg, category, language)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
+
+// Runs the update subcommand. Exits if there are any errors.
+// configFile: path to the .ini config file
+// updateURL: URL of the update server; if empty, the URL in the config file is used
+func runUpdate(configFile string, updateURL string) {
+    cfg, err := config.Load(configFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Unable to process configuration file %s: %s\n", configFile, err)
+        os.Exit(1)
+    }
+    if updateURL != "" {
+        cfg.UpdateURL = updateURL
+    }
+
+    logger := logging.New(logging.Level(cfg.LogLevel), os.Stderr)
+    err = app.Update(cfg, logger)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
+
+// Runs the user subcommand. Exits if there are any errors.
+// configFile: path to the .ini config file
+// reset: true if the user ID and test ID should be reset
+func runUser(configFile string, reset bool) {
+    cfg, err := config.Load(configFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Unable to process configuration file %s: %s\n", configFile, err)
+        os.Exit(1)
+    }
+
+    err = app.User(cfg, reset)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
+
+// Runs the validate subcommand. Exits if there are any errors.
+// configFile: path to the .ini config file
+// replaysDir: replays directory to check; if empty, the directory in the config file is used
+func runValidate(configFile string, replaysDir string) {
+    cfg, err := config.Load(configFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Unable to process configuration file %s: %s\n", configFile, err)
+        os.Exit(1)
+    }
+    if replaysDir != "" {
+        cfg.ReplaysDir = replaysDir
+    }
+
+    err = app.Validate(cfg)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
+
+// Runs the import subcommand. Exits if there are any errors.
+// configFile: path to the .ini config file
+// options: what to import from the capture file
+// addTest: true if a test for the imported replays should be added to the tests list
+// displayName: name of the added test shown to the user
+// category: category of the added test
+func runImport(configFile string, options importer.Options, addTest bool, displayName string, category string) {
+    if options.CaptureFile == "" || options.Name == "" {
+        fmt.Fprintln(os.Stderr, "The -i and -name flags are required to import replays.")
+        os.Exit(1)
+    }
+    cfg, err := config.Load(configFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Unable to process configuration file %s: %s\n", configFile, err)
+        os.Exit(1)
+    }
+
+    logger := logging.New(logging.Level(cfg.LogLevel), os.Stderr)
+    err = app.Import(cfg, options, addTest, displayName, category, logger)
     if err != nil {
-        fmt.Println(err)
+        fmt.Fprintln(os.Stderr, err)
         os.Exit(1)
     }
-    println("it worked :D")
 }
@@ -0,0 +1,116 @@
+// Prints the usage, tests list, and version of the Wehe command line client.
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "runtime/debug"
+    "text/tabwriter"
+
+    "wehe-cmdline-client/internal/config"
+    "wehe-cmdline-client/internal/testdata"
+)
+
+const (
+    defaultTestsConfigFile = "res/config/tests_list.json" // used if the config file cannot be read
+)
+
+// descriptions of the subcommands, shown in the usage
+var subcommandDescriptions = map[string]string{
+    "replay": "run tests to check for differentiation",
+    "list": "list the tests that can be run",
+    "update": "download the latest tests list and replay files",
+    "user": "show or reset the user ID and test ID",
+    "validate": "check the replay files in the replays directory",
+    "import": "create replays from a pcap or pcapng capture of an app",
+}
+
+// Prints how to use the client, including the subcommands, their flags, and the tests that can be
+// run.
+// w: where to print the usage
+// subcommands: the flag sets of the subcommands
+func printUsage(w io.Writer, subcommands []*flag.FlagSet) {
+    fmt.Fprintf(w, "Wehe command line client %s\n\n", Version)
+    fmt.Fprintf(w, "Usage:\n  %s <command> [flags]\n  %s -h | --help\n  %s -v | --version\n\n", os.Args[0], os.Args[0], os.Args[0])
+
+    fmt.Fprintln(w, "Commands:")
+    tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+    for _, subcommand := range subcommands {
+        fmt.Fprintf(tw, "  %s\t%s\n", subcommand.Name(), subcommandDescriptions[subcommand.Name()])
+    }
+    tw.Flush()
+
+    for _, subcommand := range subcommands {
+        fmt.Fprintf(w, "\nFlags for %s:\n", subcommand.Name())
+        subcommand.SetOutput(w)
+        subcommand.PrintDefaults()
+        subcommand.SetOutput(nil)
+    }
+
+    fmt.Fprintln(w)
+    printTests(w, getTestsConfigFile())
+}
+
+// Prints the tests that can be run.
+// w: where to print the tests
+// testsConfigFile: the configuration file name containing information about all the tests
+func printTests(w io.Writer, testsConfigFile string) {
+    tests, err := testdata.LoadTests(testsConfigFile)
+    if err != nil {
+        fmt.Fprintf(w, "Unable to list tests from %s: %v\n", testsConfigFile, err)
+        return
+    }
+
+    fmt.Fprintf(w, "Tests (from %s):\n", testsConfigFile)
+    tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "  NAME\tCATEGORY\tDISPLAY NAME")
+    for _, test := range tests {
+        fmt.Fprintf(tw, "  %s\t%s\t%s\n", test.Selector(), test.Category, test.Name)
+    }
+    tw.Flush()
+}
+
+// Gets the tests list from the config file given with -c, or the default config file if -c is not
+// given.
+// Returns the path to the tests list
+func getTestsConfigFile() string {
+    configFile := defaultConfigFile
+    for i, arg := range os.Args {
+        if (arg == "-c" || arg == "--c") && i + 1 < len(os.Args) {
+            configFile = os.Args[i + 1]
+        }
+    }
+    cfg, err := config.Load(configFile)
+    if err != nil {
+        return defaultTestsConfigFile
+    }
+    return cfg.TestsConfigFile
+}
+
+// Prints the version of the client and the build information embedded by the Go toolchain.
+// w: where to print the version
+func printVersion(w io.Writer) {
+    fmt.Fprintf(w, "Wehe command line client %s\n", Version)
+
+    buildInfo, ok := debug.ReadBuildInfo()
+    if !ok {
+        return
+    }
+    fmt.Fprintf(w, "Go version: %s\n", buildInfo.GoVersion)
+    settings := make(map[string]string)
+    for _, setting := range buildInfo.Settings {
+        settings[setting.Key] = setting.Value
+    }
+    if revision, ok := settings["vcs.revision"]; ok {
+        if settings["vcs.modified"] == "true" {
+            revision += " (modified)"
+        }
+        fmt.Fprintf(w, "VCS revision: %s\n", revision)
+    }
+    if vcsTime, ok := settings["vcs.time"]; ok {
+        fmt.Fprintf(w, "VCS time: %s\n", vcsTime)
+    }
+    fmt.Fprintf(w, "Platform: %s/%s\n", settings["GOOS"], settings["GOARCH"])
+}